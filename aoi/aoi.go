@@ -0,0 +1,260 @@
+package aoi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// 形の種類
+const (
+	ShapeRect = "rect"
+	ShapePolygon = "polygon"
+	ShapeCircle = "circle"
+	ShapeEllipse = "ellipse"
+)
+
+// 座標の種類
+const (
+	SpacePage = "page" // ページ(document)の座標。ページが表示を報告していなければ画面の座標と同じです
+	SpaceScreen = "screen" // 画面の座標(ブラウザの URL 欄等、ページの外のもの)
+)
+
+// 画面上の一つの点(ピクセル)
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// 一つの見ていたかどうかを確認する領域 (AOI: Area Of Interest)
+// 形によって使う値が違います。
+//   rect: x, y, width, height (shape を書かなければ rect です)
+//   polygon: points (3点以上)
+//   circle: cx, cy, r
+//   ellipse: cx, cy, rx, ry
+// padding を書くと、その分だけ外側も領域に含めます。
+// space が "screen" なら画面の座標で、そうでなければページ(document)の座標で書きます。
+type Area struct {
+	Name string `json:"name"`
+	Shape string `json:"shape,omitempty"`
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Width float64 `json:"width"`
+	Height float64 `json:"height"`
+	Points []Point `json:"points,omitempty"`
+	CX float64 `json:"cx,omitempty"`
+	CY float64 `json:"cy,omitempty"`
+	R float64 `json:"r,omitempty"`
+	RX float64 `json:"rx,omitempty"`
+	RY float64 `json:"ry,omitempty"`
+	Padding float64 `json:"padding,omitempty"` // 外側に広げる幅(ピクセル)
+	Space string `json:"space,omitempty"` // "page"(default) か "screen"
+}
+
+// 一つの視線の位置
+// ページの座標が分からない時は、画面の座標をそのままページの座標とします。
+type Gaze struct {
+	X float64 // 画面の座標
+	Y float64
+	PageX float64 // ページ(document)の座標
+	PageY float64
+	OnPage bool // 視線がページの見えている所(viewport)の中にあるか
+}
+
+// ページの座標が分からない時の視線の位置を作ります。
+func ScreenGaze(x float64, y float64) Gaze {
+	return Gaze{X: x, Y: y, PageX: x, PageY: y, OnPage: true}
+}
+
+// 設定に誤りが無いかを確認します。
+func (a *Area) Validate() error {
+	switch a.Shape {
+	case "", ShapeRect:
+		if a.Width < 0 || a.Height < 0 {
+			return errors.New(fmt.Sprintf("aoi %s: width and height must not be negative", a.Name))
+		}
+	case ShapePolygon:
+		if len(a.Points) < 3 {
+			return errors.New(fmt.Sprintf("aoi %s: polygon needs 3 or more points", a.Name))
+		}
+	case ShapeCircle:
+		if a.R <= 0 {
+			return errors.New(fmt.Sprintf("aoi %s: circle needs r", a.Name))
+		}
+	case ShapeEllipse:
+		if a.RX <= 0 || a.RY <= 0 {
+			return errors.New(fmt.Sprintf("aoi %s: ellipse needs rx and ry", a.Name))
+		}
+	default:
+		return errors.New(fmt.Sprintf("aoi %s: unknown shape: %s", a.Name, a.Shape))
+	}
+	if a.Padding < 0 {
+		return errors.New(fmt.Sprintf("aoi %s: padding must not be negative", a.Name))
+	}
+	switch a.Space {
+	case "", SpacePage, SpaceScreen:
+	default:
+		return errors.New(fmt.Sprintf("aoi %s: unknown space: %s", a.Name, a.Space))
+	}
+	return nil
+}
+
+// 視線が領域の中にあるかどうかを、領域の座標の種類に合わせて返します。
+// ページの座標の領域は、視線がページの見えている所の外にあれば中にありません。
+func (a *Area) ContainsGaze(g Gaze) bool {
+	if a.Space == SpaceScreen {
+		return a.Contains(g.X, g.Y)
+	}
+	return g.OnPage && a.Contains(g.PageX, g.PageY)
+}
+
+// (x, y) が領域(padding を含みます)の中にあるかどうかを返します。
+func (a *Area) Contains(x float64, y float64) bool {
+	p := a.Padding
+	switch a.Shape {
+	case ShapePolygon:
+		if insidePolygon(a.Points, x, y) {
+			return true
+		}
+		return p > 0 && distanceToPolygon(a.Points, x, y) <= p
+	case ShapeCircle:
+		return math.Hypot(x - a.CX, y - a.CY) <= a.R + p
+	case ShapeEllipse:
+		dx := (x - a.CX) / (a.RX + p)
+		dy := (y - a.CY) / (a.RY + p)
+		return dx * dx + dy * dy <= 1.0
+	}
+	return x >= a.X - p && x <= a.X + a.Width + p && y >= a.Y - p && y <= a.Y + a.Height + p
+}
+
+// 領域(padding を含みます)を囲む長方形を返します。
+func (a *Area) Bounds() (minX float64, minY float64, maxX float64, maxY float64) {
+	p := a.Padding
+	switch a.Shape {
+	case ShapePolygon:
+		minX, minY = a.Points[0].X, a.Points[0].Y
+		maxX, maxY = minX, minY
+		for _, point := range a.Points {
+			minX = math.Min(minX, point.X)
+			minY = math.Min(minY, point.Y)
+			maxX = math.Max(maxX, point.X)
+			maxY = math.Max(maxY, point.Y)
+		}
+		return minX - p, minY - p, maxX + p, maxY + p
+	case ShapeCircle:
+		return a.CX - a.R - p, a.CY - a.R - p, a.CX + a.R + p, a.CY + a.R + p
+	case ShapeEllipse:
+		return a.CX - a.RX - p, a.CY - a.RY - p, a.CX + a.RX + p, a.CY + a.RY + p
+	}
+	return a.X - p, a.Y - p, a.X + a.Width + p, a.Y + a.Height + p
+}
+
+// (x, y) が多角形の中にあるかどうかを返します(even-odd rule)。
+func insidePolygon(points []Point, x float64, y float64) bool {
+	inside := false
+	j := len(points) - 1
+	for i := range points {
+		a := points[i]
+		b := points[j]
+		if (a.Y > y) != (b.Y > y) && x < (b.X - a.X) * (y - a.Y) / (b.Y - a.Y) + a.X {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// (x, y) から多角形の辺までの一番近い距離を返します。
+func distanceToPolygon(points []Point, x float64, y float64) float64 {
+	result := math.Inf(1)
+	j := len(points) - 1
+	for i := range points {
+		result = math.Min(result, distanceToSegment(points[j], points[i], x, y))
+		j = i
+	}
+	return result
+}
+
+// (x, y) から線分 a-b までの距離を返します。
+func distanceToSegment(a Point, b Point, x float64, y float64) float64 {
+	dx := b.X - a.X
+	dy := b.Y - a.Y
+	length2 := dx * dx + dy * dy
+	t := 0.0
+	if length2 > 0 {
+		t = math.Max(0, math.Min(1, ((x - a.X) * dx + (y - a.Y) * dy) / length2))
+	}
+	return math.Hypot(x - (a.X + t * dx), y - (a.Y + t * dy))
+}
+
+// 名前の付いた AOI の組
+// URL が UrlPattern (正規表現) に合うページを表示している間だけ使われます。
+type Set struct {
+	Name string `json:"name"`
+	UrlPattern string `json:"url pattern"`
+	Targets []*Area `json:"targets"`
+	pattern *regexp.Regexp
+}
+
+// UrlPattern を読み込んで、AOI に誤りが無いかを確認します。
+func (s *Set) Compile() error {
+	pattern, err := regexp.Compile(s.UrlPattern)
+	if err != nil {
+		return errors.New(fmt.Sprintf("aoi set %s: url pattern error: %s", s.Name, err))
+	}
+	err = ValidateAreas(s.Targets)
+	if err != nil {
+		return errors.New(fmt.Sprintf("aoi set %s: %s", s.Name, err))
+	}
+	s.pattern = pattern
+	return nil
+}
+
+// url がこの組の対象のページかどうかを返します。
+func (s *Set) MatchUrl(url string) bool {
+	if s.pattern != nil {
+		return s.pattern.MatchString(url)
+	}
+	matched, err := regexp.MatchString(s.UrlPattern, url)
+	return err == nil && matched
+}
+
+// url に合う最初の組を返します。無ければ nil を返します。
+func FindSet(setList []*Set, url string) *Set {
+	for i := range setList {
+		if setList[i] != nil && setList[i].MatchUrl(url) {
+			return setList[i]
+		}
+	}
+	return nil
+}
+
+// nil を除いた AOI のうち、space の座標で書かれたものを返します。
+func InSpace(areaList []*Area, space string) []*Area {
+	result := []*Area{}
+	for i := range areaList {
+		a := areaList[i]
+		if a == nil {
+			continue
+		}
+		if (a.Space == SpaceScreen) == (space == SpaceScreen) {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// nil を除いた全ての AOI に誤りが無いかを確認します。
+func ValidateAreas(areaList []*Area) error {
+	for i := range areaList {
+		if areaList[i] == nil {
+			continue
+		}
+		err := areaList[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package aoi
+
+import (
+	"testing"
+)
+
+var triangle = []Point{{0, 0}, {100, 0}, {0, 100}}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name string
+		area Area
+		x float64
+		y float64
+		want bool
+	}{
+		{"rect inside", Area{X: 10, Y: 10, Width: 100, Height: 50}, 50, 30, true},
+		{"rect edge", Area{X: 10, Y: 10, Width: 100, Height: 50}, 110, 60, true},
+		{"rect outside", Area{X: 10, Y: 10, Width: 100, Height: 50}, 111, 30, false},
+		{"rect padding", Area{X: 10, Y: 10, Width: 100, Height: 50, Padding: 5}, 115, 30, true},
+		{"explicit rect", Area{Shape: ShapeRect, X: 0, Y: 0, Width: 10, Height: 10}, 5, 5, true},
+		{"polygon inside", Area{Shape: ShapePolygon, Points: triangle}, 20, 20, true},
+		{"polygon outside", Area{Shape: ShapePolygon, Points: triangle}, 60, 60, false},
+		{"polygon padding", Area{Shape: ShapePolygon, Points: triangle, Padding: 10}, 55, 55, true},
+		{"polygon padding too far", Area{Shape: ShapePolygon, Points: triangle, Padding: 5}, 60, 60, false},
+		{"circle inside", Area{Shape: ShapeCircle, CX: 100, CY: 100, R: 50}, 130, 140, true},
+		{"circle outside", Area{Shape: ShapeCircle, CX: 100, CY: 100, R: 50}, 140, 140, false},
+		{"circle padding", Area{Shape: ShapeCircle, CX: 100, CY: 100, R: 50, Padding: 10}, 100, 160, true},
+		{"ellipse inside", Area{Shape: ShapeEllipse, CX: 100, CY: 100, RX: 80, RY: 20}, 170, 100, true},
+		{"ellipse outside", Area{Shape: ShapeEllipse, CX: 100, CY: 100, RX: 80, RY: 20}, 100, 130, false},
+		{"ellipse padding", Area{Shape: ShapeEllipse, CX: 100, CY: 100, RX: 80, RY: 20, Padding: 10}, 100, 130, true},
+	}
+	for _, test := range tests {
+		got := test.area.Contains(test.x, test.y)
+		if got != test.want {
+			t.Errorf("%s: Contains(%f, %f) = %v, want %v", test.name, test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestContainsGaze(t *testing.T) {
+	page := Area{X: 0, Y: 1000, Width: 100, Height: 100}
+	screen := Area{X: 0, Y: 0, Width: 100, Height: 100, Space: SpaceScreen}
+	scrolled := Gaze{X: 50, Y: 50, PageX: 50, PageY: 1050, OnPage: true}
+	outsideViewport := Gaze{X: 50, Y: 50, PageX: 50, PageY: 1050, OnPage: false}
+	tests := []struct {
+		name string
+		area Area
+		gaze Gaze
+		want bool
+	}{
+		{"page area in page coordinates", page, scrolled, true},
+		{"page area outside the viewport", page, outsideViewport, false},
+		{"screen area ignores the page", screen, outsideViewport, true},
+		{"screen gaze", page, ScreenGaze(50, 50), false},
+	}
+	for _, test := range tests {
+		got := test.area.ContainsGaze(test.gaze)
+		if got != test.want {
+			t.Errorf("%s: ContainsGaze(%+v) = %v, want %v", test.name, test.gaze, got, test.want)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		area Area
+		want [4]float64
+	}{
+		{"rect", Area{X: 10, Y: 20, Width: 30, Height: 40, Padding: 1}, [4]float64{9, 19, 41, 61}},
+		{"polygon", Area{Shape: ShapePolygon, Points: triangle}, [4]float64{0, 0, 100, 100}},
+		{"circle", Area{Shape: ShapeCircle, CX: 50, CY: 50, R: 10, Padding: 5}, [4]float64{35, 35, 65, 65}},
+		{"ellipse", Area{Shape: ShapeEllipse, CX: 50, CY: 50, RX: 20, RY: 10}, [4]float64{30, 40, 70, 60}},
+	}
+	for _, test := range tests {
+		minX, minY, maxX, maxY := test.area.Bounds()
+		got := [4]float64{minX, minY, maxX, maxY}
+		if got != test.want {
+			t.Errorf("%s: Bounds() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		area Area
+		ok bool
+	}{
+		{"rect", Area{Width: 10, Height: 10}, true},
+		{"negative width", Area{Width: -1, Height: 10}, false},
+		{"polygon", Area{Shape: ShapePolygon, Points: triangle}, true},
+		{"polygon with two points", Area{Shape: ShapePolygon, Points: triangle[:2]}, false},
+		{"circle without r", Area{Shape: ShapeCircle}, false},
+		{"ellipse without ry", Area{Shape: ShapeEllipse, RX: 10}, false},
+		{"unknown shape", Area{Shape: "star"}, false},
+		{"negative padding", Area{Width: 10, Height: 10, Padding: -1}, false},
+		{"screen", Area{Width: 10, Height: 10, Space: SpaceScreen}, true},
+		{"unknown space", Area{Width: 10, Height: 10, Space: "window"}, false},
+	}
+	for _, test := range tests {
+		err := test.area.Validate()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Validate() = %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+}
+
+func TestFindSet(t *testing.T) {
+	setList := []*Set{
+		{Name: "top", UrlPattern: "^/$"},
+		{Name: "article", UrlPattern: "^/articles/[0-9]+"},
+		{Name: "all", UrlPattern: ""},
+	}
+	for _, s := range setList {
+		err := s.Compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		url string
+		want string
+	}{
+		{"/", "top"},
+		{"/articles/12?page=2", "article"},
+		{"/about.html", "all"},
+	}
+	for _, test := range tests {
+		got := FindSet(setList, test.url)
+		if got == nil || got.Name != test.want {
+			t.Errorf("FindSet(%s) = %+v, want %s", test.url, got, test.want)
+		}
+	}
+	if FindSet(setList[:2], "/about.html") != nil {
+		t.Errorf("FindSet should return nil when no set matches")
+	}
+	bad := &Set{Name: "bad", UrlPattern: "("}
+	if bad.Compile() == nil {
+		t.Errorf("Compile() should fail on a broken url pattern")
+	}
+}
+
+func TestInSpace(t *testing.T) {
+	areaList := []*Area{{Name: "a"}, nil, {Name: "b", Space: SpaceScreen}, {Name: "c", Space: SpacePage}}
+	names := func(list []*Area) string {
+		result := ""
+		for _, a := range list {
+			result += a.Name
+		}
+		return result
+	}
+	if got := names(InSpace(areaList, SpacePage)); got != "ac" {
+		t.Errorf("InSpace(page) = %s, want ac", got)
+	}
+	if got := names(InSpace(areaList, SpaceScreen)); got != "b" {
+		t.Errorf("InSpace(screen) = %s, want b", got)
+	}
+}
@@ -0,0 +1,107 @@
+package aoi
+
+// 一つの AOI をどう見ていたかの指標
+// 時刻と時間はすべてミリ秒で、時刻は MetricsCounter に渡した時刻と同じ基準です。
+type Metrics struct {
+	Hit bool `json:"hit"` // 一度でも見ていたかどうか
+	DwellMsec float64 `json:"dwell msec"` // 見ていた時間の合計
+	SampleCount int `json:"sample count"` // 中にあった視線のサンプルの数
+	FixationCount int `json:"fixation count"` // 中にあった注視の数
+	TimeToFirstFixationMsec float64 `json:"time to first fixation msec"` // 窓の始まりから最初の注視まで(注視が無ければ -1)
+	FirstFixationMsec float64 `json:"first fixation msec"` // 最初の注視の長さ
+	RevisitCount int `json:"revisit count"` // 一度離れてから、また注視した回数
+	LastLook float64 `json:"last look"` // 最後に見ていた時刻(見ていなければ 0)
+}
+
+// 視線のサンプルや注視を順に受け取って、AOI ごとの指標を数えるもの
+// サンプルと注視は、それぞれ時刻の順に渡します。
+type MetricsCounter struct {
+	Start float64 // 窓の始まりの時刻
+	DwellFromFixations bool // true なら注視だけで hit と dwell を数えます(false ならサンプルで数えます)
+	result map[string]*Metrics
+	lastFixationIn map[string]bool // 直前の注視がその AOI の中だったかどうか
+}
+
+func NewMetricsCounter(start float64, dwellFromFixations bool) *MetricsCounter {
+	return &MetricsCounter{
+		Start: start,
+		DwellFromFixations: dwellFromFixations,
+		result: map[string]*Metrics{},
+		lastFixationIn: map[string]bool{},
+	}
+}
+
+func (c *MetricsCounter) metrics(name string) *Metrics {
+	m, ok := c.result[name]
+	if !ok {
+		m = &Metrics{TimeToFirstFixationMsec: -1}
+		c.result[name] = m
+	}
+	return m
+}
+
+// 見ていなくても結果に出てくるように、targetList の AOI を登録します。
+func (c *MetricsCounter) AddTargets(targetList []*Area) {
+	for _, area := range targetList {
+		if area != nil {
+			c.metrics(area.Name)
+		}
+	}
+}
+
+// 時刻 t から duration の間 gaze の所を見ていたサンプルを一つ数えます。
+func (c *MetricsCounter) AddSample(targetList []*Area, t float64, duration float64, gaze Gaze) {
+	for _, area := range targetList {
+		if area == nil {
+			continue
+		}
+		m := c.metrics(area.Name)
+		if !area.ContainsGaze(gaze) {
+			continue
+		}
+		m.SampleCount += 1
+		if !c.DwellFromFixations {
+			m.Hit = true
+			m.DwellMsec += duration
+			if t > m.LastLook {
+				m.LastLook = t
+			}
+		}
+	}
+}
+
+// 時刻 start から end まで gaze の所を注視していたものを一つ数えます。
+// duration は注視の長さです(時刻の基準と tracker の時計が違うことがあるので別に渡します)。
+func (c *MetricsCounter) AddFixation(targetList []*Area, start float64, end float64, duration float64, gaze Gaze) {
+	inside := map[string]bool{}
+	for _, area := range targetList {
+		if area == nil {
+			continue
+		}
+		m := c.metrics(area.Name)
+		if !area.ContainsGaze(gaze) {
+			continue
+		}
+		inside[area.Name] = true
+		if m.FixationCount <= 0 {
+			m.TimeToFirstFixationMsec = start - c.Start
+			m.FirstFixationMsec = duration
+		} else if !c.lastFixationIn[area.Name] {
+			m.RevisitCount += 1
+		}
+		m.FixationCount += 1
+		if c.DwellFromFixations {
+			m.Hit = true
+			m.DwellMsec += duration
+			if end > m.LastLook {
+				m.LastLook = end
+			}
+		}
+	}
+	c.lastFixationIn = inside
+}
+
+// AOI の名前ごとの指標を返します。
+func (c *MetricsCounter) Result() map[string]*Metrics {
+	return c.result
+}
@@ -0,0 +1,81 @@
+package aoi
+
+import (
+	"testing"
+)
+
+func TestMetricsCounter(t *testing.T) {
+	a := &Area{Name: "a", X: 0, Y: 0, Width: 100, Height: 100}
+	b := &Area{Name: "b", X: 200, Y: 0, Width: 100, Height: 100}
+	c := &Area{Name: "c", X: 0, Y: 500, Width: 100, Height: 100}
+	targetList := []*Area{a, b, nil}
+
+	// 窓は時刻 1000 から始まります。サンプルは a, a, b、注視は a, b, a の順です。
+	count := func(dwellFromFixations bool) map[string]*Metrics {
+		counter := NewMetricsCounter(1000, dwellFromFixations)
+		counter.AddTargets([]*Area{a, b, c})
+		counter.AddSample(targetList, 1000, 33, ScreenGaze(50, 50))
+		counter.AddSample(targetList, 1033, 33, ScreenGaze(60, 50))
+		counter.AddSample(targetList, 1066, 33, ScreenGaze(250, 50))
+		counter.AddSample(targetList, 1099, 33, ScreenGaze(150, 50))
+		counter.AddFixation(targetList, 1100, 1300, 200, ScreenGaze(50, 50))
+		counter.AddFixation(targetList, 1350, 1450, 100, ScreenGaze(250, 50))
+		counter.AddFixation(targetList, 1500, 1600, 100, ScreenGaze(50, 60))
+		return counter.Result()
+	}
+
+	tests := []struct {
+		name string
+		dwellFromFixations bool
+		want map[string]Metrics
+	}{
+		{
+			name: "dwell from samples",
+			dwellFromFixations: false,
+			want: map[string]Metrics{
+				"a": {Hit: true, DwellMsec: 66, SampleCount: 2, FixationCount: 2, TimeToFirstFixationMsec: 100, FirstFixationMsec: 200, RevisitCount: 1, LastLook: 1033},
+				"b": {Hit: true, DwellMsec: 33, SampleCount: 1, FixationCount: 1, TimeToFirstFixationMsec: 350, FirstFixationMsec: 100, LastLook: 1066},
+				"c": {TimeToFirstFixationMsec: -1},
+			},
+		},
+		{
+			name: "dwell from fixations",
+			dwellFromFixations: true,
+			want: map[string]Metrics{
+				"a": {Hit: true, DwellMsec: 300, SampleCount: 2, FixationCount: 2, TimeToFirstFixationMsec: 100, FirstFixationMsec: 200, RevisitCount: 1, LastLook: 1600},
+				"b": {Hit: true, DwellMsec: 100, SampleCount: 1, FixationCount: 1, TimeToFirstFixationMsec: 350, FirstFixationMsec: 100, LastLook: 1450},
+				"c": {TimeToFirstFixationMsec: -1},
+			},
+		},
+	}
+	for _, test := range tests {
+		got := count(test.dwellFromFixations)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %d aois, want %d", test.name, len(got), len(test.want))
+		}
+		for name, want := range test.want {
+			m, ok := got[name]
+			if !ok {
+				t.Errorf("%s: no metrics for %s", test.name, name)
+				continue
+			}
+			if *m != want {
+				t.Errorf("%s: %s = %+v, want %+v", test.name, name, *m, want)
+			}
+		}
+	}
+}
+
+func TestMetricsRevisit(t *testing.T) {
+	// 同じ AOI の中で続いた注視は、離れていないので再訪ではありません。
+	a := &Area{Name: "a", X: 0, Y: 0, Width: 100, Height: 100}
+	counter := NewMetricsCounter(0, true)
+	for i, x := range []float64{10, 20, 500, 30, 40, 600, 700, 50} {
+		start := float64(i) * 200
+		counter.AddFixation([]*Area{a}, start, start + 150, 150, ScreenGaze(x, 10))
+	}
+	m := counter.Result()["a"]
+	if m.FixationCount != 5 || m.RevisitCount != 2 {
+		t.Errorf("fixation count = %d, revisit count = %d, want 5, 2", m.FixationCount, m.RevisitCount)
+	}
+}
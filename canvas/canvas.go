@@ -0,0 +1,107 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// 点 (x, y) がどれだけ図形に含まれるか(0 から 1)を返す関数
+type Coverage func(x float64, y float64) float64
+
+// rect の中の各ピクセルを、その中心の cover の割合だけ c で塗ります(draw.Over)。
+func Paint(dst draw.Image, rect image.Rectangle, cover Coverage, c color.Color) {
+	rect = rect.Intersect(dst.Bounds())
+	if rect.Empty() {
+		return
+	}
+	mask := image.NewAlpha(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			v := cover(float64(x) + 0.5, float64(y) + 0.5)
+			if v <= 0 {
+				continue
+			}
+			mask.SetAlpha(x, y, color.Alpha{uint8(255.0 * math.Min(1.0, v))})
+		}
+	}
+	draw.DrawMask(dst, rect, image.NewUniform(c), image.ZP, mask, rect.Min, draw.Over)
+}
+
+// 中心 (cx, cy), 半径 r の円を塗ります。
+func FillCircle(dst draw.Image, cx float64, cy float64, r float64, c color.Color) {
+	Paint(dst, around(cx - r, cy - r, cx + r, cy + r), func(x float64, y float64) float64 {
+		return edge(r - math.Hypot(x - cx, y - cy))
+	}, c)
+}
+
+// 中心 (cx, cy), 半径 r の円の縁を width の太さで描きます。
+func StrokeCircle(dst draw.Image, cx float64, cy float64, r float64, width float64, c color.Color) {
+	w := width / 2.0
+	Paint(dst, around(cx - r - w, cy - r - w, cx + r + w, cy + r + w), func(x float64, y float64) float64 {
+		return edge(w - math.Abs(math.Hypot(x - cx, y - cy) - r))
+	}, c)
+}
+
+// (x0, y0) から (x1, y1) まで width の太さの線を描きます。
+func Line(dst draw.Image, x0 float64, y0 float64, x1 float64, y1 float64, width float64, c color.Color) {
+	w := width / 2.0
+	dx := x1 - x0
+	dy := y1 - y0
+	length2 := dx * dx + dy * dy
+	rect := around(math.Min(x0, x1) - w, math.Min(y0, y1) - w, math.Max(x0, x1) + w, math.Max(y0, y1) + w)
+	Paint(dst, rect, func(x float64, y float64) float64 {
+		t := 0.0
+		if length2 > 0 {
+			t = math.Max(0, math.Min(1, ((x - x0) * dx + (y - y0) * dy) / length2))
+		}
+		return edge(w - math.Hypot(x - (x0 + t * dx), y - (y0 + t * dy)))
+	}, c)
+}
+
+// rect を塗ります。
+func FillRect(dst draw.Image, rect image.Rectangle, c color.Color) {
+	draw.Draw(dst, rect.Intersect(dst.Bounds()), image.NewUniform(c), image.ZP, draw.Over)
+}
+
+// 図形の縁からの距離 d (内側が正) を、縁を 1 ピクセルでぼかした割合にします。
+func edge(d float64) float64 {
+	return math.Max(0, math.Min(1, d + 0.5))
+}
+
+// 少し広げた、ピクセルの範囲を返します。
+func around(minX float64, minY float64, maxX float64, maxY float64) image.Rectangle {
+	return image.Rect(int(math.Floor(minX)) - 1, int(math.Floor(minY)) - 1, int(math.Ceil(maxX)) + 1, int(math.Ceil(maxY)) + 1)
+}
+
+// src を scale 倍に縮めて(か広げて) dst の左上に重ねます。縮める時は元のピクセルの平均を使います。
+func DrawScaled(dst draw.Image, src image.Image, scale float64) {
+	bounds := src.Bounds()
+	width := int(float64(bounds.Dx()) * scale)
+	height := int(float64(bounds.Dy()) * scale)
+	rect := image.Rect(0, 0, width, height).Intersect(dst.Bounds())
+	scaled := image.NewRGBA(rect)
+	step := int(math.Max(1, math.Floor(1.0 / scale)))
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			sx := bounds.Min.X + int(float64(x) / scale)
+			sy := bounds.Min.Y + int(float64(y) / scale)
+			var r, g, b, a uint32
+			n := uint32(0)
+			for dy := 0; dy < step && sy + dy < bounds.Max.Y; dy++ {
+				for dx := 0; dx < step && sx + dx < bounds.Max.X; dx++ {
+					pr, pg, pb, pa := src.At(sx + dx, sy + dy).RGBA()
+					r, g, b, a = r + pr, g + pg, b + pb, a + pa
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			c := color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)}
+			scaled.Set(x, y, c)
+		}
+	}
+	draw.Draw(dst, rect, scaled, rect.Min, draw.Over)
+}
@@ -0,0 +1,94 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+var white = color.NRGBA{255, 255, 255, 255}
+
+func TestFillCircle(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	FillCircle(img, 20, 20, 10, white)
+	tests := []struct {
+		x int
+		y int
+		want uint8 // 不透明度
+	}{
+		{20, 20, 255},
+		{12, 20, 255},
+		{20, 28, 255},
+		{20, 31, 0},
+		{28, 28, 0},
+		{0, 0, 0},
+	}
+	for _, test := range tests {
+		if got := img.NRGBAAt(test.x, test.y).A; got != test.want {
+			t.Errorf("alpha at (%d, %d) = %d, want %d", test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestStrokeCircle(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	StrokeCircle(img, 20, 20, 10, 2, white)
+	if img.NRGBAAt(20, 20).A != 0 {
+		t.Errorf("inside of the circle is painted")
+	}
+	if img.NRGBAAt(29, 20).A != 255 {
+		t.Errorf("edge of the circle is not painted")
+	}
+}
+
+func TestLine(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	Line(img, 5, 10.5, 35, 10.5, 3, white)
+	if img.NRGBAAt(20, 10).A != 255 || img.NRGBAAt(5, 10).A == 0 {
+		t.Errorf("line is not painted")
+	}
+	if img.NRGBAAt(20, 14).A != 0 || img.NRGBAAt(38, 10).A != 0 {
+		t.Errorf("outside of the line is painted")
+	}
+}
+
+// 描く範囲が画像からはみ出しても、中の所だけ描きます。
+func TestPaintOutside(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	FillCircle(img, -5, -5, 8, white)
+	FillCircle(img, 100, 100, 8, white)
+	if img.NRGBAAt(0, 0).A == 0 || img.NRGBAAt(9, 9).A != 0 {
+		t.Errorf("circle across the image border is not clipped")
+	}
+}
+
+func TestText(t *testing.T) {
+	w, h := TextSize("12", 2)
+	if w != 14 || h != 10 {
+		t.Errorf("TextSize(\"12\", 2) = %d, %d, want 14, 10", w, h)
+	}
+	if w, h := TextSize("", 2); w != 0 || h != 0 {
+		t.Errorf("TextSize(\"\", 2) = %d, %d, want 0, 0", w, h)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	Text(img, 0, 0, "1", 2, white)
+	// '1' の一番上の行は真ん中だけ、一番下の行は全部塗ります。
+	tests := []struct {
+		x int
+		y int
+		want uint8
+	}{
+		{0, 0, 0},
+		{2, 0, 255},
+		{3, 1, 255},
+		{4, 0, 0},
+		{0, 8, 255},
+		{5, 9, 255},
+		{7, 8, 0},
+	}
+	for _, test := range tests {
+		if got := img.NRGBAAt(test.x, test.y).A; got != test.want {
+			t.Errorf("alpha at (%d, %d) = %d, want %d", test.x, test.y, got, test.want)
+		}
+	}
+}
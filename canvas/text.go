@@ -0,0 +1,78 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// 文字の大きさ(scale が 1 の時のピクセル)
+const (
+	GlyphWidth = 3
+	GlyphHeight = 5
+)
+
+// 数字と時刻を書くための 3x5 の文字。一行が 3 bit で、上の行から並べます。
+// 無い文字は空白になります。
+var glyphs = map[rune][GlyphHeight]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 2, 2},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	':': {0, 2, 0, 2, 0},
+	'.': {0, 0, 0, 0, 2},
+	'-': {0, 0, 7, 0, 0},
+	'+': {0, 2, 7, 2, 0},
+	'/': {1, 1, 2, 4, 4},
+	'x': {0, 5, 2, 5, 0},
+	's': {0, 3, 6, 1, 6},
+	'm': {0, 7, 7, 5, 5},
+}
+
+// text を scale 倍で書いた時の大きさを返します。文字の間は 1 つ空けます。
+func TextSize(text string, scale int) (int, int) {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0, 0
+	}
+	return (n * (GlyphWidth + 1) - 1) * scale, GlyphHeight * scale
+}
+
+// 左上を (x, y) にして text を scale 倍で書きます。
+func Text(dst draw.Image, x int, y int, text string, scale int, c color.Color) {
+	src := image.NewUniform(c)
+	for _, r := range text {
+		glyph := glyphs[r]
+		for row := 0; row < GlyphHeight; row++ {
+			for col := 0; col < GlyphWidth; col++ {
+				if glyph[row] & (1 << uint(GlyphWidth - 1 - col)) == 0 {
+					continue
+				}
+				px := x + col * scale
+				py := y + row * scale
+				draw.Draw(dst, image.Rect(px, py, px + scale, py + scale), src, image.ZP, draw.Over)
+			}
+		}
+		x += (GlyphWidth + 1) * scale
+	}
+}
+
+// 中心を (cx, cy) にして text を書きます。
+func TextCentered(dst draw.Image, cx float64, cy float64, text string, scale int, c color.Color) {
+	w, h := TextSize(text, scale)
+	Text(dst, int(cx) - w / 2, int(cy) - h / 2, text, scale, c)
+}
+
+// 読みやすいように、背景の長方形(margin ピクセル広げたもの)の上に text を書きます。
+func Label(dst draw.Image, x int, y int, text string, scale int, c color.Color, background color.Color) {
+	w, h := TextSize(text, scale)
+	margin := scale
+	FillRect(dst, image.Rect(x - margin, y - margin, x + w + margin, y + h + margin), background)
+	Text(dst, x, y, text, scale, c)
+}
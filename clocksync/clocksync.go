@@ -0,0 +1,128 @@
+package clocksync
+
+import (
+	"math"
+	"sync"
+)
+
+// 二つの時計で同じ瞬間を読んだ組(ミリ秒)
+// Remote は tracker やブラウザの時計、Local はサーバの時計です。
+// Local は通信や処理の遅れの分だけ遅く読まれていることがあります。
+type Pair struct {
+	Remote float64
+	Local float64
+}
+
+// Remote の時計を Local の時計に直すための推定値
+// Local = Remote + Offset + (Remote - Reference) * Drift / 1000000
+type Estimate struct {
+	Offset float64 `json:"offset msec"` // Reference の時の Local - Remote
+	Drift float64 `json:"drift ppm"` // Remote の時計の進み方の違い(百万分率)
+	Reference float64 `json:"reference msec"` // Offset を求めた時の Remote の時刻
+	Jitter float64 `json:"jitter msec"` // 組の遅れのばらつき(平均)
+	Samples int `json:"samples"` // 推定に使った組の数
+	Resets int `json:"resets"` // 時計が飛んで推定をやり直した回数
+}
+
+// Remote の時刻を Local の時刻に直します。
+func (e *Estimate) ToLocal(remote float64) float64 {
+	return remote + e.Offset + (remote - e.Reference) * e.Drift / 1000000.0
+}
+
+// 既定値
+const (
+	DefaultWindow = 1000 // 推定に使う組の数(30Hz なら 30秒分くらい)
+	DefaultResetMsec = 1000.0 // 推定からこれより外れた組が来たら、時計が変わったものとしてやり直します
+)
+
+// 組を溜めて、Remote の時計のずれと進み方を推定するもの
+// 遅れは必ず正なので、ずれは組の下側の包絡線から求め、進み方は最小二乗法で求めます。
+// 複数の goroutine から同時に使えます。
+type Estimator struct {
+	lock sync.Mutex
+	Window int
+	ResetMsec float64
+	pairs []Pair
+	resets int
+}
+
+func NewEstimator() *Estimator {
+	return &Estimator{
+		Window: DefaultWindow,
+		ResetMsec: DefaultResetMsec,
+	}
+}
+
+// 組を一つ加えます。
+// tracker が繋ぎ直されて時計が飛んだ時は、それまでの組を捨ててやり直します。
+func (e *Estimator) Add(remote float64, local float64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.pairs) >= 2 && e.ResetMsec > 0 {
+		estimate := estimatePairs(e.pairs)
+		if math.Abs(local - estimate.ToLocal(remote)) > e.ResetMsec {
+			e.pairs = nil
+			e.resets += 1
+		}
+	}
+	e.pairs = append(e.pairs, Pair{Remote: remote, Local: local})
+	if e.Window > 0 && len(e.pairs) > e.Window {
+		e.pairs = e.pairs[len(e.pairs) - e.Window:]
+	}
+}
+
+// 今の推定値を返します。組が無ければ false を返します。
+func (e *Estimator) Estimate() (Estimate, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.pairs) <= 0 {
+		return Estimate{}, false
+	}
+	estimate := estimatePairs(e.pairs)
+	estimate.Resets = e.resets
+	return estimate, true
+}
+
+// 組のリストから推定値を求めます。
+func estimatePairs(pairs []Pair) Estimate {
+	n := float64(len(pairs))
+	reference := pairs[len(pairs) - 1].Remote
+	// 進み方: (Local - Remote) を Remote で最小二乗法
+	meanX := 0.0
+	meanY := 0.0
+	for _, p := range pairs {
+		meanX += p.Remote - reference
+		meanY += p.Local - p.Remote
+	}
+	meanX /= n
+	meanY /= n
+	cov := 0.0
+	varX := 0.0
+	for _, p := range pairs {
+		dx := p.Remote - reference - meanX
+		cov += dx * (p.Local - p.Remote - meanY)
+		varX += dx * dx
+	}
+	slope := 0.0
+	if varX > 0 {
+		slope = cov / varX
+	}
+	// ずれ: 進み方を除いた残りの一番小さいもの(一番遅れなかった組)
+	offset := math.Inf(1)
+	residuals := make([]float64, len(pairs))
+	for i, p := range pairs {
+		residuals[i] = p.Local - p.Remote - slope * (p.Remote - reference)
+		offset = math.Min(offset, residuals[i])
+	}
+	jitter := 0.0
+	for _, r := range residuals {
+		jitter += r - offset
+	}
+	return Estimate{
+		Offset: offset,
+		Drift: slope * 1000000.0,
+		Reference: reference,
+		Jitter: jitter / n,
+		Samples: len(pairs),
+	}
+}
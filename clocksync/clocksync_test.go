@@ -0,0 +1,119 @@
+package clocksync
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func near(a float64, b float64, tolerance float64) bool {
+	return math.Abs(a - b) <= tolerance
+}
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		name string
+		local func(remote float64, i int) float64
+		offset float64 // Reference (最後の組の Remote) の時のずれ
+		drift float64
+		jitter float64
+		tolerance float64
+	}{
+		{
+			name: "constant offset",
+			local: func(remote float64, i int) float64 { return remote + 5000 },
+			offset: 5000,
+		},
+		{
+			name: "drift",
+			local: func(remote float64, i int) float64 { return remote + 5000 + remote * 100 / 1000000.0 },
+			offset: 5000 + 3300 * 100 / 1000000.0,
+			drift: 100,
+		},
+		{
+			// 遅れは必ず正なので、一番遅れなかった組がずれになります。
+			name: "delayed pairs",
+			local: func(remote float64, i int) float64 { return remote + 5000 + float64(i % 2) * 10 },
+			offset: 5000,
+			jitter: 5,
+			tolerance: 0.5,
+		},
+	}
+	for _, test := range tests {
+		e := NewEstimator()
+		if _, ok := e.Estimate(); ok {
+			t.Fatalf("%s: empty estimator should have no estimate", test.name)
+		}
+		for i := 0; i <= 100; i++ {
+			remote := float64(i) * 33
+			e.Add(remote, test.local(remote, i))
+		}
+		estimate, ok := e.Estimate()
+		if !ok {
+			t.Fatalf("%s: no estimate", test.name)
+		}
+		tolerance := math.Max(test.tolerance, 1e-6)
+		if !near(estimate.Offset, test.offset, tolerance) || !near(estimate.Drift, test.drift, tolerance) || !near(estimate.Jitter, test.jitter, tolerance) {
+			t.Errorf("%s: estimate = %+v, want offset %f, drift %f, jitter %f", test.name, estimate, test.offset, test.drift, test.jitter)
+		}
+		if estimate.Reference != 3300 || estimate.Samples != 101 || estimate.Resets != 0 {
+			t.Errorf("%s: estimate = %+v", test.name, estimate)
+		}
+		// 推定値で直した時刻は、遅れなかった組の Local に合います。
+		if !near(estimate.ToLocal(0), test.local(0, 0), tolerance) {
+			t.Errorf("%s: ToLocal(0) = %f, want %f", test.name, estimate.ToLocal(0), test.local(0, 0))
+		}
+	}
+}
+
+// 時計が飛んだら、それまでの組を捨ててやり直し、Resets に数えます。
+func TestEstimatorReset(t *testing.T) {
+	e := NewEstimator()
+	for i := 0; i < 10; i++ {
+		e.Add(float64(i) * 33, float64(i) * 33 + 5000)
+	}
+	// 一分後に tracker が繋ぎ直されて、tracker の時計が 0 から数え直した
+	e.Add(0, 65000)
+	e.Add(33, 65033)
+	estimate, _ := e.Estimate()
+	if estimate.Resets != 1 || estimate.Samples != 2 || !near(estimate.Offset, 65000, 1e-6) {
+		t.Errorf("estimate after a reset = %+v", estimate)
+	}
+	// ResetMsec より小さい揺れではやり直しません。
+	e.Add(66, 65066 + DefaultResetMsec / 2)
+	estimate, _ = e.Estimate()
+	if estimate.Resets != 1 || estimate.Samples != 3 {
+		t.Errorf("estimate after a small jump = %+v", estimate)
+	}
+}
+
+func TestEstimatorWindow(t *testing.T) {
+	e := NewEstimator()
+	e.Window = 5
+	for i := 0; i < 20; i++ {
+		e.Add(float64(i) * 33, float64(i) * 33 + 100)
+	}
+	estimate, _ := e.Estimate()
+	if estimate.Samples != 5 || estimate.Reference != 19 * 33 {
+		t.Errorf("estimate = %+v, want 5 samples up to %d", estimate, 19 * 33)
+	}
+}
+
+func TestEstimatorConcurrent(t *testing.T) {
+	e := NewEstimator()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(){
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				e.Add(float64(i), float64(i) + 10)
+				e.Estimate()
+			}
+		}()
+	}
+	wg.Wait()
+	if _, ok := e.Estimate(); !ok {
+		t.Errorf("no estimate")
+	}
+}
@@ -0,0 +1,107 @@
+package eventlog
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// log file の形式の版
+// 記録の形を変えた時は上げて、読む側で区別できるようにします。
+const SchemaVersion = 1
+
+// 記録の種類と、それぞれの payload
+const (
+	TypeHeader = "header" // Header: Writer を作った時に最初に書き出します
+	TypeFrame = "frame" // tracker のフレーム(EyeTribe の frame と同じ形に、サーバで受け取った時刻 "GoTime" を加えたもの)
+	TypeNavigation = "navigation" // Navigation: ページの要求
+	TypeMarker = "marker" // Marker: 外から打たれた目印
+	TypeCalibration = "calibration" // キャリブレーションの結果(EyeTribe の calibresult と同じ形)
+	TypeConnection = "connection" // Connection: tracker との接続が切れた・繋がり直した
+	TypeAOIEvent = "aoi_event" // 視線に応じた規則が発火した(rule, type, aoi, url, time)
+	TypeSessionStart = "session_start" // セッションの header(experiment, participant, session, 画面や tracker の設定, AOI の設定, 版)
+	TypeSessionEnd = "session_end" // 終わったセッション(experiment, participant, session, 試行の一覧)
+	TypeTrialStart = "trial_start" // TrialPayload
+	TypeTrialEnd = "trial_end" // TrialPayload
+	TypeClock = "clock" // Clock: tracker やブラウザの時計とサーバの時計のずれ
+	TypeViewport = "viewport" // ページの表示の様子(viewport.State: 画面上の位置, 大きさ, scroll, zoom)
+)
+
+// log file の一行
+// Mono は Writer を作ってからの経過時間(ナノ秒)で、時計が合わせ直されても戻りません。
+// 同じ Writer が書いた記録の Seq は 1 から順に増えます。
+// 古い形式の log から読み込んだものは Version が 0 で、Seq と Mono は 0 になります。
+type Record struct {
+	Version int `json:"v"`
+	Type string `json:"type"`
+	Seq uint64 `json:"seq"`
+	Mono int64 `json:"mono"`
+	Time time.Time `json:"time"` // サーバの時計
+	Payload json.RawMessage `json:"payload"`
+}
+
+// payload を v に読み込みます。
+func (r *Record) Decode(v interface{}) error {
+	return json.Unmarshal(r.Payload, v)
+}
+
+// Writer を作った時に書き出す記録
+type Header struct {
+	Software string `json:"software"` // 書き出したプログラムの名前
+	SoftwareVersion string `json:"software version"`
+	Start time.Time `json:"start"` // Mono が 0 の時の時刻
+}
+
+// ページの要求
+type Navigation struct {
+	Url string `json:"url"` // RequestURI
+}
+
+// 外から(刺激のページ等から)打たれた目印
+// 時刻は Unix 時間のミリ秒です。ClientTime はページの時計、ServerTime は受け取った時のサーバの時計なので、
+// 二つを比べると時計のずれ(と通信の遅れ)が分かります。
+type Marker struct {
+	Name string `json:"name"`
+	Trial string `json:"trial,omitempty"` // 試行の ID
+	Session string `json:"session,omitempty"` // 打たれた時のセッション
+	Data json.RawMessage `json:"data,omitempty"` // 好きな key/value (JSON の object)
+	ClientTime *float64 `json:"client time,omitempty"` // ページが目印を打った時刻(分からなければ nil)
+	ServerTime float64 `json:"server time"` // サーバが目印を受け取った時刻
+	Client string `json:"client,omitempty"` // どのブラウザから打たれたか
+	ClientOffset *float64 `json:"client offset msec,omitempty"` // そのブラウザが /clock/ping.json で測った時計のずれ(サーバ - ブラウザ)
+}
+
+// 目印を打った時刻をサーバの時計(Unix 時間のミリ秒)で返します。
+// ブラウザの時計のずれが分かっていれば ClientTime を直したものを、分からなければ ServerTime を返します。
+func (m *Marker) Time() float64 {
+	if m.ClientTime != nil && m.ClientOffset != nil {
+		return *m.ClientTime + *m.ClientOffset
+	}
+	return m.ServerTime
+}
+
+// tracker との接続が切れた・繋がり直した事
+// 解析の時に、データが無いのは接続が切れていたからなのか、
+// 被験者がよそ見をしていたからなのかを区別できるようにするためのものです。
+type Connection struct {
+	Event string `json:"event"` // "disconnect" か "reconnect"
+	Reason string `json:"reason,omitempty"`
+}
+
+// tracker やブラウザの時計とサーバの時計のずれ
+// サーバの時計(Unix 時間のミリ秒) = 相手の時計 + Offset + (相手の時計 - Reference) * Drift / 1000000
+type Clock struct {
+	Source string `json:"source"` // "tracker" か "browser"
+	Client string `json:"client,omitempty"` // browser: どのブラウザか
+	Offset float64 `json:"offset msec"`
+	Drift float64 `json:"drift ppm"`
+	Reference float64 `json:"reference msec"` // Offset を求めた時の相手の時刻
+	Jitter float64 `json:"jitter msec,omitempty"` // tracker: 遅れのばらつき
+	RoundTrip float64 `json:"round trip msec,omitempty"` // browser: 一番短かった往復の時間(ずれの誤差はこの半分以下です)
+	Samples int `json:"samples"` // 推定に使った組や往復の数
+}
+
+// 試行の始まりと終わり
+type TrialPayload struct {
+	Trial json.RawMessage `json:"trial"` // name, index, condition, start time, end time
+	Session string `json:"session"`
+}
@@ -0,0 +1,420 @@
+package eventlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 書き込みを待っている記録が多すぎて捨てた時のエラー
+var ErrQueueFull = errors.New("log queue is full")
+
+// 閉じた File に書き込もうとした時のエラー
+var ErrClosed = errors.New("log file is closed")
+
+// File の書き込み方の設定
+type FileOptions struct {
+	QueueSize int // 書き込みを待てる記録の数。溢れた分は捨てて Dropped に数えます
+	BatchSize int // 一度にまとめて書き込む記録の最大数
+	FlushInterval time.Duration // fsync と時間での回転を確かめる間隔
+	SyncInterval time.Duration // この間隔で fsync します(0 なら回転と閉じる時だけ)
+	LateThreshold time.Duration // これより長く待たされた記録を Late に数えます
+	MaxBytes int64 // これより大きくなったら回転します(0 なら大きさでは回転しません)
+	MaxAge time.Duration // これより長く書き込んだら回転します(0 なら時間では回転しません)
+	Compress string // 回転したファイルの圧縮("" か "gzip")。"zstd" は標準ライブラリに無いので、まだ使えません
+	Header func(last []byte) []byte // 回転して新しいファイルを開いた時、最初に書き込む行を返します(nil なら書きません)。last は回転する前のファイルに最後に書き込んだ行です
+}
+
+// 既定の設定
+func DefaultFileOptions() FileOptions {
+	return FileOptions{
+		QueueSize: 8192,
+		BatchSize: 256,
+		FlushInterval: 100 * time.Millisecond,
+		SyncInterval: time.Second,
+		LateThreshold: time.Second,
+	}
+}
+
+// 設定がおかしくないかを確認します。
+func (o *FileOptions) Validate() error {
+	switch o.Compress {
+	case "", "gzip":
+	case "zstd":
+		return errors.New("zstd compression is not available in this build (use gzip)")
+	default:
+		return errors.New(fmt.Sprintf("unknown log compression: %s", o.Compress))
+	}
+	if o.QueueSize <= 0 {
+		return errors.New(fmt.Sprintf("log queue size must be positive: %d", o.QueueSize))
+	}
+	if o.MaxBytes < 0 || o.MaxAge < 0 {
+		return errors.New("log rotation size and age must not be negative")
+	}
+	return nil
+}
+
+// File の書き込みの状況
+// 捨てた記録(Dropped)や遅れた記録(Late)があれば、log は完全ではありません。
+type Stats struct {
+	Written uint64 `json:"written"` // 書き込んだ記録の数
+	Dropped uint64 `json:"dropped"` // 待ち行列が溢れて捨てた記録の数
+	Late uint64 `json:"late"` // LateThreshold より長く待たされてから書き込んだ記録の数
+	Failed uint64 `json:"failed"` // 書き込みに失敗した記録の数
+	Batches uint64 `json:"batches"` // まとめて書き込んだ回数
+	Syncs uint64 `json:"syncs"` // fsync した回数
+	Rotations uint64 `json:"rotations"` // 回転した回数
+	Queued int `json:"queued"` // 今書き込みを待っている記録の数
+	LastError string `json:"last error,omitempty"`
+}
+
+// s に other を足します(Queued と LastError は other のものにします)。
+func (s *Stats) Add(other Stats) {
+	s.Written += other.Written
+	s.Dropped += other.Dropped
+	s.Late += other.Late
+	s.Failed += other.Failed
+	s.Batches += other.Batches
+	s.Syncs += other.Syncs
+	s.Rotations += other.Rotations
+	s.Queued = other.Queued
+	if other.LastError != "" {
+		s.LastError = other.LastError
+	}
+}
+
+// 書き込みを待っている一行
+type queuedLine struct {
+	data []byte
+	queued time.Time
+}
+
+// 裏の goroutine でまとめて書き込む log file
+// Write() はファイルに書き込むのを待たずに返るので、frame を読む goroutine がディスクで止まりません。
+type File struct {
+	fileName string
+	options FileOptions
+	queue chan queuedLine
+	done chan bool
+	closeLock sync.RWMutex // 閉じた後に queue に送らないように守ります
+	closed bool
+
+	file *os.File // 裏の goroutine だけが触ります
+	size int64
+	opened time.Time
+	lastSync time.Time
+	dirty bool
+	last []byte // 最後に書き込んだ行
+
+	written uint64
+	dropped uint64
+	late uint64
+	failed uint64
+	batches uint64
+	syncs uint64
+	rotations uint64
+	errorLock sync.Mutex
+	lastError string
+	compressing sync.WaitGroup
+}
+
+// fileName に追記する File を開きます。
+func OpenFile(fileName string, options FileOptions) (*File, error) {
+	defaults := DefaultFileOptions()
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaults.QueueSize
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaults.BatchSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = defaults.FlushInterval
+	}
+	if options.LateThreshold <= 0 {
+		options.LateThreshold = defaults.LateThreshold
+	}
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+	f := &File{
+		fileName: fileName,
+		options: options,
+		queue: make(chan queuedLine, options.QueueSize),
+		done: make(chan bool),
+	}
+	err = f.open(false)
+	if err != nil {
+		return nil, err
+	}
+	go f.run()
+	return f, nil
+}
+
+// ファイルの名前を返します。
+func (f *File) Name() string {
+	return f.fileName
+}
+
+// 一行(一つの記録)を書き込み待ちにします。待ち行列が一杯なら捨てて ErrQueueFull を返します。
+func (f *File) Write(p []byte) (int, error) {
+	f.closeLock.RLock()
+	defer f.closeLock.RUnlock()
+	if f.closed {
+		return 0, ErrClosed
+	}
+	data := make([]byte, len(p))
+	copy(data, p)
+	select {
+	case f.queue <- queuedLine{data: data, queued: time.Now()}:
+		return len(p), nil
+	default:
+		atomic.AddUint64(&f.dropped, 1)
+		return 0, ErrQueueFull
+	}
+}
+
+// 待っている記録を全て書き込んでから、fsync して閉じます。
+// 回転したファイルの圧縮が終わるのも待ちます。
+func (f *File) Close() error {
+	f.closeLock.Lock()
+	if f.closed {
+		f.closeLock.Unlock()
+		return ErrClosed
+	}
+	f.closed = true
+	close(f.queue)
+	f.closeLock.Unlock()
+	<- f.done
+	f.compressing.Wait()
+	f.errorLock.Lock()
+	defer f.errorLock.Unlock()
+	if f.lastError != "" {
+		return errors.New(f.lastError)
+	}
+	return nil
+}
+
+// 今の書き込みの状況を返します。
+func (f *File) Stats() Stats {
+	f.errorLock.Lock()
+	lastError := f.lastError
+	f.errorLock.Unlock()
+	return Stats{
+		Written: atomic.LoadUint64(&f.written),
+		Dropped: atomic.LoadUint64(&f.dropped),
+		Late: atomic.LoadUint64(&f.late),
+		Failed: atomic.LoadUint64(&f.failed),
+		Batches: atomic.LoadUint64(&f.batches),
+		Syncs: atomic.LoadUint64(&f.syncs),
+		Rotations: atomic.LoadUint64(&f.rotations),
+		Queued: len(f.queue),
+		LastError: lastError,
+	}
+}
+
+func (f *File) setError(err error) {
+	f.errorLock.Lock()
+	f.lastError = err.Error()
+	f.errorLock.Unlock()
+	fmt.Printf("log file %s error: %q\n", f.fileName, err)
+}
+
+// ファイルを開きます。回転した後(rotated)なら、Header があれば最初に書き込みます。
+func (f *File) open(rotated bool) error {
+	file, err := os.OpenFile(f.fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0660)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	f.lastSync = f.opened
+	if rotated && f.options.Header != nil {
+		header := f.options.Header(f.last)
+		if len(header) > 0 {
+			n, err := file.Write(header)
+			f.size += int64(n)
+			f.dirty = true
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 裏で待ち行列の記録をまとめて書き込み続けます。
+// 記録が来たら、その時に溜まっている分(BatchSize まで)を一度に書き込みます。
+func (f *File) run() {
+	defer close(f.done)
+	ticker := time.NewTicker(f.options.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]queuedLine, 0, f.options.BatchSize)
+	for {
+		select {
+		case line, ok := <- f.queue:
+			if !ok {
+				f.sync()
+				f.file.Close()
+				return
+			}
+			batch = append(batch[:0], line)
+			for more := true; more && len(batch) < f.options.BatchSize; {
+				select {
+				case line, ok := <- f.queue:
+					if ok {
+						batch = append(batch, line)
+					} else {
+						more = false
+					}
+				default:
+					more = false
+				}
+			}
+			f.writeBatch(batch)
+		case <- ticker.C:
+			f.maybeRotate()
+			if f.options.SyncInterval > 0 && f.dirty && time.Since(f.lastSync) >= f.options.SyncInterval {
+				f.sync()
+			}
+		}
+	}
+}
+
+// 記録をまとめて一度に書き込みます。
+func (f *File) writeBatch(batch []queuedLine) {
+	if len(batch) <= 0 {
+		return
+	}
+	now := time.Now()
+	buf := make([]byte, 0, len(batch) * 256)
+	for _, line := range batch {
+		buf = append(buf, line.data...)
+		if now.Sub(line.queued) > f.options.LateThreshold {
+			atomic.AddUint64(&f.late, 1)
+		}
+	}
+	n, err := f.file.Write(buf)
+	f.size += int64(n)
+	f.dirty = true
+	atomic.AddUint64(&f.batches, 1)
+	if err != nil {
+		atomic.AddUint64(&f.failed, uint64(len(batch)))
+		f.setError(err)
+		return
+	}
+	atomic.AddUint64(&f.written, uint64(len(batch)))
+	f.last = batch[len(batch) - 1].data
+	f.maybeRotate()
+}
+
+// 書き込んだものをディスクに書き出します。
+func (f *File) sync() {
+	if !f.dirty {
+		return
+	}
+	err := f.file.Sync()
+	if err != nil {
+		f.setError(err)
+	}
+	atomic.AddUint64(&f.syncs, 1)
+	f.lastSync = time.Now()
+	f.dirty = false
+}
+
+// 大きさか時間が設定を超えていたら回転します。
+func (f *File) maybeRotate() {
+	if f.options.MaxBytes > 0 && f.size >= f.options.MaxBytes {
+		f.rotate()
+		return
+	}
+	if f.options.MaxAge > 0 && time.Since(f.opened) >= f.options.MaxAge && f.size > 0 {
+		f.rotate()
+	}
+}
+
+// 回転したファイルの名前を返します。log.json → log.20161011-150405.json
+func rotatedFileName(fileName string, t time.Time) string {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	name := fmt.Sprintf("%s.%s%s", base, t.Format("20060102-150405"), ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(name + ".gz"); os.IsNotExist(err) {
+				return name
+			}
+		}
+		name = fmt.Sprintf("%s.%s-%d%s", base, t.Format("20060102-150405"), i, ext)
+	}
+}
+
+// 今のファイルを別の名前にして、新しいファイルを開き直します。
+func (f *File) rotate() {
+	f.sync()
+	f.file.Close()
+	rotated := rotatedFileName(f.fileName, time.Now())
+	err := os.Rename(f.fileName, rotated)
+	if err != nil {
+		f.setError(err)
+	} else {
+		atomic.AddUint64(&f.rotations, 1)
+		if f.options.Compress == "gzip" {
+			f.compressing.Add(1)
+			go func(){
+				defer f.compressing.Done()
+				err := gzipFile(rotated)
+				if err != nil {
+					f.setError(err)
+				}
+			}()
+		}
+	}
+	err = f.open(true)
+	if err != nil {
+		f.setError(err)
+	}
+}
+
+// fileName を fileName.gz に圧縮して、元のファイルを消します。
+func gzipFile(fileName string) error {
+	src, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(fileName + ".gz", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(fileName)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fileName + ".gz")
+		return err
+	}
+	return os.Remove(fileName)
+}
@@ -0,0 +1,142 @@
+package eventlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		options FileOptions
+		ok bool
+	}{
+		{"default", DefaultFileOptions(), true},
+		{"gzip", FileOptions{QueueSize: 1, Compress: "gzip"}, true},
+		{"zstd", FileOptions{QueueSize: 1, Compress: "zstd"}, false},
+		{"no queue", FileOptions{}, false},
+		{"negative size", FileOptions{QueueSize: 1, MaxBytes: -1}, false},
+		{"negative age", FileOptions{QueueSize: 1, MaxAge: -time.Second}, false},
+	}
+	for _, test := range tests {
+		err := test.options.Validate()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Validate() = %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+	// zstd は知らない圧縮とは区別して、使えない事を伝えます。
+	options := FileOptions{QueueSize: 1, Compress: "zstd"}
+	err := options.Validate()
+	if err == nil || err.Error() != "zstd compression is not available in this build (use gzip)" {
+		t.Errorf("Validate() with zstd = %v", err)
+	}
+}
+
+// 回転しながら書いた log を、回転したファイルごとまとめて読むと、書いた順に全ての記録が返ります。
+func TestFileRotation(t *testing.T) {
+	directoryName := t.TempDir()
+	fileName := filepath.Join(directoryName, "log.json")
+	var writer *Writer
+	options := DefaultFileOptions()
+	options.MaxBytes = 2000
+	options.Compress = "gzip"
+	options.Header = func(last []byte) []byte {
+		return writer.HeaderRecord("test", "1.0", last)
+	}
+	file, err := OpenFile(fileName, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer = NewWriter(file)
+	writer.WriteHeader("test", "1.0")
+	const count = 100
+	for i := 0; i < count; i++ {
+		err = writer.Write(TypeNavigation, &Navigation{Url: fmt.Sprintf("/page/%d.html", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i % 10 == 0 {
+			// 一度に書き込まれると、回転が一回にまとまってしまいます。
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	err = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := file.Stats()
+	if stats.Written != count + 1 || stats.Dropped != 0 || stats.Rotations == 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if file.Close() != ErrClosed {
+		t.Errorf("second Close() should return ErrClosed")
+	}
+
+	fileNameList, err := filepath.Glob(filepath.Join(directoryName, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range fileNameList {
+		if name != fileName && !strings.HasSuffix(name, ".gz") {
+			t.Errorf("rotated file is not compressed: %s", name)
+		}
+	}
+	logList := GroupRotatedLogs(fileNameList)
+	if len(logList) != 1 || logList[0].Name != fileName || len(logList[0].FileNameList) != int(stats.Rotations) + 1 {
+		t.Fatalf("GroupRotatedLogs() = %+v, %d rotations", logList, stats.Rotations)
+	}
+	logFile, err := OpenLogs(logList[0].FileNameList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	reader := NewReader(logFile)
+	headers := 0
+	pages := 0
+	lastSeq := uint64(0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Seq < lastSeq {
+			t.Errorf("seq went back: %d < %d", record.Seq, lastSeq)
+		}
+		lastSeq = record.Seq
+		switch record.Type {
+		case TypeHeader:
+			headers++
+		case TypeNavigation:
+			var navigation Navigation
+			record.Decode(&navigation)
+			if want := fmt.Sprintf("/page/%d.html", pages); navigation.Url != want {
+				t.Errorf("navigation %d = %s, want %s", pages, navigation.Url, want)
+			}
+			pages++
+		}
+	}
+	if pages != count || headers != int(stats.Rotations) + 1 || lastSeq != count + 1 {
+		t.Errorf("read %d pages, %d headers, last seq %d", pages, headers, lastSeq)
+	}
+}
+
+func TestOpenLogsMissingFile(t *testing.T) {
+	directoryName := t.TempDir()
+	fileName := filepath.Join(directoryName, "log.json")
+	err := os.WriteFile(fileName, []byte("{\"request path\":\"/a.html\"}"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenLogs([]string{fileName, filepath.Join(directoryName, "missing.json")})
+	if err == nil {
+		t.Errorf("OpenLogs() should fail when a file is missing")
+	}
+}
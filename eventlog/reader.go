@@ -0,0 +1,299 @@
+package eventlog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 読めなかった行
+// Reader.Read() がこれを返した時は、その行を飛ばして読み続けられます。
+type LineError struct {
+	Line int
+	Err error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// 記録を一行づつ読み込むもの
+// 版の無い古い形式の行(フレームの JSON や {"request path": ...} 等)も Record にして返します。
+type Reader struct {
+	reader *bufio.Reader
+	line int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReaderSize(r, 20480)}
+}
+
+// 次の記録を返します。最後まで読んだら io.EOF を返します。
+// 読めない行は *LineError を返します。
+func (r *Reader) Read() (*Record, error) {
+	for {
+		bin, err := r.reader.ReadBytes('\n')
+		if len(bin) == 0 && err != nil {
+			return nil, err
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		r.line += 1
+		if len(bytes.TrimSpace(bin)) == 0 {
+			continue
+		}
+		record, err := ParseLine(bin)
+		if err != nil {
+			return nil, &LineError{Line: r.line, Err: err}
+		}
+		return record, nil
+	}
+}
+
+// gzip で圧縮されたファイルを読んで、閉じる時に両方閉じるもの
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// log file を読むために開きます。
+// 名前が .gz で終わるファイル(回転して圧縮されたもの)は展開しながら読みます。
+func OpenLog(fileName string) (io.ReadCloser, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(fileName, ".gz") {
+		return file, nil
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: zr, file: file}, nil
+}
+
+// 複数のファイルを続けて読み、閉じる時に全て閉じるもの
+type multiReadCloser struct {
+	io.Reader
+	fileList []io.ReadCloser
+}
+
+func (m *multiReadCloser) Close() error {
+	var result error
+	for _, file := range m.fileList {
+		err := file.Close()
+		if result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// 回転して分かれた log file を、続けて一つの log として読むために開きます。
+// fileNameList は古い順に並べておきます(GroupRotatedLogs() の FileNameList)。
+func OpenLogs(fileNameList []string) (io.ReadCloser, error) {
+	result := &multiReadCloser{}
+	readerList := []io.Reader{}
+	for _, fileName := range fileNameList {
+		file, err := OpenLog(fileName)
+		if err != nil {
+			result.Close()
+			return nil, err
+		}
+		result.fileList = append(result.fileList, file)
+		// 最後の行が改行で終わっていなくても、次のファイルの最初の行と繋がらないようにします。
+		readerList = append(readerList, file, strings.NewReader("\n"))
+	}
+	result.Reader = io.MultiReader(readerList...)
+	return result, nil
+}
+
+// 回転したファイルの名前(rotatedFileName() と、圧縮したものは .gz を付けたもの)
+var rotatedNamePattern = regexp.MustCompile(`^(.+)\.(\d{8}-\d{6})(?:-(\d+))?(\.[^.]+)(\.gz)?$`)
+
+// 回転して分かれた一つの log
+type RotatedLog struct {
+	Name string // 回転する前の(今書いている)ファイルの名前
+	FileNameList []string // 古い順のファイルの名前(回転していないものは最後です)
+}
+
+// 回転したファイルの名前から、回転する前の名前と、並べる順(回転した時刻と番号)を返します。
+// 回転したものでなければ ok = false を返します。
+func parseRotatedName(fileName string) (name string, stamp string, n int, ok bool) {
+	m := rotatedNamePattern.FindStringSubmatch(fileName)
+	if m == nil {
+		return strings.TrimSuffix(fileName, ".gz"), "", 0, false
+	}
+	if m[3] != "" {
+		n, _ = strconv.Atoi(m[3])
+	}
+	return m[1] + m[4], m[2], n, true
+}
+
+// ファイルの名前のリストを、回転して分かれた log 毎にまとめ、それぞれを古い順に並べます。
+// log の順は、最初にそのファイルが出てきた順です。
+func GroupRotatedLogs(fileNameList []string) []RotatedLog {
+	type part struct {
+		fileName string
+		stamp string
+		n int
+		rotated bool
+	}
+	nameList := []string{}
+	partMap := map[string][]part{}
+	for _, fileName := range fileNameList {
+		name, stamp, n, rotated := parseRotatedName(fileName)
+		if _, ok := partMap[name]; !ok {
+			nameList = append(nameList, name)
+		}
+		partMap[name] = append(partMap[name], part{fileName: fileName, stamp: stamp, n: n, rotated: rotated})
+	}
+	result := []RotatedLog{}
+	for _, name := range nameList {
+		partList := partMap[name]
+		sort.SliceStable(partList, func(i, j int) bool {
+			a := partList[i]
+			b := partList[j]
+			if a.rotated != b.rotated {
+				return a.rotated
+			}
+			if a.stamp != b.stamp {
+				return a.stamp < b.stamp
+			}
+			return a.n < b.n
+		})
+		log := RotatedLog{Name: name}
+		for _, p := range partList {
+			log.FileNameList = append(log.FileNameList, p.fileName)
+		}
+		result = append(result, log)
+	}
+	return result
+}
+
+// 全ての記録を順に fn に渡します。読めない行は onError に渡して読み続けます(onError が nil なら無視します)。
+// fn がエラーを返したらそこでやめます。
+func ReadFile(fileName string, fn func(*Record) error, onError func(error)) error {
+	file, err := OpenLog(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := NewReader(file)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if lineErr, ok := err.(*LineError); ok {
+			if onError != nil {
+				onError(lineErr)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 一行を Record にします。
+func ParseLine(line []byte) (*Record, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(line, &fields)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := fields["type"]; ok {
+		if _, ok := fields["payload"]; ok {
+			var record Record
+			err = json.Unmarshal(line, &record)
+			if err != nil {
+				return nil, err
+			}
+			return &record, nil
+		}
+	}
+	return parseLegacyLine(fields)
+}
+
+// 版の無い古い形式の行を Record にします。
+// 古い形式には、ページの要求の {"request path": ..., "unix time": ...} の行と、
+// tracker のメッセージをそのまま書き出したフレームの行しかありません。
+func parseLegacyLine(fields map[string]json.RawMessage) (*Record, error) {
+	record := &Record{Time: legacyTime(fields)}
+	if value, ok := fields["request path"]; ok {
+		var url string
+		err := json.Unmarshal(value, &url)
+		if err != nil {
+			return nil, err
+		}
+		record.Type = TypeNavigation
+		record.Payload, err = json.Marshal(&Navigation{Url: url})
+		if err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+	// tracker のメッセージをそのまま書き出したフレームの行
+	if values, ok := fields["values"]; ok {
+		var frameValues map[string]json.RawMessage
+		err := json.Unmarshal(values, &frameValues)
+		if err != nil {
+			return nil, err
+		}
+		frame, ok := frameValues["frame"]
+		if !ok {
+			return nil, errors.New("legacy message has no frame field")
+		}
+		record.Type = TypeFrame
+		record.Payload = frame
+		var goTime struct {
+			GoTime time.Time
+		}
+		if json.Unmarshal(frame, &goTime) == nil {
+			record.Time = goTime.GoTime
+		}
+		return record, nil
+	}
+	return nil, errors.New("unknown log line")
+}
+
+// 古い形式の行の時刻を返します。"GoTime" があればそれを、無ければ "unix time" を使います。
+func legacyTime(fields map[string]json.RawMessage) time.Time {
+	if v, ok := fields["GoTime"]; ok {
+		var t time.Time
+		if json.Unmarshal(v, &t) == nil {
+			return t
+		}
+	}
+	if v, ok := fields["unix time"]; ok {
+		var unixTime int64
+		if json.Unmarshal(v, &unixTime) == nil {
+			return time.Unix(unixTime, 0)
+		}
+	}
+	return time.Time{}
+}
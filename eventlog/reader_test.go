@@ -0,0 +1,249 @@
+package eventlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Writer で書いたものを Reader で読むと、書いた順に同じ記録が返ります。
+func TestWriteAndRead(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	err := writer.WriteHeader("test", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTime := 1476601200000.0
+	offset := 12.5
+	writer.Write(TypeNavigation, &Navigation{Url: "/stim/page.html"})
+	writer.Write(TypeMarker, &Marker{Name: "start", Trial: "t1", ClientTime: &clientTime, ServerTime: 1476601200020, ClientOffset: &offset})
+	writer.Write(TypeConnection, &Connection{Event: "disconnect", Reason: "EOF"})
+
+	reader := NewReader(&buf)
+	typeList := []string{}
+	var previous *Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Version != SchemaVersion {
+			t.Errorf("version = %d, want %d", record.Version, SchemaVersion)
+		}
+		if record.Seq != uint64(len(typeList) + 1) {
+			t.Errorf("seq = %d, want %d", record.Seq, len(typeList) + 1)
+		}
+		if previous != nil && record.Mono < previous.Mono {
+			t.Errorf("mono went back: %d < %d", record.Mono, previous.Mono)
+		}
+		switch record.Type {
+		case TypeHeader:
+			var header Header
+			record.Decode(&header)
+			if header.Software != "test" || header.SoftwareVersion != "1.0" || record.Mono != 0 {
+				t.Errorf("header = %+v, mono %d", header, record.Mono)
+			}
+		case TypeNavigation:
+			var navigation Navigation
+			record.Decode(&navigation)
+			if navigation.Url != "/stim/page.html" {
+				t.Errorf("navigation = %+v", navigation)
+			}
+		case TypeMarker:
+			var marker Marker
+			record.Decode(&marker)
+			if marker.Name != "start" || marker.Trial != "t1" || marker.Time() != clientTime + offset {
+				t.Errorf("marker = %+v, time %f", marker, marker.Time())
+			}
+		case TypeConnection:
+			var connection Connection
+			record.Decode(&connection)
+			if connection.Event != "disconnect" || connection.Reason != "EOF" {
+				t.Errorf("connection = %+v", connection)
+			}
+		}
+		typeList = append(typeList, record.Type)
+		previous = record
+	}
+	want := []string{TypeHeader, TypeNavigation, TypeMarker, TypeConnection}
+	if !reflect.DeepEqual(typeList, want) {
+		t.Errorf("types = %v, want %v", typeList, want)
+	}
+}
+
+func TestMarkerTime(t *testing.T) {
+	clientTime := 1000.0
+	offset := -20.0
+	tests := []struct {
+		marker Marker
+		want float64
+	}{
+		{Marker{ServerTime: 1100}, 1100},
+		{Marker{ServerTime: 1100, ClientTime: &clientTime}, 1100},
+		{Marker{ServerTime: 1100, ClientTime: &clientTime, ClientOffset: &offset}, 980},
+	}
+	for _, test := range tests {
+		if got := test.marker.Time(); got != test.want {
+			t.Errorf("%+v: Time() = %f, want %f", test.marker, got, test.want)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	goTime := time.Date(2016, 10, 16, 7, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		line string
+		wantType string
+		wantTime time.Time
+		wantPayload string
+		err bool
+	}{
+		{
+			name: "record",
+			line: `{"v":1,"type":"navigation","seq":3,"mono":100,"time":"2016-10-16T07:00:00Z","payload":{"url":"/a.html"}}`,
+			wantType: TypeNavigation,
+			wantTime: goTime,
+			wantPayload: `{"url":"/a.html"}`,
+		},
+		{
+			name: "legacy request path",
+			line: `{"request path":"/a.html","unix time":1476601200}`,
+			wantType: TypeNavigation,
+			wantTime: time.Unix(1476601200, 0),
+			wantPayload: `{"url":"/a.html"}`,
+		},
+		{
+			name: "legacy frame",
+			line: `{"category":"tracker","request":"get","statuscode":200,"values":{"frame":{"avg":{"x":10,"y":20},"GoTime":"2016-10-16T07:00:00Z"}}}`,
+			wantType: TypeFrame,
+			wantTime: goTime,
+			wantPayload: `{"avg":{"x":10,"y":20},"GoTime":"2016-10-16T07:00:00Z"}`,
+		},
+		{
+			name: "legacy message without frame",
+			line: `{"category":"tracker","values":{"statemessage":"ok"}}`,
+			err: true,
+		},
+		{
+			// 途中の版で書かれていた形で、今は読みません。
+			name: "unknown legacy line",
+			line: `{"rule event":{"rule":"r"}}`,
+			err: true,
+		},
+		{
+			name: "broken json",
+			line: `{"v":1,"type":`,
+			err: true,
+		},
+	}
+	for _, test := range tests {
+		record, err := ParseLine([]byte(test.line))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: want an error, got %+v", test.name, record)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if record.Type != test.wantType || !record.Time.Equal(test.wantTime) {
+			t.Errorf("%s: got %s at %s, want %s at %s", test.name, record.Type, record.Time, test.wantType, test.wantTime)
+		}
+		if !sameJson(record.Payload, []byte(test.wantPayload)) {
+			t.Errorf("%s: payload = %s, want %s", test.name, record.Payload, test.wantPayload)
+		}
+	}
+}
+
+func sameJson(a []byte, b []byte) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// 空の行は飛ばし、読めない行は行の番号の付いた *LineError を返して読み続けます。
+func TestReaderLineError(t *testing.T) {
+	input := "{\"request path\":\"/a.html\"}\n\n{broken\n{\"request path\":\"/b.html\"}"
+	reader := NewReader(bytes.NewBufferString(input))
+	record, err := reader.Read()
+	if err != nil || record.Type != TypeNavigation {
+		t.Fatalf("first line: %+v, %v", record, err)
+	}
+	_, err = reader.Read()
+	lineErr, ok := err.(*LineError)
+	if !ok || lineErr.Line != 3 {
+		t.Fatalf("want a LineError on line 3, got %v", err)
+	}
+	record, err = reader.Read()
+	if err != nil || record.Type != TypeNavigation {
+		t.Fatalf("last line without a newline: %+v, %v", record, err)
+	}
+	_, err = reader.Read()
+	if err != io.EOF {
+		t.Errorf("want io.EOF, got %v", err)
+	}
+}
+
+func TestGroupRotatedLogs(t *testing.T) {
+	tests := []struct {
+		name string
+		fileNameList []string
+		want []RotatedLog
+	}{
+		{
+			name: "not rotated",
+			fileNameList: []string{"a/log.json", "b/log.json.gz"},
+			want: []RotatedLog{
+				{Name: "a/log.json", FileNameList: []string{"a/log.json"}},
+				{Name: "b/log.json", FileNameList: []string{"b/log.json.gz"}},
+			},
+		},
+		{
+			name: "rotated parts in time order and the current file last",
+			fileNameList: []string{
+				"p/s1.json",
+				"p/s1.20161016-070149.json.gz",
+				"p/s1.20161016-070145-2.json.gz",
+				"p/s1.20161016-070145.json.gz",
+				"p/s1.20161016-070145-1.json",
+				"p/s2.20161016-080000.json.gz",
+			},
+			want: []RotatedLog{
+				{Name: "p/s1.json", FileNameList: []string{
+					"p/s1.20161016-070145.json.gz",
+					"p/s1.20161016-070145-1.json",
+					"p/s1.20161016-070145-2.json.gz",
+					"p/s1.20161016-070149.json.gz",
+					"p/s1.json",
+				}},
+				{Name: "p/s2.json", FileNameList: []string{"p/s2.20161016-080000.json.gz"}},
+			},
+		},
+		{
+			name: "name that only looks like a date",
+			fileNameList: []string{"log.2016.json", "log.json"},
+			want: []RotatedLog{
+				{Name: "log.2016.json", FileNameList: []string{"log.2016.json"}},
+				{Name: "log.json", FileNameList: []string{"log.json"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		got := GroupRotatedLogs(test.fileNameList)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: GroupRotatedLogs() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
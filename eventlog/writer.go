@@ -0,0 +1,91 @@
+package eventlog
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// 記録を一行づつ書き出すもの
+// 複数の goroutine から同時に使えます。
+type Writer struct {
+	lock sync.Mutex
+	w io.Writer
+	start time.Time
+	seq uint64
+}
+
+// w に書き出す Writer を作ります。
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, start: time.Now()}
+}
+
+// 最初の記録として Header を書き出します。
+func (w *Writer) WriteHeader(software string, softwareVersion string) error {
+	return w.WriteAt(TypeHeader, w.start, &Header{
+		Software: software,
+		SoftwareVersion: softwareVersion,
+		Start: w.start,
+	})
+}
+
+// 回転した後のファイルの先頭に置く Header の記録を返します。
+// seq は数えず、last (回転する前のファイルの最後の行)と同じ番号と時刻にするので、続きのファイルでも seq と mono は続いたままです。
+// 書き込みを待っている記録があるので、Writer が最後に付けた番号ではなく、実際に書き込まれた行に合わせます。
+func (w *Writer) HeaderRecord(software string, softwareVersion string, last []byte) []byte {
+	data, err := json.Marshal(&Header{
+		Software: software,
+		SoftwareVersion: softwareVersion,
+		Start: w.start,
+	})
+	if err != nil {
+		return nil
+	}
+	record := Record{
+		Version: SchemaVersion,
+		Type: TypeHeader,
+		Time: w.start,
+		Payload: data,
+	}
+	var previous Record
+	if json.Unmarshal(last, &previous) == nil {
+		record.Seq = previous.Seq
+		record.Mono = previous.Mono
+		record.Time = previous.Time
+	}
+	line, err := json.Marshal(&record)
+	if err != nil {
+		return nil
+	}
+	return append(line, '\n')
+}
+
+// 今の時刻で記録を一つ書き出します。
+func (w *Writer) Write(recordType string, payload interface{}) error {
+	return w.WriteAt(recordType, time.Now(), payload)
+}
+
+// 時刻 t (time.Now() で得たもの)の記録を一つ書き出します。
+func (w *Writer) WriteAt(recordType string, t time.Time, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.seq += 1
+	line, err := json.Marshal(&Record{
+		Version: SchemaVersion,
+		Type: recordType,
+		Seq: w.seq,
+		Mono: int64(t.Sub(w.start)),
+		Time: t,
+		Payload: data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(line, '\n'))
+	return err
+}
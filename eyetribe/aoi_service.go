@@ -0,0 +1,75 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// /aoi.json で返すもの
+type CurrentAOIResult struct {
+	Url string `json:"url"` // 最後に表示したページ(分からなければ "")
+	SetName string `json:"set"` // 使っている AOI の組の名前(どの組にも合わなければ "")
+	Targets []*EyeTrackCheckPoint `json:"targets"`
+}
+
+// 時刻ごとに、その時表示していたページの対象のリストを探すもの
+// 同じページが続く間は探し直さないようにします。
+type targetFinder struct {
+	config EyeTrackCheckConfig
+	pages *PageHistory
+	lastUrl string
+	lastTargets []*EyeTrackCheckPoint
+	found bool
+}
+
+func (s *HttpService) newTargetFinder() *targetFinder {
+	return &targetFinder{
+		config: s.GetEyeTrackCheckConfig(),
+		pages: s.Pages,
+	}
+}
+
+// 時刻 t に表示していたページの対象のリストを返します。
+func (f *targetFinder) At(t time.Time) []*EyeTrackCheckPoint {
+	url := ""
+	if view, ok := f.pages.At(t); ok {
+		url = view.Url
+	}
+	if !f.found || url != f.lastUrl {
+		f.lastTargets, _ = f.config.TargetsFor(url)
+		f.lastUrl = url
+		f.found = true
+	}
+	return f.lastTargets
+}
+
+// 要求がページの表示かどうかを返します。GET だけをページの表示とします。
+// 画像等でも、どれかの AOI の組の URL に合うものはページの表示とします。
+func (s *HttpService) isPageRequest(r *http.Request) bool {
+	if r.Method != "GET" {
+		return false
+	}
+	if IsPagePath(r.URL.Path) {
+		return true
+	}
+	config := s.GetEyeTrackCheckConfig()
+	_, setName := config.TargetsFor(r.RequestURI)
+	return setName != ""
+}
+
+// 今表示しているページと、そこで使っている対象のリストを返します。
+func (s *HttpService) ServeCurrentAOI(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	var result CurrentAOIResult
+	if view, ok := s.Pages.Current(); ok {
+		result.Url = view.Url
+	}
+	config := s.GetEyeTrackCheckConfig()
+	result.Targets, result.SetName = config.TargetsFor(result.Url)
+	if result.Targets == nil {
+		result.Targets = []*EyeTrackCheckPoint{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(&result)
+}
@@ -0,0 +1,261 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"../eventlog"
+)
+
+// キャリブレーションの返事を待つ時間
+const CalibrationResponseTimeout = 5 * time.Second
+
+// 目標の点に対する誤差(度)
+type CalibrationAccuracy struct {
+	Average float64 `json:"ad"`
+	Left float64 `json:"adl"`
+	Right float64 `json:"adr"`
+}
+
+// 目標の点に対する誤差(ピクセル)
+type CalibrationMeanError struct {
+	Average float64 `json:"mep"`
+	Left float64 `json:"mepl"`
+	Right float64 `json:"mepr"`
+}
+
+// 目標の点に対する標準偏差(ピクセル)
+type CalibrationDeviation struct {
+	Average float64 `json:"asd"`
+	Left float64 `json:"asdl"`
+	Right float64 `json:"asdr"`
+}
+
+// キャリブレーションの点一つ分の結果
+type CalibrationPoint struct {
+	State int64 `json:"state"` // 0: 再キャリブレーションが必要, 1: 精度が悪い, 2: 良い
+	Cp *Point `json:"cp"` // 目標の点の座標
+	Mecp *Point `json:"mecp"` // 推定された視線の平均の座標
+	Acd *CalibrationAccuracy `json:"acd"`
+	Mepix *CalibrationMeanError `json:"mepix"`
+	Asdp *CalibrationDeviation `json:"asdp"`
+}
+
+// tracker が返すキャリブレーションの結果
+type CalibrationResult struct {
+	Result bool `json:"result"` // キャリブレーションに成功したか
+	Deg float64 `json:"deg"` // 全体の誤差(度)
+	DegL float64 `json:"degl"`
+	DegR float64 `json:"degr"`
+	CalibPoints []*CalibrationPoint `json:"calibpoints"`
+}
+
+// キャリブレーションを行えるもの
+// HttpService はこれを通して tracker をキャリブレートします。
+type Calibrator interface {
+	// pointCount 個の点でのキャリブレーションを始めます。
+	CalibrationStart(pointCount int) error
+	// 座標 (x, y) の点を見てもらい始めます。
+	CalibrationPointStart(x int, y int) error
+	// 今の点を見てもらうのを終えます。最後の点であれば結果を返します(それ以外は nil)。
+	CalibrationPointEnd() (*CalibrationResult, error)
+	// キャリブレーションを中断します。
+	CalibrationAbort() error
+	// キャリブレーションの結果を消します。
+	CalibrationClear() error
+	// 現在のキャリブレーションの結果を返します。
+	CalibrationResultGet() (*CalibrationResult, error)
+}
+
+// キャリブレーションに使う点の座標を作ります。
+// pointCount は 9 か 12 か 16 で、画面の端から 10% 内側の格子状に並べます。
+func CalibrationPointList(pointCount int, screenWidth int64, screenHeight int64) ([]Point, error) {
+	var columns, rows int
+	switch pointCount {
+	case 9:
+		columns, rows = 3, 3
+	case 12:
+		columns, rows = 4, 3
+	case 16:
+		columns, rows = 4, 4
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported calibration point count: %d", pointCount))
+	}
+	marginX := float64(screenWidth) * 0.1
+	marginY := float64(screenHeight) * 0.1
+	stepX := (float64(screenWidth) - marginX * 2) / float64(columns - 1)
+	stepY := (float64(screenHeight) - marginY * 2) / float64(rows - 1)
+	result := []Point{}
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			result = append(result, Point{
+				X: float64(int(marginX + stepX * float64(column))),
+				Y: float64(int(marginY + stepY * float64(row))),
+			})
+		}
+	}
+	return result, nil
+}
+
+// pull タスクが受け取ったキャリブレーションの返事を、待っている方に渡します。
+// 誰も待っていなければ捨てます。
+func (c *EyeTribeConnection) dispatchCalibrationResponse(pushed *PushedMessage) {
+	response := &ResponseMessage{
+		Category: pushed.Category,
+		Request: pushed.Request,
+		StatusCode: pushed.StatusCode,
+	}
+	if len(pushed.Values) > 0 {
+		err := json.Unmarshal(pushed.Values, &response.Values)
+		if err != nil {
+			fmt.Printf("calibration response decode error: %q\n", err)
+		}
+	}
+	select {
+	case c.CalibrationResponse <- response:
+	default:
+		fmt.Printf("calibration response dropped: %s\n", response.Request)
+	}
+}
+
+// キャリブレーションのリクエストを送って返事を待ちます。
+// pull タスクが動いている時は、返事は pull タスクから受け取ります。
+func (c *EyeTribeConnection) requestCalibration(request string, values interface{}) (*ResponseMessage, error) {
+	if c == nil {
+		return nil, errors.New("nil input")
+	}
+	c.CalibrationLock.Lock()
+	defer c.CalibrationLock.Unlock()
+	data := RequestMessage{
+		Category: "calibration",
+		Request: request,
+		Values: values,
+	}
+
+	var response *ResponseMessage
+	var err error
+	if !c.pullTaskRunning() {
+		// 誰も読んでいない heartbeat の返事やフレームが先に届いていることがあるので、
+		// キャリブレーションの返事が来るまで読み飛ばします。
+		c.ConnectionLock.Lock()
+		err = c.Connection.PushOneJson(&data)
+		for err == nil {
+			response, err = c.Connection.PullOneJson()
+			if err == nil && response.Category == "calibration" {
+				break
+			}
+		}
+		c.ConnectionLock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}else{
+		// 前に取りこぼした返事が残っていれば捨てておきます。
+		select {
+		case <- c.CalibrationResponse:
+		default:
+		}
+		c.ConnectionLock.Lock()
+		err = c.Connection.PushOneJson(&data)
+		c.ConnectionLock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case response = <- c.CalibrationResponse:
+		case <- time.After(CalibrationResponseTimeout):
+			return nil, errors.New(fmt.Sprintf("calibration %s: no response from server", request))
+		}
+	}
+	if response.StatusCode != 200 {
+		reason := ""
+		if v, ok := response.Values["statusmessage"].(string); ok {
+			reason = v
+		}
+		return nil, errors.New(fmt.Sprintf("calibration %s: server response code is %d %s", request, response.StatusCode, reason))
+	}
+	return response, nil
+}
+
+// 返事の values から calibresult を取り出します。無ければ nil を返します。
+func parseCalibrationResult(values map[string]interface{}) (*CalibrationResult, error) {
+	v, ok := values["calibresult"]
+	if ok == false || v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result CalibrationResult
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// キャリブレーションの結果を log に書き出します。
+func (c *EyeTribeConnection) PutCalibrationLog(result *CalibrationResult) error {
+	return c.PutLogRecord(eventlog.TypeCalibration, result)
+}
+
+// pointCount 個の点でのキャリブレーションを始めます。
+func (c *EyeTribeConnection) CalibrationStart(pointCount int) error {
+	_, err := c.requestCalibration("start", map[string]int{"pointcount": pointCount})
+	return err
+}
+
+// 座標 (x, y) の点を見てもらい始めます。
+func (c *EyeTribeConnection) CalibrationPointStart(x int, y int) error {
+	_, err := c.requestCalibration("pointstart", map[string]int{"x": x, "y": y})
+	return err
+}
+
+// 今の点を見てもらうのを終えます。
+// 最後の点であれば tracker が結果を返してくるので、それを log に書き出して返します。
+func (c *EyeTribeConnection) CalibrationPointEnd() (*CalibrationResult, error) {
+	response, err := c.requestCalibration("pointend", nil)
+	if err != nil {
+		return nil, err
+	}
+	result, err := parseCalibrationResult(response.Values)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	c.setCalibrated(result.Result)
+	c.PutCalibrationLog(result)
+	return result, nil
+}
+
+// キャリブレーションを中断します。
+func (c *EyeTribeConnection) CalibrationAbort() error {
+	_, err := c.requestCalibration("abort", nil)
+	return err
+}
+
+// キャリブレーションの結果を消します。
+func (c *EyeTribeConnection) CalibrationClear() error {
+	_, err := c.requestCalibration("clear", nil)
+	if err == nil {
+		c.setCalibrated(false)
+	}
+	return err
+}
+
+// 現在のキャリブレーションの結果を返します。
+func (c *EyeTribeConnection) CalibrationResultGet() (*CalibrationResult, error) {
+	response, err := c.requestCalibration("result", nil)
+	if err != nil {
+		return nil, err
+	}
+	result, err := parseCalibrationResult(response.Values)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, errors.New("server response has no calibresult field")
+	}
+	return result, nil
+}
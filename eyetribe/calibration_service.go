@@ -0,0 +1,120 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// キャリブレーション用の HTTP の返事
+type CalibrationServiceResponse struct {
+	Error string `json:"error,omitempty"`
+	Points []Point `json:"points,omitempty"` // start で返す、見てもらう点の座標
+	ScreenWidth int64 `json:"screen_width,omitempty"`
+	ScreenHeight int64 `json:"screen_height,omitempty"`
+	Result *CalibrationResult `json:"result,omitempty"`
+}
+
+// キャリブレーション用の返事を JSON で書き出します。
+func writeCalibrationResponse(w http.ResponseWriter, response *CalibrationServiceResponse, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		response = &CalibrationServiceResponse{Error: err.Error()}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(response)
+}
+
+// キャリブレーションを始めます。
+// pointcount(9 か 12 か 16, default は 9)を受け取り、見てもらう点の座標を返します。
+func (s *HttpService) ServeCalibrationStart(w http.ResponseWriter, r *http.Request) {
+	pointCount := 9
+	count, err := strconv.Atoi(r.FormValue("pointcount"))
+	if err == nil {
+		pointCount = count
+	}
+	screenWidth, screenHeight := s.Source.ScreenSize()
+	points, err := CalibrationPointList(pointCount, screenWidth, screenHeight)
+	if err != nil {
+		writeCalibrationResponse(w, nil, err)
+		return
+	}
+	err = s.Calibrator.CalibrationStart(pointCount)
+	writeCalibrationResponse(w, &CalibrationServiceResponse{
+		Points: points,
+		ScreenWidth: screenWidth,
+		ScreenHeight: screenHeight,
+	}, err)
+}
+
+// x, y で指定された点を見てもらい始めます。
+func (s *HttpService) ServeCalibrationPointStart(w http.ResponseWriter, r *http.Request) {
+	x, err := strconv.Atoi(r.FormValue("x"))
+	if err != nil {
+		writeCalibrationResponse(w, nil, err)
+		return
+	}
+	y, err := strconv.Atoi(r.FormValue("y"))
+	if err != nil {
+		writeCalibrationResponse(w, nil, err)
+		return
+	}
+	err = s.Calibrator.CalibrationPointStart(x, y)
+	writeCalibrationResponse(w, &CalibrationServiceResponse{}, err)
+}
+
+// 今の点を見てもらうのを終えます。最後の点であれば結果も返します。
+func (s *HttpService) ServeCalibrationPointEnd(w http.ResponseWriter, r *http.Request) {
+	result, err := s.Calibrator.CalibrationPointEnd()
+	writeCalibrationResponse(w, &CalibrationServiceResponse{Result: result}, err)
+}
+
+// キャリブレーションを中断します。
+func (s *HttpService) ServeCalibrationAbort(w http.ResponseWriter, r *http.Request) {
+	err := s.Calibrator.CalibrationAbort()
+	writeCalibrationResponse(w, &CalibrationServiceResponse{}, err)
+}
+
+// キャリブレーションの結果を消します。
+func (s *HttpService) ServeCalibrationClear(w http.ResponseWriter, r *http.Request) {
+	err := s.Calibrator.CalibrationClear()
+	writeCalibrationResponse(w, &CalibrationServiceResponse{}, err)
+}
+
+// 現在のキャリブレーションの結果を、結果を表示するための画面の大きさと一緒に返します。
+func (s *HttpService) ServeCalibrationResult(w http.ResponseWriter, r *http.Request) {
+	result, err := s.Calibrator.CalibrationResultGet()
+	screenWidth, screenHeight := s.Source.ScreenSize()
+	writeCalibrationResponse(w, &CalibrationServiceResponse{
+		ScreenWidth: screenWidth,
+		ScreenHeight: screenHeight,
+		Result: result,
+	}, err)
+}
+
+// キャリブレーション用の HTTP の入り口を登録します。
+// Calibrator が無い GazeSource の場合は何もしません。
+func (s *HttpService) handleCalibration() {
+	if s.Calibrator == nil {
+		return
+	}
+	s.Mux.HandleFunc("/calibration/start.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeCalibrationStart(w, r)
+	})
+	s.Mux.HandleFunc("/calibration/pointstart.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeCalibrationPointStart(w, r)
+	})
+	s.Mux.HandleFunc("/calibration/pointend.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeCalibrationPointEnd(w, r)
+	})
+	s.Mux.HandleFunc("/calibration/abort.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeCalibrationAbort(w, r)
+	})
+	s.Mux.HandleFunc("/calibration/clear.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeCalibrationClear(w, r)
+	})
+	s.Mux.HandleFunc("/calibration/result.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeCalibrationResult(w, r)
+	})
+}
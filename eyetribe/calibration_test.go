@@ -0,0 +1,57 @@
+package eyetribe
+
+import (
+	"testing"
+)
+
+// pull タスクが動いていない時は、キャリブレーションの返事を直接読みます。
+// 前に送った heartbeat の返事が残っていても、それを返事と取り違えません。
+func TestCalibrationWithoutPullTask(t *testing.T) {
+	config := DefaultMockServerConfig()
+	config.Calibrated = false
+	server := startTestServer(t, config)
+	c := connectTestServer(t, server)
+	defer c.Close()
+	heartbeat := func() {
+		c.ConnectionLock.Lock()
+		err := c.Connection.PushOneJson(&RequestMessage{Category: "heartbeat"})
+		c.ConnectionLock.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pointList, err := CalibrationPointList(9, 1920, 1080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heartbeat()
+	err = c.CalibrationStart(len(pointList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result *CalibrationResult
+	for i, point := range pointList {
+		heartbeat()
+		err = c.CalibrationPointStart(int(point.X), int(point.Y))
+		if err != nil {
+			t.Fatalf("point %d start: %s", i, err)
+		}
+		heartbeat()
+		result, err = c.CalibrationPointEnd()
+		if err != nil {
+			t.Fatalf("point %d end: %s", i, err)
+		}
+		if i < len(pointList) - 1 && result != nil {
+			t.Errorf("point %d: result before the last point", i)
+		}
+	}
+	if result == nil || len(result.CalibPoints) != len(pointList) {
+		t.Fatalf("result = %+v, want %d points", result, len(pointList))
+	}
+	heartbeat()
+	got, err := c.CalibrationResultGet()
+	if err != nil || got == nil || got.Deg != result.Deg {
+		t.Errorf("CalibrationResultGet() = %+v, %v, want %+v", got, err, result)
+	}
+}
@@ -0,0 +1,59 @@
+package eyetribe
+
+import (
+	"time"
+	"../aoi"
+)
+
+// これより間の空いたサンプルは、見ていた時間に数えません(ミリ秒)
+const MaxSampleIntervalMsec = 100.0
+
+// 時刻を aoi.Metrics で使う Unix時間のミリ秒にします。
+func unixMillisecond(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
+// start から後に受け取ったサンプルと、start から後に始まった注視から、対象ごとの指標を求めます。
+// dwellFromFixations が true なら注視を、false ならサンプルを見ていたものとして数えます。
+// 対象はその時に表示していたページのものを使います。
+func (s *HttpService) GetEyeTrackCheckResult(start time.Time, dwellFromFixations bool) EyeTrackCheckResult {
+	counter := aoi.NewMetricsCounter(unixMillisecond(start), dwellFromFixations)
+	targets := s.newTargetFinder()
+	counter.AddTargets(targets.At(time.Now()))
+
+	sampleList := s.Source.SamplesBetween(start, time.Time{})
+	for i := range sampleList {
+		sample := sampleList[i]
+		if !sample.Valid {
+			// 外れ値っぽいので無視します。
+			continue
+		}
+		t := unixMillisecond(sample.Time)
+		duration := 0.0
+		for j := i + 1; j < len(sampleList); j++ {
+			if sampleList[j].Valid {
+				duration = unixMillisecond(sampleList[j].Time) - t
+				break
+			}
+		}
+		if duration > MaxSampleIntervalMsec {
+			duration = 0
+		}
+		sample = s.WithPagePosition(sample)
+		counter.AddSample(targets.At(sample.Time), t, duration, sample.Gaze())
+	}
+
+	data_list := s.GetFixationDataList()
+	for i := range data_list {
+		data := data_list[i]
+		if start.Sub(data.GoTime) > 0 {
+			continue
+		}
+		gaze := aoi.ScreenGaze(data.X, data.Y)
+		if page := s.PagePositionAt(data.GoTime, data.X, data.Y); page != nil {
+			gaze = aoi.Gaze{X: data.X, Y: data.Y, PageX: page.X, PageY: page.Y, OnPage: page.Inside}
+		}
+		counter.AddFixation(targets.At(data.GoTime), unixMillisecond(data.GoTime), unixMillisecond(data.EndGoTime), data.Duration, gaze)
+	}
+	return EyeTrackCheckResult(counter.Result())
+}
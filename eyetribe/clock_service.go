@@ -0,0 +1,166 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"../clocksync"
+	"../eventlog"
+)
+
+// tracker の時計のずれを返せるもの
+type ClockSource interface {
+	TrackerClock() (clocksync.Estimate, bool)
+}
+
+// ブラウザから報告された時計のずれ
+type BrowserClocks struct {
+	lock sync.Mutex
+	clocks map[string]*eventlog.Clock
+}
+
+func NewBrowserClocks() *BrowserClocks {
+	return &BrowserClocks{clocks: map[string]*eventlog.Clock{}}
+}
+
+// ブラウザの時計のずれを覚えます。
+func (b *BrowserClocks) Set(clock *eventlog.Clock) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	copied := *clock
+	b.clocks[clock.Client] = &copied
+}
+
+// client のブラウザの時計のずれを返します。
+func (b *BrowserClocks) Get(client string) (eventlog.Clock, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	clock, ok := b.clocks[client]
+	if !ok {
+		return eventlog.Clock{}, false
+	}
+	return *clock, true
+}
+
+// 覚えている全てのブラウザの時計のずれを返します。
+func (b *BrowserClocks) All() map[string]eventlog.Clock {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	result := map[string]eventlog.Clock{}
+	for client, clock := range b.clocks {
+		result[client] = *clock
+	}
+	return result
+}
+
+// /clock/ping.json の返事(時刻は全て Unix 時間のミリ秒)
+type ClockPingResponse struct {
+	ClientSend float64 `json:"client send"` // 受け取った client_send をそのまま返します
+	ServerReceive float64 `json:"server receive"`
+	ServerSend float64 `json:"server send"`
+}
+
+// /clock/report.json で受け取るもの
+type ClockReport struct {
+	Client string `json:"client"` // 省略すると接続元の IP アドレス
+	Offset float64 `json:"offset msec"` // サーバ - ブラウザ
+	RoundTrip float64 `json:"round trip msec"`
+	Samples int `json:"samples"`
+}
+
+// /clock.json の返事
+type ClockResult struct {
+	ServerTime float64 `json:"server time"` // Unix 時間のミリ秒
+	Tracker *clocksync.Estimate `json:"tracker"` // まだ分からなければ null
+	Browsers map[string]eventlog.Clock `json:"browsers"`
+}
+
+// ブラウザの名前を返します。指定が無ければ接続元の IP アドレスにします。
+func clientName(r *http.Request, given string) string {
+	if given != "" {
+		return given
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NTP と同じように、ブラウザが時計のずれを測るための往復を一回受け付けます。
+// ブラウザは client_send に自分の時計(Date.now())を入れて送り、返事を受け取った時刻と合わせて
+// offset = ((server receive - client send) + (server send - client receive)) / 2 を求めます。
+func (s *HttpService) ServeClockPing(w http.ResponseWriter, r *http.Request){
+	received := unixMillisecond(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	clientSend, _ := strconv.ParseFloat(r.FormValue("client_send"), 64)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(&ClockPingResponse{
+		ClientSend: clientSend,
+		ServerReceive: received,
+		ServerSend: unixMillisecond(time.Now()),
+	})
+}
+
+// ブラウザが測った時計のずれを受け取って、log に書き出します。
+func (s *HttpService) ServeClockReport(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	var report ClockReport
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, MaxMarkerBytes))
+	err := decoder.Decode(&report)
+	if err == nil && report.Samples <= 0 {
+		err = errors.New("samples must be positive")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	report.Client = clientName(r, report.Client)
+	clock := &eventlog.Clock{
+		Source: "browser",
+		Client: report.Client,
+		Offset: report.Offset,
+		Reference: unixMillisecond(time.Now()) - report.Offset,
+		RoundTrip: report.RoundTrip,
+		Samples: report.Samples,
+	}
+	s.BrowserClocks.Set(clock)
+	s.putLog(eventlog.TypeClock, clock)
+	encoder.Encode(clock)
+}
+
+// サーバの時計と、tracker やブラウザの時計のずれを返します。
+func (s *HttpService) ServeClock(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	result := ClockResult{
+		ServerTime: unixMillisecond(time.Now()),
+		Browsers: s.BrowserClocks.All(),
+	}
+	if source, ok := s.Source.(ClockSource); ok {
+		if estimate, ok := source.TrackerClock(); ok {
+			result.Tracker = &estimate
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(&result)
+}
+
+// 時計合わせ用の HTTP の入り口を登録します。
+func (s *HttpService) handleClock() {
+	s.Mux.HandleFunc("/clock.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeClock(w, r)
+	})
+	s.Mux.HandleFunc("/clock/ping.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeClockPing(w, r)
+	})
+	s.Mux.HandleFunc("/clock/report.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeClockReport(w, r)
+	})
+}
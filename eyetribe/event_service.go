@@ -0,0 +1,89 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"../gazeevent"
+)
+
+// サーバの時刻を付けた視線のイベント
+type EventData struct {
+	gazeevent.Event
+	GoTime time.Time `json:"GoTime"`
+	EndGoTime time.Time `json:"EndGoTime"`
+}
+
+// /events.json で返すもの
+type EventListResult struct {
+	Summary gazeevent.Summary `json:"summary"`
+	Events []EventData `json:"events"`
+}
+
+// GazeSample をイベントの判定に使うサンプルにします。
+func GazeSamplesToEventSamples(sampleList []GazeSample) []gazeevent.Sample {
+	fixationSamples := GazeSamplesToFixationSamples(sampleList)
+	result := make([]gazeevent.Sample, len(sampleList))
+	for i := range sampleList {
+		result[i] = gazeevent.Sample{
+			Sample: fixationSamples[i],
+			State: sampleList[i].State,
+		}
+	}
+	return result
+}
+
+// 設定に従ってイベントを見つける Classifier を作ります。
+func (s *HttpService) NewEventClassifier() (*gazeevent.Classifier, error) {
+	detector, err := s.NewFixationDetector()
+	if err != nil {
+		return nil, err
+	}
+	config := s.GetEyeTrackCheckConfig()
+	return gazeevent.NewClassifier(detector, config.Events, s.pixelsPerDegree()), nil
+}
+
+// start から end までに受け取ったサンプルをイベントに分けて返します。
+func (s *HttpService) GetEventList(start time.Time, end time.Time) (EventListResult, error) {
+	result := EventListResult{Events: []EventData{}}
+	classifier, err := s.NewEventClassifier()
+	if err != nil {
+		return result, err
+	}
+	sampleList := s.Source.SamplesBetween(start, end)
+	eventSamples := GazeSamplesToEventSamples(sampleList)
+	eventList := classifier.Classify(eventSamples)
+	for i := range eventList {
+		e := eventList[i]
+		result.Events = append(result.Events, EventData{
+			Event: e,
+			GoTime: sampleList[e.StartIndex].Time,
+			EndGoTime: sampleList[e.EndIndex].Time,
+		})
+	}
+	result.Summary = gazeevent.Summarize(eventList, gazeevent.SamplesDuration(eventSamples))
+	return result, nil
+}
+
+// 指定された時間内の fixation, saccade, blink, track loss と、そのまとめを返します。
+func (s *HttpService) ServeEventList(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	delta_millisecond := 10*1000 // default は 10秒前までのデータを確認します。
+	delta_millisecond_str := r.FormValue("delta_millisecond")
+	millisecond, err := strconv.Atoi(delta_millisecond_str)
+	if err == nil {
+		delta_millisecond = millisecond
+	}
+	check_time := time.Now().Add(-time.Duration(delta_millisecond) * time.Millisecond)
+
+	result, err := s.GetEventList(check_time, time.Time{})
+	if err != nil {
+		fmt.Printf("event config error: %q\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(&result)
+}
@@ -7,12 +7,14 @@ import (
 	"fmt"
 	"os"
 	"time"
-	"container/list"
-	"image"
-	"image/png"
-	"image/draw"
-	"net/http"
-	"strconv"
+	"sync"
+	"../aoi"
+	"../clocksync"
+	"../eventlog"
+	"../fixation"
+	"../gazeevent"
+	"../heatmap"
+	"../scanpath"
 )
 
 // 接続状態等を保存するための構造体
@@ -89,42 +91,129 @@ type OneFrameMessage struct {
 }
 
 // 指定の場所を確認していたかどうかの指定の場所
-type EyeTrackCheckPoint struct {
-	X float64 `json:"x"`
-	Y float64 `json:"y"`
-	Width float64 `json:"width"`
-	Height float64 `json:"height"`
-	Name string `json:"name"`
-}
+// 長方形の他に多角形、円、楕円も指定できます。
+type EyeTrackCheckPoint = aoi.Area
 
 // 指定の場所を確認していたかどうかを判定するための設定
 type EyeTrackCheckConfig struct {
-	Fixation *map[string]int `json:"fixation"` // そこを見ていたと判定される時に使う情報
-	TargetList []*EyeTrackCheckPoint `json:"targets"` // 対象の情報
+	Fixation *fixation.Config `json:"fixation"` // そこを見ていたと判定される時に使う情報
+	Events *gazeevent.Config `json:"events"` // 瞬きや saccade を見つける時に使う情報
+	HeatMap *heatmap.Config `json:"heatmap"` // heatmap の描き方
+	ScanPath *scanpath.Config `json:"scanpath"` // gaze plot (scanpath) の描き方
+	TargetList []*EyeTrackCheckPoint `json:"targets"` // 対象の情報(どの AOI の組にも合わないページで使います)
+	AOISetList []*aoi.Set `json:"aoi sets"` // ページの URL ごとの対象の情報
+	RuleList []*GazeRule `json:"rules"` // 視線に応じて発火する規則
+	CallbackUrlList []string `json:"callback urls"` // どの規則が発火しても RuleEvent を POST する先
+}
+
+// 設定を読み込んだ後に、誤りが無いかを確認して URL の正規表現を読み込みます。
+func (c *EyeTrackCheckConfig) Compile() error {
+	err := aoi.ValidateAreas(c.TargetList)
+	if err != nil {
+		return err
+	}
+	for i := range c.AOISetList {
+		if c.AOISetList[i] == nil {
+			continue
+		}
+		err = c.AOISetList[i].Compile()
+		if err != nil {
+			return err
+		}
+	}
+	ruleNames := map[string]bool{}
+	for i := range c.RuleList {
+		if c.RuleList[i] == nil {
+			continue
+		}
+		err = c.RuleList[i].Compile()
+		if err != nil {
+			return err
+		}
+		if ruleNames[c.RuleList[i].Name] {
+			return errors.New(fmt.Sprintf("rule %s: name is used twice", c.RuleList[i].Name))
+		}
+		ruleNames[c.RuleList[i].Name] = true
+	}
+	if c.HeatMap != nil {
+		err = c.HeatMap.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.ScanPath != nil {
+		return c.ScanPath.Validate()
+	}
+	return nil
+}
+
+// url のページで使う対象のリストと、その AOI の組の名前を返します。
+// 合う組が無ければ TargetList と "" を返します。
+func (c *EyeTrackCheckConfig) TargetsFor(url string) ([]*EyeTrackCheckPoint, string) {
+	set := aoi.FindSet(c.AOISetList, url)
+	if set == nil {
+		return c.TargetList, ""
+	}
+	return set.Targets, set.Name
 }
 
 // 指定の場所を確認していたかどうかの判定結果
-type EyeTrackCheckResult map[string]bool
+// 対象の名前ごとの、見ていた時間や最初に注視するまでの時間等の指標です。
+// 時刻(last look)は Unix時間のミリ秒です。
+type EyeTrackCheckResult map[string]*aoi.Metrics
+
+// 対象の名前ごとに、見ていたかどうかだけを返します。
+func (r EyeTrackCheckResult) Hits() map[string]bool {
+	result := map[string]bool{}
+	for name, m := range r {
+		result[name] = m.Hit
+	}
+	return result
+}
 
 // EyeTribe を使うための class
 type EyeTribeConnection struct {
 	Connection *JsonReaderWriter
+	ConnectionLock sync.Mutex // Connection の差し替えと送信、tracker の設定、QuitPullTask を守ります
+	HostAndPort string // 再接続の時に使う接続先
+	ReconnectMinInterval time.Duration // 再接続を試みる間隔の初期値
+	ReconnectMaxInterval time.Duration // 再接続を試みる間隔の最大値
 	ScreenWidth int64
 	ScreenHeight int64
-	HeartbeatTimeoutMillisecond int64
-	FrameList *list.List
+	FrameRate int64 // tracker が一秒間に送ってくるフレームの数
+	IsCalibrated bool // tracker がキャリブレートされているか(GetServerStatus() とキャリブレーションの結果で更新します。ConnectionLock で守ります)
+	CalibrationLock sync.Mutex // キャリブレーションのリクエストを一つづつ送るためのもの
+	CalibrationResponse chan *ResponseMessage // pull タスクが受け取ったキャリブレーションの返事
+	FrameStore *FrameStore // 受け取ったフレームを GazeSample にして溜め込む先
+	GazeBroadcaster // 受け取ったフレームを GazeSample にして流す先
 	QuitHeartbeatTask chan bool
-	QuitPullTask chan bool
-	HeatMapDrawImage *image.Image
-	CheckConfig EyeTrackCheckConfig
-	LogFile *os.File
+	QuitPullTask chan bool // pull タスクに終了を伝えます(動いていなければ nil。ConnectionLock で守ります)
+	pullTaskDone chan bool // pull タスクが終わったら閉じられます
+	LogFile *eventlog.File // 裏でまとめて書き込む log file
+	LogFileName string // LogFile の名前
+	LogOptions eventlog.FileOptions // log file の待ち行列の大きさ、fsync の間隔、回転と圧縮の設定
+	logWriter *eventlog.Writer // LogFile に記録を書き出すもの
+	logStats eventlog.Stats // 閉じた log file の書き込みの状況の合計
+	LogLock sync.Mutex // LogFile への書き込みと閉じるのを守ります
+	Clock *clocksync.Estimator // tracker の時計とサーバの時計のずれ
+	ClockLogInterval time.Duration // この間隔で Clock の推定値を log に書き出します
+	lastClockLog time.Time
+}
+
+// tracker の設定(GetServerStatus() で受け取ったもの)
+type TrackerSettings struct {
+	Address string `json:"address"`
+	ScreenWidth int64 `json:"screen width"`
+	ScreenHeight int64 `json:"screen height"`
+	FrameRate int64 `json:"frame rate"`
+	IsCalibrated bool `json:"is calibrated"`
 }
 
 // 見ていた(Fixation チェックに成功した)とされる座標とその時間を記録したデータ
 type FixateData struct {
-	X float64
-	Y float64
-	GoTime time.Time
+	fixation.Fixation // 座標と時刻(tracker の時刻)
+	GoTime time.Time `json:"GoTime"` // 注視を始めた時刻(サーバの時計)
+	EndGoTime time.Time `json:"EndGoTime"` // 注視を終えた時刻(サーバの時計)
 }
 
 // リクエスト型をJsonにエンコードしたらどうなるかを Stdout に吐き出します
@@ -138,27 +227,31 @@ func (m *RequestMessage) DumpJsonToStdout(){
 // 終了させるには StopHeartbeatTask() を呼び出します。
 func (c *EyeTribeConnection) StartHeartbeatTask(interval time.Duration) {
 	c.QuitHeartbeatTask = make(chan bool)
-	tick := time.Tick(interval)
-	go func(){
+	ticker := time.NewTicker(interval)
+	go func(quit chan bool){
+		defer ticker.Stop()
 		quitFlug := false
 		for(quitFlug != true) {
 			select {
-			case <- c.QuitHeartbeatTask:
+			case <- quit:
 				quitFlug = true
 				break
-			case <- tick:
+			case <- ticker.C:
 				data := RequestMessage{
 					Category: "heartbeat",
 				}
 				//fmt.Println("send heartbeat message.")
-				if err := c.Connection.PushOneJson(&data); err != nil {
-					fmt.Printf("heartbeat: PushOneJson() error. quit: %q\n", err)
-					quitFlug = true
-					break
+				c.ConnectionLock.Lock()
+				err := c.Connection.PushOneJson(&data)
+				c.ConnectionLock.Unlock()
+				if err != nil {
+					// 接続が切れた場合は pull タスクの方で再接続されるので、
+					// ここでは終了せずに次の heartbeat を待ちます。
+					fmt.Printf("heartbeat: PushOneJson() error: %q\n", err)
 				}
 			}
 		}
-	}()
+	}(c.QuitHeartbeatTask)
 }
 
 // heartbeat タスクを終了します
@@ -173,34 +266,51 @@ func (c *EyeTribeConnection) StopHeartbeatTask() {
 // サーバに接続します
 // host_and_port は "hostname:port" と書きます
 func CreateServerConnection(host_and_port string) (*EyeTribeConnection, error) {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", host_and_port)
-	if err != nil {
-		return nil, err
-	}
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	rw, err := DialJsonReaderWriter(host_and_port)
 	if err != nil {
 		return nil, err
 	}
 	ret := &EyeTribeConnection {
-		Connection: &JsonReaderWriter{
-			Decoder: json.NewDecoder(conn),
-			Encoder: json.NewEncoder(conn),
-			Connection: conn,
-		},
-		FrameList: list.New(),
+		Connection: rw,
+		HostAndPort: host_and_port,
+		ReconnectMinInterval: 1 * time.Second,
+		ReconnectMaxInterval: 30 * time.Second,
+		FrameStore: NewFrameStore(0),
+		CalibrationResponse: make(chan *ResponseMessage, 1),
+		Clock: clocksync.NewEstimator(),
+		ClockLogInterval: 10 * time.Second,
 	}
 	calibrated, interval, err := ret.GetServerStatus()
 	if err != nil {
 		return nil, err
 	}
 	if calibrated != true {
-		return nil, errors.New("Server is not calibrated")
+		// キャリブレーションは HTTP 越しに行えるので、ここでは警告だけにします。
+		fmt.Println("Server is not calibrated. calibrate from /calibration.html")
 	}
 	ret.StartHeartbeatTask(time.Duration(int64(interval) / 2))
 
 	return ret, nil
 }
 
+// TCP でサーバに接続して JsonReaderWriter を作ります
+// host_and_port は "hostname:port" と書きます
+func DialJsonReaderWriter(host_and_port string) (*JsonReaderWriter, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", host_and_port)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &JsonReaderWriter{
+		Decoder: json.NewDecoder(conn),
+		Encoder: json.NewEncoder(conn),
+		Connection: conn,
+	}, nil
+}
+
 // 接続を切ります
 func (rw *JsonReaderWriter) Close() error {
 	if rw == nil {
@@ -217,10 +327,20 @@ func (c *EyeTribeConnection) Close() error {
 	if c == nil {
 		return errors.New("nil input")
 	}
+	// pull タスクが受信待ちのまま止まらないように、終了を伝えてから接続を切り、
+	// それから pull タスクが終わるのを待ちます。
+	// 再接続の途中で heartbeat タスクが作り直されることがあるので、heartbeat はその後で止めます。
+	done := c.signalPullTaskQuit()
+	c.ConnectionLock.Lock()
+	err := c.Connection.Close()
+	c.ConnectionLock.Unlock()
+	if done != nil {
+		<- done
+		c.setPullTask(nil)
+	}
 	c.StopHeartbeatTask()
-	c.StopPullFrameTask()
-
-	return c.Connection.Close()
+	c.CloseLogFile()
+	return err
 }
 
 // 一つのJSONメッセージを送信します
@@ -298,61 +418,84 @@ func (c *EyeTribeConnection) GetServerStatus() (bool, time.Duration, error) {
 		return false, 0, err
 	}
 	if interval <= 0 {
-		return false, 0, errors.New(fmt.Sprintf("server return heartbeatinterval is invalid: %p", interval))
+		return false, 0, errors.New(fmt.Sprintf("server return heartbeatinterval is invalid: %d", interval))
 	}
 
-	c.ScreenWidth, err = ConvInterfaceToInt64(response.Values, "screenresw")
+	screenWidth, err := ConvInterfaceToInt64(response.Values, "screenresw")
 	if err != nil {
 		return false, 0, err
 	}
-	c.ScreenHeight, err = ConvInterfaceToInt64(response.Values, "screenresh")
+	screenHeight, err := ConvInterfaceToInt64(response.Values, "screenresh")
 	if err != nil {
 		return false, 0, err
 	}
-	c.HeartbeatTimeoutMillisecond, err = ConvInterfaceToInt64(response.Values, "framerate")
+	frameRate, err := ConvInterfaceToInt64(response.Values, "framerate")
 	if err != nil {
 		return false, 0, err
 	}
-	
+	// HTTP の処理からも読まれるので、まとめて書き換えます。
+	c.ConnectionLock.Lock()
+	c.ScreenWidth = screenWidth
+	c.ScreenHeight = screenHeight
+	c.FrameRate = frameRate
+	c.IsCalibrated = iscalibrated.(bool)
+	c.ConnectionLock.Unlock()
+
 	return iscalibrated.(bool), time.Duration(interval) * time.Millisecond, nil
 }
 
-// []byte を log に書き出します。
-func (c *EyeTribeConnection) PutLog(data []byte) error {
+// 記録を一つ log に書き出します。
+func (c *EyeTribeConnection) PutLogRecord(recordType string, payload interface{}) error {
+	return c.PutLogRecordAt(recordType, time.Now(), payload)
+}
+
+// 時刻 t の記録を一つ log に書き出します。
+func (c *EyeTribeConnection) PutLogRecordAt(recordType string, t time.Time, payload interface{}) error {
 	if c == nil {
 		return errors.New("this is nil")
 	}
-	if c.LogFile == nil {
+	c.LogLock.Lock()
+	defer c.LogLock.Unlock()
+	if c.LogFile == nil || c.logWriter == nil {
 		return errors.New("log file not opend")
 	}
-	_, err := c.LogFile.Write(data)
-	if err != nil {
-		return err
-	}
-	_, err = c.LogFile.Write([]byte("\n"))
-	return err
+	return c.logWriter.WriteAt(recordType, t, payload)
 }
 
-func (c *EyeTribeConnection) PutLogJson(msg OneFrameMessage) error {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-	return c.PutLog(data)
-}
-
-func (c *EyeTribeConnection) PutLogString(str string) error {
-	return c.PutLog([]byte(str))
+// push mode で流れてくるメッセージ
+// values の中身は category によって違うので、後から読み直します。
+type PushedMessage struct {
+	Category string `json:"category"`
+	Request string `json:"request"`
+	StatusCode int `json:"statuscode"`
+	Values json.RawMessage `json:"values"`
 }
 
 // pullリクエストで一つフレームを取り出します。
+// キャリブレーションの返事が来た場合はそれを待っている方に渡して nil を返します。
 func (c *EyeTribeConnection) PullOneFrame() (*Frame, error) {
-	var response OneFrameMessage
-	err := c.Connection.Decoder.Decode(&response)
+	var pushed PushedMessage
+	err := c.Connection.Decoder.Decode(&pushed)
 	if err != nil {
 		fmt.Printf("decode error: %q\n", err)
 		return nil, err
 	}
+	if pushed.Category == "calibration" {
+		c.dispatchCalibrationResponse(&pushed)
+		return nil, nil
+	}
+	response := OneFrameMessage{
+		Category: pushed.Category,
+		Request: pushed.Request,
+		StatusCode: pushed.StatusCode,
+	}
+	if len(pushed.Values) > 0 {
+		err = json.Unmarshal(pushed.Values, &response.Values)
+		if err != nil {
+			fmt.Printf("frame decode error: %q\n", err)
+			return nil, nil
+		}
+	}
 	//fmt.Printf("responce: %q\n", response)
 	
 	if response.StatusCode != 200 {
@@ -371,60 +514,132 @@ func (c *EyeTribeConnection) PullOneFrame() (*Frame, error) {
 		return nil, nil
 	}
 	frame.GoTime = time.Now()
-	c.PutLogJson(response)
+	c.PutLogRecordAt(eventlog.TypeFrame, frame.GoTime, frame)
+	c.addClockPair(frame)
 	return frame, nil
 }
 
+// フレームの tracker の時刻と受け取った時刻で、時計のずれの推定を更新します。
+// ClockLogInterval 毎に推定値を log に書き出します。
+func (c *EyeTribeConnection) addClockPair(frame *Frame) {
+	if c.Clock == nil || frame.Time <= 0 {
+		return
+	}
+	c.Clock.Add(frame.Time, unixMillisecond(frame.GoTime))
+	if c.lastClockLog.IsZero() {
+		// 最初の推定値は ClockLogInterval の分の組が溜まってから書き出します。
+		c.lastClockLog = frame.GoTime
+	}
+	if c.ClockLogInterval <= 0 || frame.GoTime.Sub(c.lastClockLog) < c.ClockLogInterval {
+		return
+	}
+	estimate, ok := c.Clock.Estimate()
+	if !ok {
+		return
+	}
+	c.lastClockLog = frame.GoTime
+	c.PutLogRecordAt(eventlog.TypeClock, frame.GoTime, &eventlog.Clock{
+		Source: "tracker",
+		Offset: estimate.Offset,
+		Drift: estimate.Drift,
+		Reference: estimate.Reference,
+		Jitter: estimate.Jitter,
+		Samples: estimate.Samples,
+	})
+}
+
+// tracker の時計をサーバの時計に直すための推定値を返します。まだ分からなければ false を返します。
+func (c *EyeTribeConnection) TrackerClock() (clocksync.Estimate, bool) {
+	if c.Clock == nil {
+		return clocksync.Estimate{}, false
+	}
+	return c.Clock.Estimate()
+}
+
 // フレームを一つキャッシュに貯めます。
-// numFrames 以上のものは溢れるような処理をします。
-func (c *EyeTribeConnection) AddOneFrame(frame *Frame, numFrames int) error {
-	if c == nil {
+// FrameStore に入りきらないものは溢れるような処理をします。
+func (c *EyeTribeConnection) AddOneFrame(frame *Frame) error {
+	if c == nil || frame == nil {
 		// nil は許容します
 		return nil
 	}
-	if c.FrameList.Len() >= numFrames {
-		c.FrameList.Remove(c.FrameList.Front())
-	}
-	c.FrameList.PushBack(frame)
+	sample := FrameToGazeSample(frame)
+	c.FrameStore.Add(sample)
+	c.Publish(sample)
 	//fmt.Printf("frame: %q\n", frame)
 	return nil
 }
 
-// push mode でデータの取得を開始します。
-// だいたい second[秒] 分の frame を溜め込むようにします。
-func (c *EyeTribeConnection) StartPullFrameTask(second int64) {
-	// サーバを push mode にします。
+// サーバを push mode にします。
+func (c *EyeTribeConnection) SetPushMode() error {
 	pushModeMessage := &RequestPushModeMessage{
 		Category: "tracker",
 		Request: "set",
 		Values: &RequestPushModeMessageValue{Push: true, Version: 1},
 	}
+	c.ConnectionLock.Lock()
 	err := c.Connection.Encoder.Encode(pushModeMessage)
+	c.ConnectionLock.Unlock()
 	if err != nil {
-		return
+		return err
 	}
 	enc := json.NewEncoder(os.Stdout)
 	enc.Encode(&pushModeMessage)
+	return nil
+}
 
-	numFrames := int(second * 1000 / c.HeartbeatTimeoutMillisecond)
-	fmt.Printf("numFrames: %d\n", numFrames)
+// push mode でデータの取得を開始します。
+// だいたい second[秒] 分の frame を溜め込むようにします。
+func (c *EyeTribeConnection) StartPullFrameTask(second int64) {
+	err := c.SetPushMode()
+	if err != nil {
+		return
+	}
 
-	c.QuitPullTask = make(chan bool)
-	go func(){
+	c.ConnectionLock.Lock()
+	frameRate := c.FrameRate
+	c.ConnectionLock.Unlock()
+	if frameRate <= 0 {
+		frameRate = 30
+	}
+	numFrames := int(second * frameRate)
+	fmt.Printf("numFrames: %d\n", numFrames)
+	c.FrameStore.Resize(numFrames)
+
+	quit := make(chan bool)
+	c.ConnectionLock.Lock()
+	c.QuitPullTask = quit
+	c.pullTaskDone = make(chan bool)
+	done := c.pullTaskDone
+	c.ConnectionLock.Unlock()
+	go func(quit chan bool, done chan bool){
+		defer close(done)
 		quitFlug := false
 		for (quitFlug != true) {
 			select {
-			case <- c.QuitPullTask:
+			case <- quit:
 				quitFlug = true
 				break
 			default:
 				frame, err := c.PullOneFrame()
 				if err != nil {
+					select {
+					case <- quit:
+						// 終了するために接続が切られたので、繋ぎ直しません。
+						quitFlug = true
+						continue
+					default:
+					}
 					fmt.Printf("pull one frame return error: %q\n", err)
-					quitFlug = true
+					// 接続が切れたものとして、繋がるまで再接続を試みます。
+					err = c.Reconnect(err)
+					if err != nil {
+						fmt.Printf("reconnect return error. quit: %q\n", err)
+						quitFlug = true
+					}
 					break
 				}
-				err = c.AddOneFrame(frame, numFrames)
+				err = c.AddOneFrame(frame)
 				if err != nil {
 					fmt.Printf("add one frame return error: %q\n", err)
 					quitFlug = true
@@ -432,301 +647,142 @@ func (c *EyeTribeConnection) StartPullFrameTask(second int64) {
 				}
 			}
 		}
-	}()
+	}(quit, done)
+}
+
+// pull タスクに終了を伝えます。
+// pull タスクが終わったら閉じられる channel を返します(動いていなければ nil)。
+func (c *EyeTribeConnection) signalPullTaskQuit() chan bool {
+	c.ConnectionLock.Lock()
+	defer c.ConnectionLock.Unlock()
+	if c.QuitPullTask == nil {
+		return nil
+	}
+	select {
+	case <- c.QuitPullTask:
+		// 既に伝えてあります。
+	default:
+		close(c.QuitPullTask)
+	}
+	return c.pullTaskDone
 }
 
+// pull タスクを終了し、終わるまで待ちます。
 func (c *EyeTribeConnection) StopPullFrameTask() error {
 	if c == nil {
 		return errors.New("nil input")
 	}
-	if c.QuitPullTask == nil {
+	done := c.signalPullTaskQuit()
+	if done == nil {
 		return errors.New("push task is not started")
 	}
-	c.QuitPullTask <- true
+	<- done
+	c.setPullTask(nil)
 	return nil
 }
 
-func (c *EyeTribeConnection) StopHttpService() {
-	
+// pull タスクに終了を伝える channel を差し替えます。
+func (c *EyeTribeConnection) setPullTask(quit chan bool) {
+	c.ConnectionLock.Lock()
+	c.QuitPullTask = quit
+	c.ConnectionLock.Unlock()
 }
 
-
-func (c *EyeTribeConnection) GetFrameList() *list.List {
-	return c.FrameList
+// pull タスクが動いているかどうかを返します。
+func (c *EyeTribeConnection) pullTaskRunning() bool {
+	c.ConnectionLock.Lock()
+	defer c.ConnectionLock.Unlock()
+	return c.QuitPullTask != nil
 }
 
-func (c *EyeTribeConnection) LoadHeatMapDrawImage() (*image.Image, error) {
-	if c.HeatMapDrawImage != nil {
-		return c.HeatMapDrawImage, nil
-	}
-	imgFile, err := os.Open("heatmap_brush.png")
-	if err != nil {
-		return nil, err
-	}
-	defer imgFile.Close()
-	img, err := png.Decode(imgFile)
-	if err != nil {
-		return nil, err
-	}
-	c.HeatMapDrawImage = &img
-	return &img, nil
+// tracker がキャリブレートされているかどうかを覚えておきます。
+func (c *EyeTribeConnection) setCalibrated(calibrated bool) {
+	c.ConnectionLock.Lock()
+	c.IsCalibrated = calibrated
+	c.ConnectionLock.Unlock()
 }
 
-func (c *EyeTribeConnection) CreateHeatMapImage() (*image.RGBA, error) {
-	img := image.NewRGBA(image.Rect(0, 0, int(c.ScreenWidth), int(c.ScreenHeight)) )
-	draw.Draw(img, img.Bounds(), image.Transparent, image.ZP, draw.Src)
+func (c *EyeTribeConnection) GetFrameStore() *FrameStore {
+	return c.FrameStore
+}
 
-	drawImage, err := c.LoadHeatMapDrawImage()
-	if err != nil {
-		return nil, err
+// log の書き出し先を fileName に切り替えます。前の log file は閉じます。
+func (c *EyeTribeConnection) SetLogFile(fileName string) error {
+	var writer *eventlog.Writer
+	options := c.LogOptions
+	options.Header = func(last []byte) []byte {
+		return writer.HeaderRecord("eyebit_server", Version, last)
 	}
-	drawWidth := 100.0
-	drawHeight := 100.0
-	for f := c.FrameList.Front(); f != nil; f = f.Next() {
-		frame := f.Value.(*Frame)
-		if frame == nil || frame.Avg == nil {
-			continue
-		}
-		if frame.Avg.X <= 0.0 && frame.Avg.Y <= 0.0 {
-			// 外れ値っぽいので無視します。
-			continue
-		}
-		x := frame.Avg.X
-		y := frame.Avg.Y
-		x_start := x - drawWidth / 2.0
-		y_start := y - drawHeight / 2.0
-		x_end := x + drawWidth / 2.0
-		y_end := y + drawHeight / 2.0
-		draw.Draw(img, image.Rect(int(x_start), int(y_start), int(x_end), int(y_end)),
-			*drawImage, image.ZP, draw.Over)
+	file, err := eventlog.OpenFile(fileName, options)
+	if err != nil {
+		return err
 	}
-	return img, nil	
-}
-
-func (c *EyeTribeConnection) ServeHeatMapPng(w http.ResponseWriter, r *http.Request){
-	img, err := c.CreateHeatMapImage()
+	writer = eventlog.NewWriter(file)
+	err = writer.WriteHeader("eyebit_server", Version)
 	if err != nil {
-		w.Write([]byte("internal server error: create PNG failed."))
-		return
+		file.Close()
+		return err
 	}
-	w.Header().Set("Content-Type", "image/png")
-	png.Encode(w, img)
-}
-
-// 現在持っている情報から 注視 していた座標のリストを返します。
-func (c *EyeTribeConnection) GetFixationDataList() []FixateData {
-	result := []FixateData{}
-	fixation := c.CheckConfig.Fixation
-	var ok bool
-	max_distance, ok := (*fixation)["max distance"]
-	if !ok {
-		max_distance = 50
+	c.LogLock.Lock()
+	defer c.LogLock.Unlock()
+	previous := c.LogFile
+	c.LogFile = file
+	c.LogFileName = fileName
+	c.logWriter = writer
+	if previous != nil {
+		previous.Close()
+		c.logStats.Add(previous.Stats())
 	}
-	min_msec, ok := (*fixation)["min msec"]
-	if !ok {
-		min_msec = 100
-	}
-
-	PrevX := -1000000.0
-	PrevY := -1000000.0
-	PrevTime := time.Now()
-	CurrentTime := time.Now()
-	front := c.FrameList.Front()
-	if front != nil {
-		front_frame := front.Value.(*Frame)
-		if front_frame != nil {
-			PrevTime = front.Value.(*Frame).GoTime
-			CurrentTime = PrevTime
-			if front_frame.Avg != nil {
-				PrevX = front_frame.Avg.X
-				PrevY = front_frame.Avg.Y
-			}
-		}
-	}
-	check_time := PrevTime.Add(time.Millisecond * time.Duration(min_msec))
-	AverageX := PrevX
-	AverageY := PrevY
-	fixate_count := 0
-	fixate_count_sum := 0
-	distance2 := float64(max_distance * max_distance)
-	for f := c.FrameList.Front(); f != nil; f = f.Next() {
-		frame := f.Value.(*Frame)
-		if frame == nil || frame.Avg == nil {
-			continue
-		}
-		if frame.Avg.X <= 0.0 && frame.Avg.Y <= 0.0 {
-			// 外れ値っぽいので無視します。
-			continue
-		}
-		X := frame.Avg.X
-		Y := frame.Avg.Y
-		t := frame.GoTime
-		// 今見ている所が前の所より max_distance 以上離れていれば
-		// 違う場所を見始めたとする
-		xx := PrevX - X
-		yy := PrevY - Y
-		if distance2 < (xx * xx + yy * yy) {
-			// 違う場所を見始めた か、
-
-			// 違う場所を見始めるまでに指定された時間が経っていて、
-			// 何回も計測されているなら、見続けた事にする。
-			//fmt.Printf("%d count. total %q (%f,%f)\r\n", fixate_count, check_time.Sub(t), AverageX, AverageY)
-			if check_time.Sub(t) < 0 && fixate_count > 1 {
-				//fmt.Printf("add %d count. total %q\r\n", fixate_count, check_time.Sub(t))
-				result = append(result, FixateData{AverageX, AverageY, PrevTime})
-			}
-
-			fixate_count = 0
-			PrevTime = t
-			check_time = PrevTime.Add(time.Millisecond * time.Duration(min_msec))
-			PrevX = X
-			PrevY = Y
-			AverageX = PrevX
-			AverageY = PrevY
-			continue
-		}
-		// ここまで来たのであれば、前の所からそれほど離れていない所を見ていたことになる
-		// ということで、見続けたカウントを増やします。
-		fixate_count += 1
-		fixate_count_sum += 1
-		CurrentTime = t
-
-		alpha := 1.0 / (1.0 + float64(fixate_count))
-		a := 1.0 - alpha
-		b := alpha
-		AverageX = a * AverageX + b * PrevX
-		AverageY = a * AverageY + b * PrevY
-	}
-	// 最後に残ったものも追加する必要があれば追加します。
-	if fixate_count > 0 && check_time.Sub(CurrentTime) < 0 {
-		//fmt.Printf("%d count. total %q (%f,%f)\r\n", fixate_count, check_time.Sub(CurrentTime), AverageX, AverageY)
-		result = append(result, FixateData{AverageX, AverageY, PrevTime})
-	}
-	fmt.Printf("注視回数, 微小移動回数, 全体の回数 -> %d, %d, %d\r\n", len(result), fixate_count_sum, c.FrameList.Len())
-	return result
+	return nil
 }
 
-// 単に一瞬でも見ていればOKとする場合
-func (c *EyeTribeConnection) ServeEyeTrackCheck(w http.ResponseWriter, r *http.Request){
-	w.Header().Set("Content-Type", "application/json")
-	delta_millisecond := 10*1000 // default は 10秒前までのデータを確認します。
-	// delta_millisecond が指定されていたら、その秒数までのデータで確認しようとします。
-	delta_millisecond_str := r.FormValue("delta_millisecond")
-	millisecond, err := strconv.Atoi(delta_millisecond_str)
-	if err == nil {
-		delta_millisecond = millisecond
-	}
-	check_time := time.Now().Add(-time.Duration(delta_millisecond) * time.Millisecond)
-	
-	result := make(EyeTrackCheckResult)
-	for f := c.FrameList.Front(); f != nil; f = f.Next() {
-		frame := f.Value.(*Frame)
-		if frame == nil || frame.Avg == nil {
-			continue
-		}
-		if check_time.Sub(frame.GoTime) > 0 {
-			continue
-		}
-		if frame.Avg.X <= 0.0 && frame.Avg.Y <= 0.0 {
-			// 外れ値っぽいので無視します。
-			continue
-		}
-		x := frame.Avg.X
-		y := frame.Avg.Y
-
-		for i := range c.CheckConfig.TargetList {
-			v := c.CheckConfig.TargetList[i]
-			if v == nil {
-				continue
-			}
-			if x >= v.X && x <= (v.X + v.Width) && y >= v.Y && y <= (v.Y + v.Height) {
-				result[v.Name] = true
-			}else{
-				result[v.Name] = false
-			}
-		}
+// 今の log file の名前を返します。log を書き出していなければ "" を返します。
+func (c *EyeTribeConnection) GetLogFileName() string {
+	c.LogLock.Lock()
+	defer c.LogLock.Unlock()
+	if c.LogFile == nil {
+		return ""
 	}
-	fmt.Printf("result: %q\n", result)
-	encoder := json.NewEncoder(w)
-	encoder.Encode(&result)
+	return c.LogFileName
 }
 
-// 注視していればOKとする場合
-func (c *EyeTribeConnection) ServeEyeTrackCheckFixation(w http.ResponseWriter, r *http.Request){
-	w.Header().Set("Content-Type", "application/json")
-	delta_millisecond := 10*1000 // default は 10秒前までのデータを確認します。
-	// delta_millisecond が指定されていたら、その秒数までのデータで確認しようとします。
-	delta_millisecond_str := r.FormValue("delta_millisecond")
-	millisecond, err := strconv.Atoi(delta_millisecond_str)
-	if err == nil {
-		delta_millisecond = millisecond
-	}
-	check_time := time.Now().Add(-time.Duration(delta_millisecond) * time.Millisecond)
-	
-	result := make(EyeTrackCheckResult)
-	data_list := c.GetFixationDataList()
-	for i := range data_list {
-		data := data_list[i]
-		if check_time.Sub(data.GoTime) > 0 {
-			continue
-		}
-		x := data.X
-		y := data.Y
-		for i := range c.CheckConfig.TargetList {
-			v := c.CheckConfig.TargetList[i]
-			if v == nil {
-				continue
-			}
-			if x >= v.X && x <= (v.X + v.Width) && y >= v.Y && y <= (v.Y + v.Height) {
-				result[v.Name] = true
-			}else{
-				result[v.Name] = false
-			}
-		}
+// 接続した時に受け取った tracker の設定を返します。
+func (c *EyeTribeConnection) GetTrackerSettings() TrackerSettings {
+	c.ConnectionLock.Lock()
+	defer c.ConnectionLock.Unlock()
+	return TrackerSettings{
+		Address: c.HostAndPort,
+		ScreenWidth: c.ScreenWidth,
+		ScreenHeight: c.ScreenHeight,
+		FrameRate: c.FrameRate,
+		IsCalibrated: c.IsCalibrated,
 	}
-	fmt.Printf("result: %q\n", result)
-	encoder := json.NewEncoder(w)
-	encoder.Encode(&result)
 }
 
-func (c *EyeTribeConnection) StartHttpService(port int) error {
-	http.HandleFunc("/current_heatmap.png", func(w http.ResponseWriter, r *http.Request){
-		c.ServeHeatMapPng(w, r)
-	})
-	http.HandleFunc("/check.json", func(w http.ResponseWriter, r *http.Request){
-		c.ServeEyeTrackCheck(w, r)
-	})
-	http.HandleFunc("/check_fixation.json", func(w http.ResponseWriter, r *http.Request){
-		c.ServeEyeTrackCheckFixation(w, r)
-	})
-	fileServer := http.FileServer(http.Dir("./static/"))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		msg := fmt.Sprintf("{\"request path\": \"%s\", \"unix time\": %d}", r.RequestURI, time.Now().Unix())
-		c.PutLogString(msg)
-		fileServer.ServeHTTP(w, r)
-	})
-	go func(){
-		//http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
-		http.ListenAndServeTLS(fmt.Sprintf(":%d", port), "ssl_key/server.crt", "ssl_key/server.key", nil)
-	}()
-	fmt.Println("httpd done")
-	return nil
-}
-
-func (c *EyeTribeConnection) LoadEyeTrackCheckConfig(fileName string) error {
-	configFile, err := os.Open(fileName)
-	if err != nil {
-		return err
+// log file に溜まっている記録を全てディスクに書き出してから閉じます。
+func (c *EyeTribeConnection) CloseLogFile() error {
+	c.LogLock.Lock()
+	defer c.LogLock.Unlock()
+	if c.LogFile == nil {
+		return nil
 	}
-	defer configFile.Close()
-	decoder := json.NewDecoder(configFile)
-	return decoder.Decode(&c.CheckConfig)
+	err := c.LogFile.Close()
+	c.logStats.Add(c.LogFile.Stats())
+	c.LogFile = nil
+	c.LogFileName = ""
+	c.logWriter = nil
+	return err
 }
 
-func (c *EyeTribeConnection) SetLogFile(fileName string) error {
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0660)
-	if err == nil {
-		c.LogFile = file
+// これまでに開いた全ての log file の書き込みの状況を合わせて返します。
+// Dropped や Late が 0 でなければ、log から記録が抜けたり遅れたりしています。
+func (c *EyeTribeConnection) LogStats() eventlog.Stats {
+	c.LogLock.Lock()
+	defer c.LogLock.Unlock()
+	stats := c.logStats
+	stats.Queued = 0
+	if c.LogFile != nil {
+		stats.Add(c.LogFile.Stats())
 	}
-	return err
+	return stats
 }
@@ -0,0 +1,236 @@
+package eyetribe
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+	"../eventlog"
+)
+
+// 偽物のサーバを空いている port で起動し、テストの終わりに止めます。
+func startTestServer(t *testing.T, config MockServerConfig) *MockServer {
+	server, err := StartMockServer("localhost:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func(){
+		server.Close()
+	})
+	return server
+}
+
+// 偽物のサーバに繋ぎ、再接続を待つ間隔を短くします。
+func connectTestServer(t *testing.T, server *MockServer) *EyeTribeConnection {
+	c, err := CreateServerConnection(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ReconnectMinInterval = 10 * time.Millisecond
+	c.ReconnectMaxInterval = 50 * time.Millisecond
+	return c
+}
+
+// cond が true になるまで待ちます。timeout までにならなければ false を返します。
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+// t より後に受け取ったサンプルが来るまで待ちます。
+func waitForSampleAfter(c *EyeTribeConnection, t time.Time) bool {
+	return waitFor(3 * time.Second, func() bool {
+		sample, ok := c.FrameStore.Latest()
+		return ok && sample.Time.After(t)
+	})
+}
+
+func TestCreateServerConnection(t *testing.T) {
+	config := DefaultMockServerConfig()
+	config.Calibrated = false
+	config.ScreenWidth = 1280
+	config.ScreenHeight = 720
+	config.FrameRate = 60
+	server := startTestServer(t, config)
+	c := connectTestServer(t, server)
+	got := c.GetTrackerSettings()
+	want := TrackerSettings{Address: server.Addr(), ScreenWidth: 1280, ScreenHeight: 720, FrameRate: 60, IsCalibrated: false}
+	if got != want {
+		t.Errorf("GetTrackerSettings() = %+v, want %+v", got, want)
+	}
+	err := c.Close()
+	if err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+}
+
+func TestCreateServerConnectionRefused(t *testing.T) {
+	server := startTestServer(t, DefaultMockServerConfig())
+	address := server.Addr()
+	server.Close()
+	_, err := CreateServerConnection(address)
+	if err == nil {
+		t.Errorf("CreateServerConnection() to a closed server should fail")
+	}
+}
+
+func TestPullFrameTask(t *testing.T) {
+	config := DefaultMockServerConfig()
+	config.FrameRate = 60
+	server := startTestServer(t, config)
+	c := connectTestServer(t, server)
+	defer c.Close()
+	samples := c.Subscribe(16)
+	c.StartPullFrameTask(2)
+	// 溜め込むフレームの数は tracker のフレームレートから決めます。
+	if got := c.FrameStore.Capacity(); got != 120 {
+		t.Errorf("frame store capacity = %d, want 120", got)
+	}
+	select {
+	case sample := <- samples:
+		if !sample.Valid || sample.State != 7 {
+			t.Errorf("sample = %+v", sample)
+		}
+	case <- time.After(3 * time.Second):
+		t.Fatalf("no sample from the pull task")
+	}
+	if !waitFor(3 * time.Second, func() bool { return c.FrameStore.Len() >= 10 }) {
+		t.Fatalf("frame store has only %d samples", c.FrameStore.Len())
+	}
+	err := c.StopPullFrameTask()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.pullTaskRunning() {
+		t.Errorf("pull task is still running")
+	}
+	if c.StopPullFrameTask() == nil {
+		t.Errorf("second StopPullFrameTask() should fail")
+	}
+	c.Unsubscribe(samples)
+}
+
+// サーバから接続を切られても、繋ぎ直してフレームを受け取り続け、切れた事と繋がった事を log に残します。
+func TestReconnect(t *testing.T) {
+	server := startTestServer(t, DefaultMockServerConfig())
+	c := connectTestServer(t, server)
+	logFileName := filepath.Join(t.TempDir(), "log.json")
+	err := c.SetLogFile(logFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.StartPullFrameTask(10)
+	if !waitForSampleAfter(c, time.Time{}) {
+		t.Fatalf("no sample before dropping the connection")
+	}
+	for i := 0; i < 2; i++ {
+		dropped := time.Now()
+		server.DropConnections()
+		if !waitForSampleAfter(c, dropped.Add(100 * time.Millisecond)) {
+			t.Fatalf("no sample after dropping the connection %d times", i + 1)
+		}
+	}
+	err = c.Close()
+	if err != nil {
+		t.Logf("Close() = %v", err)
+	}
+
+	eventList := []string{}
+	frames := 0
+	err = eventlog.ReadFile(logFileName, func(record *eventlog.Record) error {
+		switch record.Type {
+		case eventlog.TypeFrame:
+			frames++
+		case eventlog.TypeConnection:
+			var connection eventlog.Connection
+			record.Decode(&connection)
+			eventList = append(eventList, connection.Event)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"disconnect", "reconnect", "disconnect", "reconnect"}
+	if len(eventList) != len(want) {
+		t.Fatalf("connection events = %v, want %v", eventList, want)
+	}
+	for i := range want {
+		if eventList[i] != want[i] {
+			t.Errorf("connection events = %v, want %v", eventList, want)
+			break
+		}
+	}
+	if frames <= 0 {
+		t.Errorf("no frame in the log")
+	}
+}
+
+// サーバが止まって繋ぎ直せない間も、Close() で再接続をやめて終われます。
+func TestCloseWhileReconnecting(t *testing.T) {
+	server := startTestServer(t, DefaultMockServerConfig())
+	c := connectTestServer(t, server)
+	c.StartPullFrameTask(10)
+	if !waitForSampleAfter(c, time.Time{}) {
+		t.Fatalf("no sample before stopping the server")
+	}
+	server.Close()
+	// 何度か繋ぎ直しに失敗するのを待ちます。
+	time.Sleep(100 * time.Millisecond)
+	closed := make(chan bool)
+	go func(){
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <- closed:
+	case <- time.After(3 * time.Second):
+		t.Fatalf("Close() did not return while reconnecting")
+	}
+	if c.pullTaskRunning() {
+		t.Errorf("pull task is still running")
+	}
+}
+
+// 繋ぎ直している間に終了を伝えられたら、新しい接続に切り替えずにやめます。
+func TestReconnectAfterQuit(t *testing.T) {
+	server := startTestServer(t, DefaultMockServerConfig())
+	c := connectTestServer(t, server)
+	defer c.Close()
+	connection := c.Connection
+	quit := make(chan bool)
+	close(quit)
+	c.ConnectionLock.Lock()
+	c.QuitPullTask = quit
+	c.ConnectionLock.Unlock()
+	if c.reconnectOnce() == nil {
+		t.Errorf("reconnectOnce() after quit should fail")
+	}
+	c.ConnectionLock.Lock()
+	c.QuitPullTask = nil
+	switched := c.Connection != connection
+	c.ConnectionLock.Unlock()
+	if switched {
+		t.Errorf("connection was switched after quit")
+	}
+}
+
+func TestMockServerCloseTwice(t *testing.T) {
+	server, err := StartMockServer("localhost:0", DefaultMockServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Close()
+	if err != nil {
+		t.Errorf("first Close() = %v", err)
+	}
+	err = server.Close()
+	if err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+}
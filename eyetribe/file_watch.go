@@ -0,0 +1,44 @@
+package eyetribe
+
+import (
+	"os"
+	"time"
+)
+
+// fileName の更新時刻と大きさを interval 毎に確かめ、変わっていたら返り値の channel に通知します。
+// quit が閉じられたら確かめるのをやめます。
+func WatchFile(fileName string, interval time.Duration, quit chan bool) chan bool {
+	changed := make(chan bool, 1)
+	var lastModTime time.Time
+	var lastSize int64
+	if info, err := os.Stat(fileName); err == nil {
+		lastModTime = info.ModTime()
+		lastSize = info.Size()
+	}
+	go func(){
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <- quit:
+				return
+			case <- ticker.C:
+			}
+			info, err := os.Stat(fileName)
+			if err != nil {
+				// 書き換えの途中で消えていることもあるので、次を待ちます。
+				continue
+			}
+			if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+				continue
+			}
+			lastModTime = info.ModTime()
+			lastSize = info.Size()
+			select {
+			case changed <- true:
+			default:
+			}
+		}
+	}()
+	return changed
+}
@@ -0,0 +1,141 @@
+package eyetribe
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 視線のサンプルを決まった数だけ溜め込む、複数の goroutine から触っても良い輪状の入れ物
+// サンプルは受け取った時刻(GazeSample.Time)の順に追加される前提で、
+// 時刻での絞り込みは二分探索で行います。
+// 読み出す側には、その時点の内容を写したスライスを返します。
+type FrameStore struct {
+	lock sync.RWMutex
+	samples []GazeSample // 長さ capacity の輪
+	head int // 一番古いサンプルの位置
+	count int // 溜め込んでいるサンプルの数
+}
+
+// capacity 個までサンプルを溜め込む FrameStore を作ります。
+func NewFrameStore(capacity int) *FrameStore {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &FrameStore{
+		samples: make([]GazeSample, capacity),
+	}
+}
+
+// 溜め込める数を返します。
+func (s *FrameStore) Capacity() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.samples)
+}
+
+// 溜め込んでいる数を返します。
+func (s *FrameStore) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.count
+}
+
+// 溜め込める数を変えます。入りきらない分は古いものから捨てます。
+func (s *FrameStore) Resize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	samples := make([]GazeSample, capacity)
+	count := s.count
+	if count > capacity {
+		count = capacity
+	}
+	for i := 0; i < count; i++ {
+		samples[i] = *s.at(s.count - count + i)
+	}
+	s.samples = samples
+	s.head = 0
+	s.count = count
+}
+
+// サンプルを一つ溜め込みます。一杯であれば一番古いものを捨てます。
+func (s *FrameStore) Add(sample GazeSample) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	capacity := len(s.samples)
+	if capacity <= 0 {
+		return
+	}
+	if s.count < capacity {
+		s.samples[(s.head + s.count) % capacity] = sample
+		s.count += 1
+		return
+	}
+	s.samples[s.head] = sample
+	s.head = (s.head + 1) % capacity
+}
+
+// 古い方から i 番目のサンプルを返します。lock を取ってから呼び出します。
+func (s *FrameStore) at(i int) *GazeSample {
+	return &s.samples[(s.head + i) % len(s.samples)]
+}
+
+// Time が t 以上になる最初のサンプルの番号を返します。lock を取ってから呼び出します。
+func (s *FrameStore) search(t time.Time) int {
+	return sort.Search(s.count, func(i int) bool {
+		return !s.at(i).Time.Before(t)
+	})
+}
+
+// [from, to) 番目のサンプルを写して返します。lock を取ってから呼び出します。
+func (s *FrameStore) copyRange(from int, to int) []GazeSample {
+	if to <= from {
+		return []GazeSample{}
+	}
+	result := make([]GazeSample, 0, to - from)
+	for i := from; i < to; i++ {
+		result = append(result, *s.at(i))
+	}
+	return result
+}
+
+// 溜め込んでいるサンプルを古い順に写して返します。
+func (s *FrameStore) Snapshot() []GazeSample {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.copyRange(0, s.count)
+}
+
+// start 以降、end より前に受け取ったサンプルを古い順に返します。
+// start, end が zero value の場合はそちら側の制限をしません。
+func (s *FrameStore) Between(start time.Time, end time.Time) []GazeSample {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	from := 0
+	if !start.IsZero() {
+		from = s.search(start)
+	}
+	to := s.count
+	if !end.IsZero() {
+		to = s.search(end)
+	}
+	return s.copyRange(from, to)
+}
+
+// start 以降に受け取ったサンプルを古い順に返します。
+func (s *FrameStore) Since(start time.Time) []GazeSample {
+	return s.Between(start, time.Time{})
+}
+
+// 一番新しいサンプルを返します。一つも無ければ false を返します。
+func (s *FrameStore) Latest() (GazeSample, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.count <= 0 {
+		return GazeSample{}, false
+	}
+	return *s.at(s.count - 1), true
+}
@@ -0,0 +1,57 @@
+package eyetribe
+
+import (
+	"sync"
+)
+
+// 受け取ったサンプルを、それを待っている全ての channel に配るもの
+// GazeSource はこれを持つことで、溜め込むだけでなく届いた順にサンプルを流せるようになります。
+type GazeBroadcaster struct {
+	lock sync.Mutex
+	subscribers map[chan GazeSample]bool
+}
+
+// 届いたサンプルを流してくれる GazeSource
+type GazeStreamer interface {
+	// サンプルが届く度にそれを受け取る channel を作ります。
+	Subscribe(bufferSize int) chan GazeSample
+	// Subscribe() で作った channel を閉じて、受け取るのをやめます。
+	Unsubscribe(ch chan GazeSample)
+}
+
+// サンプルが届く度にそれを受け取る channel を作ります。
+// 受け取る側が遅れて bufferSize 以上溜まった分は捨てられます。
+func (b *GazeBroadcaster) Subscribe(bufferSize int) chan GazeSample {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = map[chan GazeSample]bool{}
+	}
+	ch := make(chan GazeSample, bufferSize)
+	b.subscribers[ch] = true
+	return ch
+}
+
+// Subscribe() で作った channel を閉じて、受け取るのをやめます。
+func (b *GazeBroadcaster) Unsubscribe(ch chan GazeSample) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.subscribers[ch]; !ok {
+		return
+	}
+	delete(b.subscribers, ch)
+	close(ch)
+}
+
+// サンプルを全ての channel に配ります。
+// 受け取る側を待つことはしないので、pull タスクを止めることはありません。
+func (b *GazeBroadcaster) Publish(sample GazeSample) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- sample:
+		default:
+		}
+	}
+}
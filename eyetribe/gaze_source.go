@@ -0,0 +1,167 @@
+package eyetribe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 片目分の正規化された視線のデータ
+type EyeSample struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	PupilSize float64 `json:"psize"`
+}
+
+// tracker の種類に依らない、正規化された視線のサンプル
+// 座標は画面上のピクセルで表します。
+type GazeSample struct {
+	Time time.Time `json:"time"` // サンプルを受け取った時刻
+	TrackerTime float64 `json:"tracker_time"` // tracker 側の時刻(ミリ秒)
+	X float64 `json:"x"` // 平滑化された視線の座標
+	Y float64 `json:"y"`
+	RawX float64 `json:"raw_x"` // 平滑化される前の視線の座標
+	RawY float64 `json:"raw_y"`
+	Valid bool `json:"valid"` // 視線が取れているかどうか
+	Fix bool `json:"fix"` // tracker が注視中と判断しているかどうか
+	State int64 `json:"state"` // tracker の状態(EyeTribe の state と同じ bitfield)
+	LeftEye *EyeSample `json:"lefteye"`
+	RightEye *EyeSample `json:"righteye"`
+	Page *PagePosition `json:"page,omitempty"` // ページ上の位置(HttpService がページの表示の報告から入れます)
+}
+
+// 視線のサンプルを生み出すもの
+// HTTP での問い合わせや解析はこれだけを見るようにして、tracker の種類に依存しないようにします。
+type GazeSource interface {
+	// 現在溜め込んでいるサンプルを古い順に返します。
+	Samples() []GazeSample
+	// start 以降、end より前に受け取ったサンプルを古い順に返します。
+	// start, end が zero value の場合はそちら側の制限をしません。
+	SamplesBetween(start time.Time, end time.Time) []GazeSample
+	// 画面の大きさ(幅, 高さ)を返します。
+	ScreenSize() (int64, int64)
+}
+
+// ServerConfig の source に書ける GazeSource の種類
+const (
+	SourceTracker = "tracker" // EyeTribe サーバに繋ぎます
+	SourceReplay = "replay" // "replay:<log file>" で log file のフレームを流しなおします
+	SourceSynthetic = "synthetic" // 画面の中心を回る円をなぞる作り物の視線を流します
+)
+
+// "tracker", "replay:<log file>", "synthetic" の形の source の指定を、種類と引数に分けます。
+func ParseSourceSpec(spec string) (string, string, error) {
+	kind := spec
+	argument := ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind = spec[:i]
+		argument = spec[i + 1:]
+	}
+	switch kind {
+	case "", SourceTracker:
+		return SourceTracker, "", nil
+	case SourceSynthetic:
+		return SourceSynthetic, "", nil
+	case SourceReplay:
+		if argument == "" {
+			return "", "", errors.New("source replay needs a log file: replay:<log file>")
+		}
+		return SourceReplay, argument, nil
+	}
+	return "", "", errors.New(fmt.Sprintf("unknown source: %s", spec))
+}
+
+// EyeData を EyeSample に変換します。
+func eyeDataToEyeSample(eye *EyeData) *EyeSample {
+	if eye == nil || eye.Avg == nil {
+		return nil
+	}
+	return &EyeSample{
+		X: eye.Avg.X,
+		Y: eye.Avg.Y,
+		PupilSize: eye.Psize,
+	}
+}
+
+// EyeTribe の Frame を GazeSample に変換します。
+// 座標が (0, 0) 以下のものは外れ値として Valid = false にします。
+func FrameToGazeSample(frame *Frame) GazeSample {
+	sample := GazeSample{}
+	if frame == nil {
+		return sample
+	}
+	sample.Time = frame.GoTime
+	sample.TrackerTime = frame.Time
+	sample.Fix = frame.Fix
+	sample.State = frame.State
+	if frame.Avg != nil {
+		sample.X = frame.Avg.X
+		sample.Y = frame.Avg.Y
+		sample.Valid = !(frame.Avg.X <= 0.0 && frame.Avg.Y <= 0.0)
+	}
+	if frame.Raw != nil {
+		sample.RawX = frame.Raw.X
+		sample.RawY = frame.Raw.Y
+	}
+	sample.LeftEye = eyeDataToEyeSample(frame.LeftEye)
+	sample.RightEye = eyeDataToEyeSample(frame.RightEye)
+	return sample
+}
+
+// EyeTribe から溜め込んだフレームを GazeSample にして返します。
+func (c *EyeTribeConnection) Samples() []GazeSample {
+	return c.FrameStore.Snapshot()
+}
+
+// EyeTribe から溜め込んだフレームのうち、start 以降 end より前のものを返します。
+func (c *EyeTribeConnection) SamplesBetween(start time.Time, end time.Time) []GazeSample {
+	return c.FrameStore.Between(start, end)
+}
+
+// tracker から得た画面の大きさを返します。
+func (c *EyeTribeConnection) ScreenSize() (int64, int64) {
+	c.ConnectionLock.Lock()
+	defer c.ConnectionLock.Unlock()
+	return c.ScreenWidth, c.ScreenHeight
+}
+
+// サンプルを溜め込んで GazeSource として見せるための入れ物
+// EyeTribe 以外の GazeSource はこれを使ってサンプルを溜め込みます。
+type GazeSampleBuffer struct {
+	GazeBroadcaster // 溜め込んだサンプルを流す先
+	Store *FrameStore
+	ScreenWidth int64
+	ScreenHeight int64
+}
+
+// maxSamples 個までサンプルを溜め込む入れ物を作ります。
+func NewGazeSampleBuffer(maxSamples int, screenWidth int64, screenHeight int64) *GazeSampleBuffer {
+	return &GazeSampleBuffer{
+		Store: NewFrameStore(maxSamples),
+		ScreenWidth: screenWidth,
+		ScreenHeight: screenHeight,
+	}
+}
+
+// サンプルを一つ溜め込みます。
+// 溜め込める数以上のものは溢れるような処理をします。
+func (b *GazeSampleBuffer) AddSample(sample GazeSample) {
+	b.Store.Add(sample)
+	b.Publish(sample)
+}
+
+// 溜め込んでいるサンプルを古い順に返します。
+func (b *GazeSampleBuffer) Samples() []GazeSample {
+	return b.Store.Snapshot()
+}
+
+// 溜め込んでいるサンプルのうち、start 以降 end より前のものを返します。
+func (b *GazeSampleBuffer) SamplesBetween(start time.Time, end time.Time) []GazeSample {
+	return b.Store.Between(start, end)
+}
+
+// 画面の大きさを返します。
+func (b *GazeSampleBuffer) ScreenSize() (int64, int64) {
+	return b.ScreenWidth, b.ScreenHeight
+}
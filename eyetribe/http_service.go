@@ -0,0 +1,479 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"image"
+	"image/png"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"context"
+	"sync"
+	"math"
+	"../aoi"
+	"../eventlog"
+	"../fixation"
+	"../heatmap"
+	"../viewport"
+)
+
+// log に記録を書き出せるもの
+type LogWriter interface {
+	PutLogRecord(recordType string, payload interface{}) error
+}
+
+// GazeSource から得られるサンプルを元に、heatmap や注視の判定を HTTP で返すための class
+// tracker の種類には依存せず、GazeSource だけを見ます。
+type HttpService struct {
+	Source GazeSource
+	Log LogWriter // ページの要求等を書き出す先(nil なら書き出しません)
+	Calibrator Calibrator // キャリブレーションを行う先(nil ならキャリブレーションはできません)
+	Stream *StreamHub // ライブ配信でイベントを配る先
+	Pages *PageHistory // 参加者が見ていたページの移り変わり
+	Rules *RuleEngine // 視線に応じて発火する規則を確認しているもの
+	Sessions *SessionManager // 実験の参加者のセッションと試行
+	BrowserClocks *BrowserClocks // ブラウザから報告された時計のずれ
+	Viewports *viewport.History // ページから報告された表示の様子(scroll 等)の移り変わり
+	Mux *http.ServeMux // このサービスの HTTP の入り口
+	Server *http.Server // StartHttpService() で動かし始めたサーバ
+	gazeStream chan GazeSample // GazeSource から Stream に流しているサンプル
+	CheckConfig EyeTrackCheckConfig
+	CheckConfigLock sync.RWMutex // CheckConfig の読み直しを守ります
+}
+
+// 覚えておくページの表示の数
+const MaxPageViews = 10000
+
+// source を元に HTTP で返す HttpService を作ります。
+// source が LogWriter でもあれば、log もそこに書き出します。
+// source が Calibrator でもあれば、HTTP 越しにキャリブレーションできるようにします。
+func NewHttpService(source GazeSource) *HttpService {
+	s := &HttpService{
+		Source: source,
+		Stream: NewStreamHub(),
+		Pages: NewPageHistory(MaxPageViews),
+		Sessions: NewSessionManager(),
+		BrowserClocks: NewBrowserClocks(),
+		Viewports: viewport.NewHistory(MaxViewportStates),
+		Mux: http.NewServeMux(),
+	}
+	if w, ok := source.(LogWriter); ok {
+		s.Log = w
+	}
+	if c, ok := source.(Calibrator); ok {
+		s.Calibrator = c
+	}
+	return s
+}
+
+// heatmap の画像の一辺の最大の大きさ(ページ全体を描く時に使います)
+const MaxHeatMapSize = 16384
+
+// 画面の 1 度の視角が何ピクセルかを返します。fixation の設定から分からなければ 0 を返します。
+// 画面の幅が書かれていなければ、GazeSource の画面の幅を使います。
+func (s *HttpService) pixelsPerDegree() float64 {
+	config := s.GetEyeTrackCheckConfig()
+	if config.Fixation == nil {
+		return 0
+	}
+	c := *config.Fixation
+	if c.ScreenWidthPixel <= 0 {
+		screenWidth, _ := s.Source.ScreenSize()
+		c.ScreenWidthPixel = float64(screenWidth)
+	}
+	return c.PixelsPerDegree()
+}
+
+// config.json の "heatmap" を、要求の値(color_map, weight, scale, sigma, unit, max)で上書きした設定を返します。
+func (s *HttpService) heatMapConfigFor(r *http.Request) (*heatmap.Config, error) {
+	c := heatmap.Config{}
+	if config := s.GetEyeTrackCheckConfig().HeatMap; config != nil {
+		c = *config
+	}
+	stringValues := map[string]*string{
+		"color_map": &c.ColorMap,
+		"weight": &c.Weight,
+		"scale": &c.Scale,
+		"unit": &c.Unit,
+	}
+	for name, value := range stringValues {
+		if v := r.FormValue(name); v != "" {
+			*value = v
+		}
+	}
+	floatValues := map[string]*float64{
+		"sigma": &c.Sigma,
+		"max": &c.Max,
+	}
+	for name, value := range floatValues {
+		if v := r.FormValue(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s: %s", name, err))
+			}
+			*value = f
+		}
+	}
+	return &c, c.Validate()
+}
+
+// 視線を描く画像の大きさと、ページ上の位置で描くかどうかを返します。
+// space が aoi.SpacePage で、今のページが表示の様子を報告していれば、ページ全体の大きさにします。
+// そうでなければ画面の大きさにします。
+func (s *HttpService) imageSpace(space string) (float64, float64, bool) {
+	screenWidth, screenHeight := s.Source.ScreenSize()
+	if space == aoi.SpacePage {
+		if state, ok := s.ViewportAt(time.Now()); ok {
+			width, height := state.DocumentSize()
+			return math.Min(width, MaxHeatMapSize), math.Min(height, MaxHeatMapSize), true
+		}
+	}
+	return float64(screenWidth), float64(screenHeight), false
+}
+
+// 時刻 t の画面の座標 (x, y) を、画像に描く座標にします。
+// usePage の時、前のページか、ページの外を見ていたものは false を返します。
+func (s *HttpService) imagePosition(usePage bool, t time.Time, x float64, y float64) (float64, float64, bool) {
+	if !usePage {
+		return x, y, true
+	}
+	page := s.PagePositionAt(t, x, y)
+	if page == nil || !page.Inside {
+		return 0, 0, false
+	}
+	return page.X, page.Y, true
+}
+
+// 現在溜め込んでいるサンプルから heatmap の画像を作ります。
+// space が aoi.SpacePage で、今のページが表示の様子を報告していれば、ページ全体の大きさの画像に
+// ページ上の位置で描きます。そうでなければ画面の大きさの画像に画面の座標で描きます。
+func (s *HttpService) CreateHeatMapImage(space string, config *heatmap.Config) (*image.NRGBA, error) {
+	renderer, err := heatmap.NewRenderer(config, s.pixelsPerDegree())
+	if err != nil {
+		return nil, err
+	}
+	width, height, usePage := s.imageSpace(space)
+	accumulator := renderer.NewAccumulator(int(width), int(height))
+	if renderer.UsesFixations() {
+		fixationList := s.GetFixationDataList()
+		pointList := make([]heatmap.Point, len(fixationList))
+		for i := range fixationList {
+			f := fixationList[i]
+			x, y, ok := s.imagePosition(usePage, f.GoTime, f.X, f.Y)
+			pointList[i] = heatmap.Point{X: x, Y: y, Duration: f.Duration, Valid: ok}
+		}
+		renderer.Add(accumulator, pointList)
+	} else {
+		sampleList := s.Source.Samples()
+		pointList := make([]heatmap.Point, len(sampleList))
+		for i := range sampleList {
+			sample := sampleList[i]
+			x, y, ok := s.imagePosition(usePage, sample.Time, sample.X, sample.Y)
+			pointList[i] = heatmap.Point{
+				X: x,
+				Y: y,
+				Time: float64(sample.Time.UnixNano()) / float64(time.Millisecond),
+				// 外れ値っぽいものは無視します。
+				Valid: sample.Valid && ok,
+			}
+		}
+		renderer.Add(accumulator, pointList)
+	}
+	return renderer.Render(accumulator), nil
+}
+
+// heatmap の画像を返します。space=screen が指定されていたら、ページの表示に関わらず画面の座標で描きます。
+// color_map, weight, scale, sigma, unit, max で config.json の "heatmap" を上書きできます。
+func (s *HttpService) ServeHeatMapPng(w http.ResponseWriter, r *http.Request){
+	space := r.FormValue("space")
+	if space == "" {
+		space = aoi.SpacePage
+	}
+	config, err := s.heatMapConfigFor(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("heatmap config error: %s", err)))
+		return
+	}
+	img, err := s.CreateHeatMapImage(space, config)
+	if err != nil {
+		fmt.Printf("heatmap image create error: %q\n", err)
+		w.Write([]byte("internal server error: create PNG failed."))
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, img)
+}
+
+// heatmap の色の目盛りの画像を返します。color_map 等は /current_heatmap.png と同じです。
+func (s *HttpService) ServeHeatMapLegendPng(w http.ResponseWriter, r *http.Request){
+	config, err := s.heatMapConfigFor(r)
+	if err == nil {
+		// 目盛りには視角の大きさは要らないので、ピクセルとして扱います。
+		config.Unit = ""
+	}
+	var renderer *heatmap.Renderer
+	if err == nil {
+		renderer, err = heatmap.NewRenderer(config, 0)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("heatmap config error: %s", err)))
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, renderer.Legend(256, 16))
+}
+
+// 設定に従って注視を見つける Detector を作ります。
+// 視角で指定されていて画面の幅が書かれていなければ、GazeSource の画面の幅を使います。
+func (s *HttpService) NewFixationDetector() (fixation.Detector, error) {
+	config := s.GetEyeTrackCheckConfig().Fixation
+	if config != nil && config.Unit == "degree" && config.ScreenWidthPixel <= 0 {
+		c := *config
+		screenWidth, _ := s.Source.ScreenSize()
+		c.ScreenWidthPixel = float64(screenWidth)
+		config = &c
+	}
+	return fixation.NewDetector(config)
+}
+
+// GazeSample を注視の判定に使うサンプルにします。
+// 時刻は tracker の時刻を使い、無ければサーバの時計を使います。
+func GazeSamplesToFixationSamples(sampleList []GazeSample) []fixation.Sample {
+	result := make([]fixation.Sample, len(sampleList))
+	for i := range sampleList {
+		sample := sampleList[i]
+		t := sample.TrackerTime
+		if t <= 0 {
+			t = float64(sample.Time.UnixNano()) / float64(time.Millisecond)
+		}
+		result[i] = fixation.Sample{
+			Time: t,
+			X: sample.X,
+			Y: sample.Y,
+			Valid: sample.Valid,
+		}
+	}
+	return result
+}
+
+// 現在持っている情報から 注視 していた座標のリストを返します。
+func (s *HttpService) GetFixationDataList() []FixateData {
+	result := []FixateData{}
+	detector, err := s.NewFixationDetector()
+	if err != nil {
+		fmt.Printf("fixation config error: %q\n", err)
+		return result
+	}
+	sampleList := s.Source.Samples()
+	fixationList := detector.Detect(GazeSamplesToFixationSamples(sampleList))
+	for i := range fixationList {
+		f := fixationList[i]
+		result = append(result, FixateData{
+			Fixation: f,
+			GoTime: sampleList[f.StartIndex].Time,
+			EndGoTime: sampleList[f.EndIndex].Time,
+		})
+	}
+	fmt.Printf("注視回数, 全体の回数 -> %d, %d\r\n", len(result), len(sampleList))
+	return result
+}
+
+// 単に一瞬でも見ていればOKとする場合
+// 見ていた時間はサンプルで数えます。
+func (s *HttpService) ServeEyeTrackCheck(w http.ResponseWriter, r *http.Request){
+	s.serveEyeTrackCheckMetrics(w, r, false)
+}
+
+// 注視していればOKとする場合
+// 見ていた時間は注視で数えます。
+func (s *HttpService) ServeEyeTrackCheckFixation(w http.ResponseWriter, r *http.Request){
+	s.serveEyeTrackCheckMetrics(w, r, true)
+}
+
+// AOI ごとの指標を返します。simple=true が指定されていたら、見ていたかどうかだけを返します。
+func (s *HttpService) serveEyeTrackCheckMetrics(w http.ResponseWriter, r *http.Request, dwellFromFixations bool){
+	w.Header().Set("Content-Type", "application/json")
+	delta_millisecond := 10*1000 // default は 10秒前までのデータを確認します。
+	// delta_millisecond が指定されていたら、その秒数までのデータで確認しようとします。
+	delta_millisecond_str := r.FormValue("delta_millisecond")
+	millisecond, err := strconv.Atoi(delta_millisecond_str)
+	if err == nil {
+		delta_millisecond = millisecond
+	}
+	check_time := time.Now().Add(-time.Duration(delta_millisecond) * time.Millisecond)
+
+	result := s.GetEyeTrackCheckResult(check_time, dwellFromFixations)
+	hits := result.Hits()
+	fmt.Printf("result: %v\n", hits)
+	encoder := json.NewEncoder(w)
+	if simple, _ := strconv.ParseBool(r.FormValue("simple")); simple {
+		encoder.Encode(&hits)
+		return
+	}
+	encoder.Encode(&result)
+}
+
+// 指定された時間内に始まった注視のリストを返します。
+func (s *HttpService) ServeFixationList(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	delta_millisecond := 10*1000 // default は 10秒前までのデータを確認します。
+	delta_millisecond_str := r.FormValue("delta_millisecond")
+	millisecond, err := strconv.Atoi(delta_millisecond_str)
+	if err == nil {
+		delta_millisecond = millisecond
+	}
+	check_time := time.Now().Add(-time.Duration(delta_millisecond) * time.Millisecond)
+
+	result := []FixateData{}
+	data_list := s.GetFixationDataList()
+	for i := range data_list {
+		if check_time.Sub(data_list[i].GoTime) > 0 {
+			continue
+		}
+		result = append(result, data_list[i])
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(&result)
+}
+
+// HTTP での問い合わせを受け付けはじめます。
+// config の ListenAddress で待ち受け、TLS が有効なら CertFile, KeyFile を使って HTTPS で待ち受けます。
+// "/" 以下では StaticDirectory のファイルを返します。
+func (s *HttpService) StartHttpService(config *ServerConfig) error {
+	s.Mux.HandleFunc("/current_heatmap.png", func(w http.ResponseWriter, r *http.Request){
+		s.ServeHeatMapPng(w, r)
+	})
+	s.Mux.HandleFunc("/heatmap_legend.png", func(w http.ResponseWriter, r *http.Request){
+		s.ServeHeatMapLegendPng(w, r)
+	})
+	s.Mux.HandleFunc("/current_scanpath.png", func(w http.ResponseWriter, r *http.Request){
+		s.ServeScanPathPng(w, r)
+	})
+	s.Mux.HandleFunc("/check.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeEyeTrackCheck(w, r)
+	})
+	s.Mux.HandleFunc("/check_fixation.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeEyeTrackCheckFixation(w, r)
+	})
+	s.Mux.HandleFunc("/fixations.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeFixationList(w, r)
+	})
+	s.Mux.HandleFunc("/events.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeEventList(w, r)
+	})
+	s.Mux.HandleFunc("/aoi.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeCurrentAOI(w, r)
+	})
+	s.Mux.HandleFunc("/wait.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeWait(w, r)
+	})
+	s.Mux.HandleFunc("/viewport.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeViewport(w, r)
+	})
+	s.Mux.HandleFunc("/marker.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeMarker(w, r)
+	})
+	s.Mux.HandleFunc("/log_stats.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeLogStats(w, r)
+	})
+	s.handleCalibration()
+	s.Sessions.Directory = config.SessionDirectory
+	s.Sessions.Experiment = config.Experiment
+	s.handleSession()
+	s.handleClock()
+	s.Mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request){
+		s.ServeStream(w, r)
+	})
+	fileServer := http.FileServer(http.Dir(config.StaticDirectory))
+	s.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if s.Log != nil {
+			s.Log.PutLogRecord(eventlog.TypeNavigation, &eventlog.Navigation{Url: r.RequestURI})
+		}
+		if s.isPageRequest(r) {
+			s.Pages.Add(r.RequestURI, time.Now())
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+
+	s.Server = &http.Server{Handler: s.Mux}
+	if config.TLS {
+		// 証明書の読み込みに失敗した時はここでエラーを返せるように、先に読み込んでおきます。
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return err
+		}
+		s.Server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		return err
+	}
+	s.startGazeStream()
+	s.startRuleEngine()
+	go func(){
+		var err error
+		if config.TLS {
+			err = s.Server.ServeTLS(listener, "", "")
+		}else{
+			err = s.Server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Printf("http service error: %q\n", err)
+		}
+	}()
+	fmt.Printf("httpd listening on %s (tls: %t)\n", listener.Addr(), config.TLS)
+	return nil
+}
+
+// 指定の場所を確認していたかどうかを判定するための設定を読み込みます。
+// 読み込みに失敗した場合は今までの設定のままにします。
+func (s *HttpService) LoadEyeTrackCheckConfig(fileName string) error {
+	configFile, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer configFile.Close()
+	var config EyeTrackCheckConfig
+	decoder := json.NewDecoder(configFile)
+	err = decoder.Decode(&config)
+	if err != nil {
+		return err
+	}
+	err = config.Compile()
+	if err != nil {
+		return err
+	}
+	s.CheckConfigLock.Lock()
+	s.CheckConfig = config
+	s.CheckConfigLock.Unlock()
+	return nil
+}
+
+// 現在の指定の場所を確認していたかどうかを判定するための設定を返します。
+func (s *HttpService) GetEyeTrackCheckConfig() EyeTrackCheckConfig {
+	s.CheckConfigLock.RLock()
+	defer s.CheckConfigLock.RUnlock()
+	return s.CheckConfig
+}
+
+// HTTP での問い合わせの受け付けをやめます。
+// ライブ配信を終わらせ、処理中のリクエストが終わるまで timeout だけ待ちます。
+func (s *HttpService) StopHttpService(timeout time.Duration) error {
+	s.stopRuleEngine()
+	s.stopGazeStream()
+	s.EndSession()
+	s.Stream.Close()
+	if s.Server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Server.Shutdown(ctx)
+}
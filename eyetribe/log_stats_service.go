@@ -0,0 +1,36 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"net/http"
+	"../eventlog"
+)
+
+// log の書き込みの状況を返せるもの
+type LogStatsSource interface {
+	LogStats() eventlog.Stats
+	GetLogFileName() string
+}
+
+// /log_stats.json の返事
+type LogStatsResult struct {
+	LogFileName string `json:"log file"` // 今書き込んでいる log file("" なら書き込んでいません)
+	eventlog.Stats
+}
+
+// log の書き込みの状況(書き込んだ数、捨てた数、遅れた数、回転した数等)を返します。
+func (s *HttpService) ServeLogStats(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	source, ok := s.Log.(LogStatsSource)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("{\"error\": \"log is not written\"}\n"))
+		return
+	}
+	result := LogStatsResult{
+		LogFileName: source.GetLogFileName(),
+		Stats: source.LogStats(),
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(&result)
+}
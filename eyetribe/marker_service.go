@@ -0,0 +1,129 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"../eventlog"
+)
+
+// 受け付ける目印の JSON の最大の大きさ
+const MaxMarkerBytes = 1024 * 1024
+
+// ページから送られてくる目印
+type MarkerRequest struct {
+	Name string `json:"name"`
+	Trial string `json:"trial"` // 省略すると今の試行の名前
+	Client string `json:"client"` // /clock/report.json で使ったブラウザの名前(省略すると接続元の IP アドレス)
+	Data map[string]interface{} `json:"data"`
+	ClientTime *float64 `json:"client time"` // ページの時計の Unix 時間(ミリ秒)。JavaScript なら Date.now()
+}
+
+// /marker.json の返事
+type MarkerResponse struct {
+	Error string `json:"error,omitempty"`
+	Marker *eventlog.Marker `json:"marker,omitempty"`
+	ClockOffset *float64 `json:"clock offset msec,omitempty"` // server time - client time
+}
+
+// 目印を log とライブ配信に流します。
+// 試行が指定されていなければ今の試行の名前を使います。
+func (s *HttpService) PutMarker(request *MarkerRequest, received time.Time) (*eventlog.Marker, error) {
+	if request.Name == "" {
+		return nil, errors.New("marker name is empty")
+	}
+	marker := &eventlog.Marker{
+		Name: request.Name,
+		Trial: request.Trial,
+		ClientTime: request.ClientTime,
+		ServerTime: unixMillisecond(received),
+		Client: request.Client,
+	}
+	if clock, ok := s.BrowserClocks.Get(request.Client); ok && marker.ClientTime != nil {
+		offset := clock.Offset
+		marker.ClientOffset = &offset
+	}
+	session, trial := s.CurrentTrial()
+	marker.Session = session
+	if marker.Trial == "" && trial != nil {
+		marker.Trial = trial.Name
+	}
+	if len(request.Data) > 0 {
+		data, err := json.Marshal(request.Data)
+		if err != nil {
+			return nil, err
+		}
+		marker.Data = data
+	}
+	s.putLog(eventlog.TypeMarker, marker)
+	s.Stream.Publish("marker", marker)
+	return marker, nil
+}
+
+// POST された JSON の、またはクエリの目印を読み込みます。
+// クエリでは name, trial, client, client_time 以外の値を data に入れます。
+func readMarkerRequest(r *http.Request) (*MarkerRequest, error) {
+	request := &MarkerRequest{}
+	isForm := strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+	if r.Method == "POST" && !isForm {
+		decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, MaxMarkerBytes))
+		err := decoder.Decode(request)
+		if err != nil {
+			return nil, err
+		}
+		return request, nil
+	}
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	request.Data = map[string]interface{}{}
+	for key, values := range r.Form {
+		switch key {
+		case "name":
+			request.Name = values[0]
+		case "trial":
+			request.Trial = values[0]
+		case "client":
+			request.Client = values[0]
+		case "client_time":
+			clientTime, err := strconv.ParseFloat(values[0], 64)
+			if err != nil {
+				return nil, errors.New("client_time is not a number: " + values[0])
+			}
+			request.ClientTime = &clientTime
+		default:
+			request.Data[key] = values[0]
+		}
+	}
+	return request, nil
+}
+
+// 目印を受け付けて log に書き出します。
+// {"name": "stimulus shown", "trial": "bank", "data": {...}, "client time": Date.now()} を POST するか、
+// ?name=...&trial=...&client_time=... で受け取ります。
+func (s *HttpService) ServeMarker(w http.ResponseWriter, r *http.Request){
+	received := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	request, err := readMarkerRequest(r)
+	if err == nil {
+		request.Client = clientName(r, request.Client)
+		var marker *eventlog.Marker
+		marker, err = s.PutMarker(request, received)
+		if err == nil {
+			response := &MarkerResponse{Marker: marker}
+			if marker.ClientTime != nil {
+				offset := marker.ServerTime - *marker.ClientTime
+				response.ClockOffset = &offset
+			}
+			encoder.Encode(response)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	encoder.Encode(&MarkerResponse{Error: err.Error()})
+}
@@ -0,0 +1,341 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// 偽物の EyeTribe サーバが返す tracker の値
+type MockServerConfig struct {
+	Calibrated bool
+	HeartbeatInterval int64 // ミリ秒
+	ScreenWidth int64
+	ScreenHeight int64
+	FrameRate int64
+}
+
+// 実機が無くても eyetribe package を動かすための、偽物の EyeTribe サーバ
+// CreateServerConnection() 等が使うのと同じ JSON のやりとりをして、
+// push mode になったら GazePath か LoadFrameLog() で読み込んだフレームを順番に送りつけます。
+type MockServer struct {
+	Config MockServerConfig
+	Listener net.Listener
+	GazePath []Point // 送りつける視線の座標のリスト(最後まで行ったら最初に戻ります)
+	FrameArray []*Frame // log から読み込んだフレームのリスト(GazePath より優先されます)
+	CalibrationResult *CalibrationResult // 最後に行われたキャリブレーションの結果
+	lock sync.Mutex
+	connectionList []net.Conn
+	quit chan bool
+	closeOnce sync.Once
+}
+
+// 偽物のサーバの既定の設定を返します。
+func DefaultMockServerConfig() MockServerConfig {
+	return MockServerConfig{
+		Calibrated: true,
+		HeartbeatInterval: 3000,
+		ScreenWidth: 1920,
+		ScreenHeight: 1080,
+		FrameRate: 30,
+	}
+}
+
+// 偽物のサーバを起動します。
+// host_and_port は "hostname:port" と書きます。"localhost:0" なら空いている port を使います。
+func StartMockServer(host_and_port string, config MockServerConfig) (*MockServer, error) {
+	listener, err := net.Listen("tcp", host_and_port)
+	if err != nil {
+		return nil, err
+	}
+	if config.FrameRate <= 0 {
+		config.FrameRate = 30
+	}
+	s := &MockServer{
+		Config: config,
+		Listener: listener,
+		GazePath: CircleGazePath(float64(config.ScreenWidth) / 2, float64(config.ScreenHeight) / 2, 200, 90),
+		quit: make(chan bool),
+	}
+	go func(){
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.connectionList = append(s.connectionList, conn)
+			s.lock.Unlock()
+			go s.serveConnection(conn)
+		}
+	}()
+	return s, nil
+}
+
+// 実際に listen している "hostname:port" を返します。
+func (s *MockServer) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+// 偽物のサーバを止め、繋がっている接続を全て切ります。二度目からは何もしません。
+func (s *MockServer) Close() error {
+	if s == nil {
+		return errors.New("nil input")
+	}
+	var err error
+	s.closeOnce.Do(func(){
+		close(s.quit)
+		err = s.Listener.Close()
+		s.DropConnections()
+	})
+	return err
+}
+
+// 繋がっている接続を全て切ります。サーバが落ちた時の再接続を試すのに使います。
+func (s *MockServer) DropConnections() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.connectionList {
+		s.connectionList[i].Close()
+	}
+	s.connectionList = nil
+}
+
+// 中心 (cx, cy) 半径 r の円を n 個の点でなぞる視線の座標のリストを作ります。
+func CircleGazePath(cx float64, cy float64, r float64, n int) []Point {
+	result := []Point{}
+	for i := 0; i < n; i++ {
+		rad := 2.0 * math.Pi * float64(i) / float64(n)
+		result = append(result, Point{X: cx + r * math.Cos(rad), Y: cy + r * math.Sin(rad)})
+	}
+	return result
+}
+
+// eyebit server が書き出した log file からフレームを読み込んで、それを送りつけるようにします。
+func (s *MockServer) LoadFrameLog(fileName string) error {
+	frameArray, err := LoadFrameLogFile(fileName)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.FrameArray = frameArray
+	s.lock.Unlock()
+	return nil
+}
+
+// n 番目に送りつけるフレームを作ります。
+func (s *MockServer) createFrame(n int) *Frame {
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.FrameArray) > 0 {
+		original := *s.FrameArray[n % len(s.FrameArray)]
+		original.Timestamp = now.Format("2006-01-02 15:04:05.000")
+		original.Time = float64(now.UnixNano() / int64(time.Millisecond))
+		return &original
+	}
+	point := &Point{}
+	if len(s.GazePath) > 0 {
+		p := s.GazePath[n % len(s.GazePath)]
+		point = &Point{X: p.X, Y: p.Y}
+	}
+	eye := &EyeData{
+		Raw: point,
+		Avg: point,
+		Psize: 20,
+		Pcenter: &Point{X: 0.5, Y: 0.5},
+	}
+	return &Frame{
+		Timestamp: now.Format("2006-01-02 15:04:05.000"),
+		Time: float64(now.UnixNano() / int64(time.Millisecond)),
+		Fix: false,
+		State: 7, // STATE_TRACKING_GAZE | STATE_TRACKING_EYES | STATE_TRACKING_PRESENCE
+		Raw: point,
+		Avg: point,
+		LeftEye: eye,
+		RightEye: eye,
+	}
+}
+
+// tracker get で聞かれた値を返します。
+func (s *MockServer) trackerValue(name string) (interface{}, bool) {
+	switch name {
+	case "push":
+		return true, true
+	case "iscalibrated":
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return s.Config.Calibrated, true
+	case "heartbeatinterval":
+		return s.Config.HeartbeatInterval, true
+	case "screenresw":
+		return s.Config.ScreenWidth, true
+	case "screenresh":
+		return s.Config.ScreenHeight, true
+	case "framerate":
+		return s.Config.FrameRate, true
+	case "version":
+		return 1, true
+	}
+	return nil, false
+}
+
+// 一つの接続を処理します。
+func (s *MockServer) serveConnection(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	var writeLock sync.Mutex
+	send := func(v interface{}) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return encoder.Encode(v)
+	}
+	closed := make(chan bool)
+	defer close(closed)
+	pushStarted := false
+	calibrationPointCount := 0
+	calibrationPointList := []*Point{}
+
+	for {
+		var request RequestMessage
+		err := decoder.Decode(&request)
+		if err != nil {
+			return
+		}
+		response := map[string]interface{}{
+			"category": request.Category,
+			"statuscode": 200,
+		}
+		if request.Request != "" {
+			response["request"] = request.Request
+		}
+		switch {
+		case request.Category == "heartbeat":
+		case request.Category == "tracker" && request.Request == "get":
+			values := map[string]interface{}{}
+			if names, ok := request.Values.([]interface{}); ok {
+				for i := range names {
+					name, _ := names[i].(string)
+					if v, ok := s.trackerValue(name); ok {
+						values[name] = v
+					}
+				}
+			}
+			response["values"] = values
+		case request.Category == "tracker" && request.Request == "set":
+			if values, ok := request.Values.(map[string]interface{}); ok {
+				if push, _ := values["push"].(bool); push && !pushStarted {
+					pushStarted = true
+					go s.pushFrames(send, closed)
+				}
+			}
+		case request.Category == "calibration" && request.Request == "start":
+			calibrationPointCount = 0
+			calibrationPointList = []*Point{}
+			if values, ok := request.Values.(map[string]interface{}); ok {
+				if count, ok := values["pointcount"].(float64); ok {
+					calibrationPointCount = int(count)
+				}
+			}
+			if calibrationPointCount <= 0 {
+				response["statuscode"] = 403
+			}
+		case request.Category == "calibration" && request.Request == "pointstart":
+			if calibrationPointCount <= 0 {
+				response["statuscode"] = 403
+				break
+			}
+			point := &Point{}
+			if values, ok := request.Values.(map[string]interface{}); ok {
+				point.X, _ = values["x"].(float64)
+				point.Y, _ = values["y"].(float64)
+			}
+			calibrationPointList = append(calibrationPointList, point)
+		case request.Category == "calibration" && request.Request == "pointend":
+			if calibrationPointCount <= 0 || len(calibrationPointList) <= 0 {
+				response["statuscode"] = 403
+				break
+			}
+			if len(calibrationPointList) >= calibrationPointCount {
+				result := mockCalibrationResult(calibrationPointList)
+				s.lock.Lock()
+				s.CalibrationResult = result
+				s.Config.Calibrated = true
+				s.lock.Unlock()
+				response["values"] = map[string]interface{}{"calibresult": result}
+				calibrationPointCount = 0
+			}
+		case request.Category == "calibration" && request.Request == "abort":
+			calibrationPointCount = 0
+		case request.Category == "calibration" && request.Request == "clear":
+			s.lock.Lock()
+			s.CalibrationResult = nil
+			s.Config.Calibrated = false
+			s.lock.Unlock()
+		case request.Category == "calibration" && request.Request == "result":
+			s.lock.Lock()
+			result := s.CalibrationResult
+			s.lock.Unlock()
+			if result == nil {
+				response["statuscode"] = 403
+				break
+			}
+			response["values"] = map[string]interface{}{"calibresult": result}
+		default:
+			response["statuscode"] = 400
+		}
+		if err := send(response); err != nil {
+			return
+		}
+	}
+}
+
+// 見てもらった点のリストから、それらしいキャリブレーションの結果を作ります。
+func mockCalibrationResult(pointList []*Point) *CalibrationResult {
+	result := &CalibrationResult{
+		Result: true,
+		Deg: 0.5,
+		DegL: 0.6,
+		DegR: 0.4,
+	}
+	for i := range pointList {
+		p := pointList[i]
+		result.CalibPoints = append(result.CalibPoints, &CalibrationPoint{
+			State: 2,
+			Cp: &Point{X: p.X, Y: p.Y},
+			Mecp: &Point{X: p.X + 5, Y: p.Y - 3},
+			Acd: &CalibrationAccuracy{Average: 0.5, Left: 0.6, Right: 0.4},
+			Mepix: &CalibrationMeanError{Average: 15, Left: 18, Right: 12},
+			Asdp: &CalibrationDeviation{Average: 8, Left: 9, Right: 7},
+		})
+	}
+	return result
+}
+
+// framerate の間隔でフレームを送りつけます。
+func (s *MockServer) pushFrames(send func(interface{}) error, closed chan bool) {
+	ticker := time.NewTicker(time.Second / time.Duration(s.Config.FrameRate))
+	defer ticker.Stop()
+	for n := 0; ; n++ {
+		select {
+		case <- closed:
+			return
+		case <- s.quit:
+			return
+		case <- ticker.C:
+		}
+		message := &OneFrameMessage{
+			Category: "tracker",
+			Request: "get",
+			StatusCode: 200,
+			Values: map[string]*Frame{"frame": s.createFrame(n)},
+		}
+		if err := send(message); err != nil {
+			return
+		}
+	}
+}
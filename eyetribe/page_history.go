@@ -0,0 +1,79 @@
+package eyetribe
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 一つのページの表示
+type PageView struct {
+	Url string `json:"url"` // RequestURI
+	Time time.Time `json:"time"` // 要求を受け取った時刻
+}
+
+// 参加者が見ていたページの移り変わり
+// サーバを通したページの要求("navigation" として log に書くもの)を時刻の順に覚えておき、
+// ある時刻にどのページを表示していたかを返します。
+type PageHistory struct {
+	lock sync.RWMutex
+	viewList []PageView
+	maxViews int
+}
+
+// maxViews 個までページの表示を覚えておく PageHistory を作ります。
+func NewPageHistory(maxViews int) *PageHistory {
+	return &PageHistory{maxViews: maxViews}
+}
+
+// ページの表示を一つ追加します。古すぎるものは忘れます。
+func (h *PageHistory) Add(url string, t time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.viewList = append(h.viewList, PageView{Url: url, Time: t})
+	if h.maxViews > 0 && len(h.viewList) > h.maxViews {
+		h.viewList = append([]PageView{}, h.viewList[len(h.viewList) - h.maxViews:]...)
+	}
+}
+
+// 時刻 t に表示していたページを返します。分からなければ false を返します。
+func (h *PageHistory) At(t time.Time) (PageView, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	i := sort.Search(len(h.viewList), func(i int) bool {
+		return h.viewList[i].Time.After(t)
+	})
+	if i <= 0 {
+		return PageView{}, false
+	}
+	return h.viewList[i - 1], true
+}
+
+// 最後に表示したページを返します。
+func (h *PageHistory) Current() (PageView, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if len(h.viewList) <= 0 {
+		return PageView{}, false
+	}
+	return h.viewList[len(h.viewList) - 1], true
+}
+
+// ページから読み込まれるだけで、ページの表示ではないファイルの拡張子
+// .aspx や .dll 等、ここに無いものは全てページとします。
+var StaticAssetExtensions = map[string]bool{
+	".js": true, ".mjs": true, ".css": true, ".map": true, ".json": true, ".xml": true, ".txt": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true, ".svg": true, ".webp": true, ".ico": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp3": true, ".wav": true, ".ogg": true, ".mp4": true, ".webm": true,
+}
+
+// 要求された path がページ(画像や script ではないもの)かどうかを返します。
+func IsPagePath(urlPath string) bool {
+	if strings.HasSuffix(urlPath, "/") {
+		return true
+	}
+	return !StaticAssetExtensions[strings.ToLower(path.Ext(urlPath))]
+}
@@ -0,0 +1,98 @@
+package eyetribe
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"../eventlog"
+)
+
+// 接続に関するイベントを log に書き出します。
+// event は "disconnect" か "reconnect" です。
+func (c *EyeTribeConnection) PutConnectionEventLog(event string, reason string) error {
+	return c.PutLogRecord(eventlog.TypeConnection, &eventlog.Connection{
+		Event: event,
+		Reason: reason,
+	})
+}
+
+// サーバに繋ぎ直します。
+// 繋がるまで ReconnectMinInterval から ReconnectMaxInterval まで間隔を倍々に伸ばしながら試み、
+// 繋がったら GetServerStatus() を呼び直し、heartbeat を再開し、push mode に戻します。
+// 途中で QuitPullTask に通知が来た場合はエラーを返します。
+func (c *EyeTribeConnection) Reconnect(cause error) error {
+	reason := ""
+	if cause != nil {
+		reason = cause.Error()
+	}
+	fmt.Printf("connection lost. try to reconnect %s: %q\n", c.HostAndPort, reason)
+	c.PutConnectionEventLog("disconnect", reason)
+
+	c.StopHeartbeatTask()
+	c.ConnectionLock.Lock()
+	c.Connection.Close()
+	quit := c.QuitPullTask
+	c.ConnectionLock.Unlock()
+
+	interval := c.ReconnectMinInterval
+	if interval <= 0 {
+		interval = 1 * time.Second
+	}
+	for {
+		select {
+		case <- quit:
+			return errors.New("reconnect canceled")
+		case <- time.After(interval):
+		}
+		err := c.reconnectOnce()
+		if err == nil {
+			break
+		}
+		fmt.Printf("reconnect to %s failed. retry after %s: %q\n", c.HostAndPort, interval, err)
+		interval *= 2
+		if c.ReconnectMaxInterval > 0 && interval > c.ReconnectMaxInterval {
+			interval = c.ReconnectMaxInterval
+		}
+	}
+
+	fmt.Printf("reconnected to %s\n", c.HostAndPort)
+	c.PutConnectionEventLog("reconnect", "")
+	return nil
+}
+
+// 一度だけ繋ぎ直しを試みます。
+func (c *EyeTribeConnection) reconnectOnce() error {
+	rw, err := DialJsonReaderWriter(c.HostAndPort)
+	if err != nil {
+		return err
+	}
+	// 繋いでいる間に Close() が呼ばれていたら、新しい接続は Close() に閉じてもらえないので、
+	// ここで閉じてやめます(tracker を push mode のままにしないためです)。
+	c.ConnectionLock.Lock()
+	select {
+	case <- c.QuitPullTask:
+		c.ConnectionLock.Unlock()
+		rw.Close()
+		return errors.New("reconnect canceled")
+	default:
+	}
+	c.Connection = rw
+	c.ConnectionLock.Unlock()
+
+	calibrated, interval, err := c.GetServerStatus()
+	if err != nil {
+		rw.Close()
+		return err
+	}
+	if calibrated != true {
+		// キャリブレーションし直せば良いので、繋ぎ直しは続けます。
+		fmt.Println("Server is not calibrated. calibrate from /calibration.html")
+	}
+	err = c.SetPushMode()
+	if err != nil {
+		rw.Close()
+		return err
+	}
+	c.StartHeartbeatTask(time.Duration(int64(interval) / 2))
+	return nil
+}
@@ -0,0 +1,112 @@
+package eyetribe
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"../eventlog"
+)
+
+// eyebit server が書き出した log file からフレームだけを読み込みます。
+// フレーム以外の記録と読めない行は読み飛ばします。
+func LoadFrameLogFile(fileName string) ([]*Frame, error) {
+	frameArray := []*Frame{}
+	err := eventlog.ReadFile(fileName, func(record *eventlog.Record) error {
+		if record.Type != eventlog.TypeFrame {
+			return nil
+		}
+		var frame Frame
+		if record.Decode(&frame) != nil {
+			return nil
+		}
+		frameArray = append(frameArray, &frame)
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(frameArray) <= 0 {
+		return nil, errors.New(fmt.Sprintf("log file %s has no frame", fileName))
+	}
+	return frameArray, nil
+}
+
+// log file に記録されたフレームを、記録された時と同じ間隔で流しなおす GazeSource
+type LogReplaySource struct {
+	*GazeSampleBuffer
+	FrameArray []*Frame
+	Speed float64 // 再生速度(1.0 で記録された時と同じ速さ)
+	Loop bool // 最後まで流したら最初から流しなおすかどうか
+	quit chan bool
+}
+
+// log file を読み込んで、だいたい second[秒] 分のサンプルを溜め込む LogReplaySource を作ります。
+func NewLogReplaySource(fileName string, second int64, screenWidth int64, screenHeight int64) (*LogReplaySource, error) {
+	frameArray, err := LoadFrameLogFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	// log に記録されている時間から frame rate を見積もります。
+	frameRate := 30.0
+	first := frameArray[0].GoTime
+	last := frameArray[len(frameArray) - 1].GoTime
+	if len(frameArray) > 1 && last.Sub(first) > 0 {
+		frameRate = float64(len(frameArray) - 1) / last.Sub(first).Seconds()
+	}
+	return &LogReplaySource{
+		GazeSampleBuffer: NewGazeSampleBuffer(int(float64(second) * frameRate), screenWidth, screenHeight),
+		FrameArray: frameArray,
+		Speed: 1.0,
+		Loop: true,
+	}, nil
+}
+
+// i 番目のフレームから次のフレームまでの待ち時間を返します。
+func (r *LogReplaySource) frameInterval(i int) time.Duration {
+	interval := time.Second / 30
+	if i + 1 < len(r.FrameArray) {
+		d := r.FrameArray[i + 1].GoTime.Sub(r.FrameArray[i].GoTime)
+		if d > 0 && d < 10 * time.Second {
+			interval = d
+		}
+	}
+	if r.Speed > 0 {
+		interval = time.Duration(float64(interval) / r.Speed)
+	}
+	return interval
+}
+
+// 流しなおしを開始します。
+// 終了させるには Stop() を呼び出します。
+func (r *LogReplaySource) Start() {
+	r.quit = make(chan bool)
+	go func(quit chan bool){
+		i := 0
+		for {
+			frame := *r.FrameArray[i]
+			frame.GoTime = time.Now()
+			r.AddSample(FrameToGazeSample(&frame))
+			select {
+			case <- quit:
+				return
+			case <- time.After(r.frameInterval(i)):
+			}
+			i += 1
+			if i >= len(r.FrameArray) {
+				if r.Loop != true {
+					return
+				}
+				i = 0
+			}
+		}
+	}(r.quit)
+}
+
+// 流しなおしを終了します。
+func (r *LogReplaySource) Stop() {
+	if r.quit == nil {
+		return
+	}
+	close(r.quit)
+	r.quit = nil
+}
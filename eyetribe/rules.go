@@ -0,0 +1,292 @@
+package eyetribe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+	"../eventlog"
+)
+
+// 視線に応じて発火する規則の種類
+const (
+	RuleTypeDwell = "dwell" // aoi を min msec 以上見続けた
+	RuleTypeNotLooked = "not looked" // ページを開いてから within msec の間 aoi を見なかった
+	RuleTypeSequence = "sequence" // after を見た後で aoi に視線が入った
+)
+
+// これより長く視線が取れなければ、見続けていたことにしません(ミリ秒)
+const MaxRuleGapMsec = 100.0
+
+// 規則を確認する間隔
+const RuleTickInterval = 100 * time.Millisecond
+
+// 通知先の URL に POST する時の待ち時間
+const RuleCallbackTimeout = 5 * time.Second
+
+// 視線に応じて発火する規則
+// config.json の "rules" に書きます。一つのページを表示している間に一度だけ発火します。
+// 状態は名前で覚えておくので、設定を読み直しても同じ名前の規則は同じページで発火し直しません。
+type GazeRule struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	AOI string `json:"aoi"` // 対象の名前(表示しているページの対象から探します)
+	After string `json:"after"` // sequence: 先に見ているはずの対象の名前
+	MinMsec float64 `json:"min msec"` // dwell: 見続けている時間
+	WithinMsec float64 `json:"within msec"` // not looked: ページを開いてからの時間
+	UrlPattern string `json:"url pattern"` // この規則を使うページの URL の正規表現(空なら全てのページ)
+	CallbackUrl string `json:"callback url"` // 発火した時に RuleEvent を POST する先(空なら送りません)
+	pattern *regexp.Regexp
+}
+
+// 設定に誤りが無いかを確認して、URL の正規表現を読み込みます。
+func (r *GazeRule) Compile() error {
+	if r.Name == "" {
+		return errors.New("rule name is required")
+	}
+	if r.AOI == "" {
+		return errors.New(fmt.Sprintf("rule %s: aoi is required", r.Name))
+	}
+	switch r.Type {
+	case RuleTypeDwell:
+		if r.MinMsec <= 0 {
+			return errors.New(fmt.Sprintf("rule %s: dwell needs min msec", r.Name))
+		}
+	case RuleTypeNotLooked:
+		if r.WithinMsec <= 0 {
+			return errors.New(fmt.Sprintf("rule %s: not looked needs within msec", r.Name))
+		}
+	case RuleTypeSequence:
+		if r.After == "" {
+			return errors.New(fmt.Sprintf("rule %s: sequence needs after", r.Name))
+		}
+	default:
+		return errors.New(fmt.Sprintf("rule %s: unknown type: %s", r.Name, r.Type))
+	}
+	pattern, err := regexp.Compile(r.UrlPattern)
+	if err != nil {
+		return errors.New(fmt.Sprintf("rule %s: url pattern error: %s", r.Name, err))
+	}
+	r.pattern = pattern
+	return nil
+}
+
+// url のページでこの規則を使うかどうかを返します。
+func (r *GazeRule) MatchUrl(url string) bool {
+	if r.pattern != nil {
+		return r.pattern.MatchString(url)
+	}
+	matched, err := regexp.MatchString(r.UrlPattern, url)
+	return err == nil && matched
+}
+
+// 規則が発火したことを伝えるもの
+type RuleEvent struct {
+	Rule string `json:"rule"`
+	Type string `json:"type"`
+	AOI string `json:"aoi"`
+	Url string `json:"url"` // その時表示していたページ
+	Time time.Time `json:"time"`
+}
+
+// 一つの規則の、今表示しているページでの状態
+type ruleState struct {
+	fired bool
+	insideSince time.Time // dwell: 見続けはじめた時刻
+	lastInside time.Time // dwell: 最後に中にあった時刻
+	looked bool // not looked: 見たかどうか
+	seenAfter bool // sequence: after を見たかどうか
+	wasInside bool // sequence: 直前のサンプルが中にあったかどうか
+}
+
+// 流れてくるサンプルに対して規則を確認し続けるもの
+type RuleEngine struct {
+	service *HttpService
+	page PageView // 今表示しているページ
+	pageStart time.Time // 今表示しているページを開いた時刻
+	stateMap map[string]*ruleState // 規則の名前から状態を引きます
+	client *http.Client
+	samples chan GazeSample
+	quit chan bool
+	done chan bool
+}
+
+// GazeSource が流すサンプルで規則を確認し始めます。
+// GazeSource が GazeStreamer でなければ何もしません。
+func (s *HttpService) startRuleEngine() {
+	streamer, ok := s.Source.(GazeStreamer)
+	if !ok || s.Rules != nil {
+		return
+	}
+	e := &RuleEngine{
+		service: s,
+		pageStart: time.Now(),
+		stateMap: map[string]*ruleState{},
+		client: &http.Client{Timeout: RuleCallbackTimeout},
+		samples: streamer.Subscribe(256),
+		quit: make(chan bool),
+		done: make(chan bool),
+	}
+	s.Rules = e
+	go e.run()
+}
+
+// 規則の確認をやめます。
+func (s *HttpService) stopRuleEngine() {
+	streamer, ok := s.Source.(GazeStreamer)
+	if !ok || s.Rules == nil {
+		return
+	}
+	streamer.Unsubscribe(s.Rules.samples)
+	close(s.Rules.quit)
+	<- s.Rules.done
+	s.Rules = nil
+}
+
+func (e *RuleEngine) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(RuleTickInterval)
+	defer ticker.Stop()
+	samples := e.samples
+	for {
+		select {
+		case <- e.quit:
+			return
+		case sample, ok := <- samples:
+			if !ok {
+				samples = nil
+				continue
+			}
+			sample = e.service.WithPagePosition(sample)
+			e.step(&sample, sample.Time)
+		case now := <- ticker.C:
+			e.step(nil, now)
+		}
+	}
+}
+
+// 名前が name の対象を探します。
+func findTarget(targetList []*EyeTrackCheckPoint, name string) *EyeTrackCheckPoint {
+	for i := range targetList {
+		if targetList[i] != nil && targetList[i].Name == name {
+			return targetList[i]
+		}
+	}
+	return nil
+}
+
+// サンプルが対象の中にあるかどうかを返します。
+func sampleInside(sample *GazeSample, target *EyeTrackCheckPoint) bool {
+	return sample != nil && sample.Valid && target != nil && target.ContainsGaze(sample.Gaze())
+}
+
+// サンプルを一つ(nil なら時刻 now だけ)受け取って、全ての規則を確認します。
+func (e *RuleEngine) step(sample *GazeSample, now time.Time) {
+	if view, ok := e.service.Pages.Current(); ok && view != e.page {
+		// ページが変わったので、最初からやり直します。
+		e.page = view
+		e.pageStart = view.Time
+		e.stateMap = map[string]*ruleState{}
+	}
+	config := e.service.GetEyeTrackCheckConfig()
+	targetList, _ := config.TargetsFor(e.page.Url)
+	for _, rule := range config.RuleList {
+		if rule == nil || !rule.MatchUrl(e.page.Url) {
+			continue
+		}
+		state, ok := e.stateMap[rule.Name]
+		if !ok {
+			state = &ruleState{}
+			e.stateMap[rule.Name] = state
+		}
+		if state.fired {
+			continue
+		}
+		target := findTarget(targetList, rule.AOI)
+		if checkRule(rule, state, target, findTarget(targetList, rule.After), sample, now, e.pageStart) {
+			state.fired = true
+			e.fire(rule, config.CallbackUrlList, now)
+		}
+	}
+}
+
+// 規則が発火するかどうかを返します。
+// sample が nil の時は時刻 now になったことだけを確認します。pageStart はページを開いた時刻です。
+func checkRule(rule *GazeRule, state *ruleState, target *EyeTrackCheckPoint, after *EyeTrackCheckPoint, sample *GazeSample, now time.Time, pageStart time.Time) bool {
+	inside := sampleInside(sample, target)
+	switch rule.Type {
+	case RuleTypeDwell:
+		if sample == nil {
+			return false
+		}
+		if inside {
+			if state.insideSince.IsZero() {
+				state.insideSince = now
+			}
+			state.lastInside = now
+			return now.Sub(state.insideSince) >= time.Duration(rule.MinMsec * float64(time.Millisecond))
+		}
+		// 外を見たか、長く視線が取れなければ見続けていたことにしません。
+		if sample.Valid || now.Sub(state.lastInside) > time.Duration(MaxRuleGapMsec * float64(time.Millisecond)) {
+			state.insideSince = time.Time{}
+		}
+	case RuleTypeNotLooked:
+		if inside {
+			state.looked = true
+		}
+		return !state.looked && now.Sub(pageStart) >= time.Duration(rule.WithinMsec * float64(time.Millisecond))
+	case RuleTypeSequence:
+		if sample == nil || !sample.Valid {
+			return false
+		}
+		entered := inside && !state.wasInside && state.seenAfter
+		state.wasInside = inside
+		if sampleInside(sample, after) {
+			state.seenAfter = true
+		}
+		return entered
+	}
+	return false
+}
+
+// 規則が発火したことを log, ライブ配信, 通知先の URL に伝えます。
+func (e *RuleEngine) fire(rule *GazeRule, callbackUrlList []string, now time.Time) {
+	event := &RuleEvent{
+		Rule: rule.Name,
+		Type: rule.Type,
+		AOI: rule.AOI,
+		Url: e.page.Url,
+		Time: now,
+	}
+	fmt.Printf("rule %s fired on %s\n", rule.Name, e.page.Url)
+	e.service.putLog(eventlog.TypeAOIEvent, event)
+	e.service.Stream.Publish("rule", event)
+
+	urlList := append([]string{}, callbackUrlList...)
+	if rule.CallbackUrl != "" {
+		urlList = append(urlList, rule.CallbackUrl)
+	}
+	for _, url := range urlList {
+		go e.post(url, event)
+	}
+}
+
+// event を url に JSON で POST します。
+func (e *RuleEngine) post(url string, event *RuleEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	res, err := e.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		fmt.Printf("rule callback error: %q\n", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		fmt.Printf("rule callback %s returned %s\n", url, res.Status)
+	}
+}
@@ -0,0 +1,188 @@
+package eyetribe
+
+import (
+	"testing"
+	"time"
+)
+
+// 発火した規則を覚えておく LogWriter
+type ruleEventRecorder struct {
+	eventList []RuleEvent
+}
+
+func (r *ruleEventRecorder) PutLogRecord(recordType string, payload interface{}) error {
+	if event, ok := payload.(*RuleEvent); ok {
+		r.eventList = append(r.eventList, *event)
+	}
+	return nil
+}
+
+// 規則を確認する時に与えるサンプル
+type ruleInput struct {
+	msec float64 // ページを開いてからの時刻
+	x float64
+	y float64
+	valid bool
+}
+
+// from から to (ミリ秒、to を含みます) まで 33 ミリ秒毎に (x, y) を見ているサンプルを返します。
+func ruleInputs(from float64, to float64, x float64, y float64, valid bool) []ruleInput {
+	result := []ruleInput{}
+	for t := from; t <= to; t += 33 {
+		result = append(result, ruleInput{msec: t, x: x, y: y, valid: valid})
+	}
+	return result
+}
+
+func joinRuleInputs(lists ...[]ruleInput) []ruleInput {
+	result := []ruleInput{}
+	for _, list := range lists {
+		result = append(result, list...)
+	}
+	return result
+}
+
+// 対象 a (0, 0)-(100, 100) と b (200, 0)-(300, 100) に ruleList を使う設定を読み込んだ RuleEngine を作ります。
+func newTestRuleEngine(t *testing.T, ruleList []*GazeRule) (*RuleEngine, *ruleEventRecorder) {
+	s := NewHttpService(NewGazeSampleBuffer(10, 1920, 1080))
+	recorder := &ruleEventRecorder{}
+	s.Log = recorder
+	setTestRules(t, s, ruleList)
+	e := &RuleEngine{
+		service: s,
+		stateMap: map[string]*ruleState{},
+	}
+	return e, recorder
+}
+
+// 規則の設定を読み込み直します。
+func setTestRules(t *testing.T, s *HttpService, ruleList []*GazeRule) {
+	config := EyeTrackCheckConfig{
+		TargetList: []*EyeTrackCheckPoint{
+			{Name: "a", X: 0, Y: 0, Width: 100, Height: 100},
+			{Name: "b", X: 200, Y: 0, Width: 100, Height: 100},
+		},
+		RuleList: ruleList,
+	}
+	err := config.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CheckConfigLock.Lock()
+	s.CheckConfig = config
+	s.CheckConfigLock.Unlock()
+}
+
+// start に開いたページで inputs を順に与えます。
+func stepRuleInputs(e *RuleEngine, start time.Time, inputs []ruleInput) {
+	for _, input := range inputs {
+		now := start.Add(time.Duration(input.msec * float64(time.Millisecond)))
+		sample := GazeSample{Time: now, X: input.x, Y: input.y, Valid: input.valid}
+		e.step(&sample, now)
+	}
+}
+
+func TestRuleEngine(t *testing.T) {
+	dwell := &GazeRule{Name: "dwell", Type: RuleTypeDwell, AOI: "a", MinMsec: 300}
+	notLooked := &GazeRule{Name: "not looked", Type: RuleTypeNotLooked, AOI: "a", WithinMsec: 500}
+	sequence := &GazeRule{Name: "sequence", Type: RuleTypeSequence, AOI: "a", After: "b"}
+	tests := []struct {
+		name string
+		rule *GazeRule
+		inputs []ruleInput
+		want int // 発火する回数
+	}{
+		{"dwell", dwell, ruleInputs(0, 990, 50, 50, true), 1},
+		{"dwell too short", dwell, ruleInputs(0, 264, 50, 50, true), 0},
+		{"dwell broken by a look outside", dwell, joinRuleInputs(ruleInputs(0, 198, 50, 50, true), ruleInputs(231, 231, 500, 500, true), ruleInputs(264, 528, 50, 50, true)), 0},
+		{"dwell over a short track loss", dwell, joinRuleInputs(ruleInputs(0, 165, 50, 50, true), ruleInputs(198, 198, 0, 0, false), ruleInputs(231, 330, 50, 50, true)), 1},
+		{"not looked", notLooked, ruleInputs(0, 990, 500, 500, true), 1},
+		{"not looked before within", notLooked, ruleInputs(0, 462, 500, 500, true), 0},
+		{"looked", notLooked, joinRuleInputs(ruleInputs(0, 99, 500, 500, true), ruleInputs(132, 132, 50, 50, true), ruleInputs(165, 990, 500, 500, true)), 0},
+		{"sequence", sequence, joinRuleInputs(ruleInputs(0, 99, 250, 50, true), ruleInputs(132, 264, 50, 50, true)), 1},
+		{"sequence entered twice", sequence, joinRuleInputs(ruleInputs(0, 99, 250, 50, true), ruleInputs(132, 264, 50, 50, true), ruleInputs(297, 396, 250, 50, true), ruleInputs(429, 528, 50, 50, true)), 1},
+		{"sequence in the wrong order", sequence, joinRuleInputs(ruleInputs(0, 99, 50, 50, true), ruleInputs(132, 264, 250, 50, true)), 0},
+	}
+	for _, test := range tests {
+		e, recorder := newTestRuleEngine(t, []*GazeRule{test.rule})
+		start := time.Now()
+		e.service.Pages.Add("/page.html", start)
+		stepRuleInputs(e, start, test.inputs)
+		if len(recorder.eventList) != test.want {
+			t.Errorf("%s: fired %d times, want %d", test.name, len(recorder.eventList), test.want)
+			continue
+		}
+		for _, event := range recorder.eventList {
+			if event.Rule != test.rule.Name || event.Url != "/page.html" {
+				t.Errorf("%s: event = %+v", test.name, event)
+			}
+		}
+	}
+}
+
+// 発火した規則は、ページが変わると最初からやり直します。
+func TestRuleEnginePageChange(t *testing.T) {
+	rule := &GazeRule{Name: "dwell", Type: RuleTypeDwell, AOI: "a", MinMsec: 300}
+	e, recorder := newTestRuleEngine(t, []*GazeRule{rule})
+	start := time.Now()
+	e.service.Pages.Add("/first.html", start)
+	stepRuleInputs(e, start, ruleInputs(0, 990, 50, 50, true))
+	next := start.Add(time.Second)
+	e.service.Pages.Add("/second.html", next)
+	stepRuleInputs(e, next, ruleInputs(0, 990, 50, 50, true))
+	if len(recorder.eventList) != 2 || recorder.eventList[0].Url != "/first.html" || recorder.eventList[1].Url != "/second.html" {
+		t.Errorf("events = %+v, want one on each page", recorder.eventList)
+	}
+}
+
+// 設定を読み直しても、同じ名前の規則は同じページで発火し直しません。
+func TestRuleEngineReload(t *testing.T) {
+	newRuleList := func() []*GazeRule {
+		return []*GazeRule{
+			{Name: "dwell", Type: RuleTypeDwell, AOI: "a", MinMsec: 300},
+			{Name: "not looked", Type: RuleTypeNotLooked, AOI: "b", WithinMsec: 500},
+		}
+	}
+	e, recorder := newTestRuleEngine(t, newRuleList())
+	start := time.Now()
+	e.service.Pages.Add("/page.html", start)
+	stepRuleInputs(e, start, ruleInputs(0, 990, 50, 50, true))
+	if len(recorder.eventList) != 2 {
+		t.Fatalf("events before reload = %+v, want 2", recorder.eventList)
+	}
+	setTestRules(t, e.service, newRuleList())
+	stepRuleInputs(e, start, ruleInputs(1023, 1980, 50, 50, true))
+	if len(recorder.eventList) != 2 {
+		t.Errorf("events after reload = %+v, want no more", recorder.eventList)
+	}
+}
+
+func TestGazeRuleCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		rule GazeRule
+		ok bool
+	}{
+		{"dwell", GazeRule{Name: "r", Type: RuleTypeDwell, AOI: "a", MinMsec: 100}, true},
+		{"no name", GazeRule{Type: RuleTypeDwell, AOI: "a", MinMsec: 100}, false},
+		{"no aoi", GazeRule{Name: "r", Type: RuleTypeDwell, MinMsec: 100}, false},
+		{"dwell without min msec", GazeRule{Name: "r", Type: RuleTypeDwell, AOI: "a"}, false},
+		{"not looked without within msec", GazeRule{Name: "r", Type: RuleTypeNotLooked, AOI: "a"}, false},
+		{"sequence without after", GazeRule{Name: "r", Type: RuleTypeSequence, AOI: "a"}, false},
+		{"unknown type", GazeRule{Name: "r", Type: "stare", AOI: "a"}, false},
+		{"broken url pattern", GazeRule{Name: "r", Type: RuleTypeDwell, AOI: "a", MinMsec: 100, UrlPattern: "("}, false},
+	}
+	for _, test := range tests {
+		err := test.rule.Compile()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Compile() = %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+	config := EyeTrackCheckConfig{RuleList: []*GazeRule{
+		{Name: "r", Type: RuleTypeDwell, AOI: "a", MinMsec: 100},
+		{Name: "r", Type: RuleTypeSequence, AOI: "a", After: "b"},
+	}}
+	if config.Compile() == nil {
+		t.Errorf("Compile() should fail when two rules have the same name")
+	}
+}
@@ -0,0 +1,87 @@
+package eyetribe
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strconv"
+	"time"
+	"../aoi"
+	"../scanpath"
+)
+
+// since から後に始まった注視で gaze plot (scanpath) の画像を作ります。
+// 画像の大きさと座標は heatmap と同じように space で決めます。AOI は今のページのものを描きます。
+func (s *HttpService) CreateScanPathImage(space string, since time.Time, config *scanpath.Config) (*image.RGBA, error) {
+	renderer, err := scanpath.NewRenderer(config)
+	if err != nil {
+		return nil, err
+	}
+	width, height, usePage := s.imageSpace(space)
+	fixationList := []scanpath.Fixation{}
+	for _, f := range s.GetFixationDataList() {
+		if f.GoTime.Before(since) {
+			continue
+		}
+		x, y, ok := s.imagePosition(usePage, f.GoTime, f.X, f.Y)
+		if !ok {
+			continue
+		}
+		fixationList = append(fixationList, scanpath.Fixation{X: x, Y: y, Start: f.Start, Duration: f.Duration})
+	}
+	view, _ := s.Pages.Current()
+	checkConfig := s.GetEyeTrackCheckConfig()
+	targetList, _ := checkConfig.TargetsFor(view.Url)
+	areaList := targetList
+	if usePage {
+		// 画面の座標の対象は、ページ全体の画像には描けません。
+		areaList = aoi.InSpace(targetList, aoi.SpacePage)
+	}
+	return renderer.Render(int(width), int(height), fixationList, areaList), nil
+}
+
+// gaze plot の画像を返します。
+// delta_millisecond が指定されていたら、その時間内に始まった注視だけを描きます(既定は溜め込んでいる全て)。
+// space は /current_heatmap.png と同じです。color_by_time, aoi, hide_numbers で config.json の "scanpath" を上書きできます。
+func (s *HttpService) ServeScanPathPng(w http.ResponseWriter, r *http.Request){
+	space := r.FormValue("space")
+	if space == "" {
+		space = aoi.SpacePage
+	}
+	since := time.Time{}
+	if millisecond, err := strconv.Atoi(r.FormValue("delta_millisecond")); err == nil {
+		since = time.Now().Add(-time.Duration(millisecond) * time.Millisecond)
+	}
+	config := scanpath.Config{}
+	if c := s.GetEyeTrackCheckConfig().ScanPath; c != nil {
+		config = *c
+	}
+	boolValues := map[string]*bool{
+		"color_by_time": &config.ColorByTime,
+		"aoi": &config.AOIOutlines,
+		"hide_numbers": &config.HideNumbers,
+	}
+	for name, value := range boolValues {
+		if v := r.FormValue(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("scanpath config error: %s: %s", name, err)))
+				return
+			}
+			*value = b
+		}
+	}
+	if v := r.FormValue("color_map"); v != "" {
+		config.ColorMap = v
+	}
+	img, err := s.CreateScanPathImage(space, since, &config)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("scanpath config error: %s", err)))
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, img)
+}
@@ -0,0 +1,214 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"../eventlog"
+)
+
+// eyebit server の動かし方の設定
+// 既定値 → 設定ファイル → 環境変数 → コマンドライン引数 の順に上書きされます。
+type ServerConfig struct {
+	Source string `json:"source"` // 視線のサンプルを得る先("tracker", "replay:<log file>", "synthetic")
+	ScreenWidth int64 `json:"screen width"` // replay と synthetic の時の画面の大きさ(tracker の時は tracker から得ます)
+	ScreenHeight int64 `json:"screen height"`
+	FrameRate int64 `json:"frame rate"` // synthetic の時のフレームレート
+	TrackerAddress string `json:"tracker address"` // EyeTribe サーバの "hostname:port"
+	ListenAddress string `json:"listen address"` // HTTP で待ち受ける "hostname:port"
+	TLS bool `json:"tls"` // HTTPS で待ち受けるかどうか
+	CertFile string `json:"cert file"`
+	KeyFile string `json:"key file"`
+	StaticDirectory string `json:"static directory"` // "/" 以下で返すファイルのディレクトリ
+	BufferSeconds int64 `json:"buffer seconds"` // だいたい何秒分のフレームを溜め込むか
+	LogFileName string `json:"log file"`
+	CheckConfigFileName string `json:"check config file"` // EyeTrackCheckConfig のファイル
+	WatchCheckConfig bool `json:"watch check config"` // CheckConfigFileName が書き換えられたら読み直すかどうか
+	ShutdownTimeout int64 `json:"shutdown timeout msec"` // 終了時に処理中のリクエストを待つ時間(ミリ秒)
+	SessionDirectory string `json:"session directory"` // セッション毎の log file を作るディレクトリ("" なら LogFileName に区切りを書きます)
+	Experiment string `json:"experiment"` // セッションを始める時に指定が無ければ使う実験の名前
+	LogQueueSize int64 `json:"log queue size"` // 書き込みを待てる log の記録の数(溢れた分は捨てます)
+	LogSyncMsec int64 `json:"log sync msec"` // log file を fsync する間隔(ミリ秒、0 なら回転と終了の時だけ)
+	LogMaxBytes int64 `json:"log max bytes"` // log file がこれより大きくなったら回転します(0 なら回転しません)
+	LogMaxAgeSeconds int64 `json:"log max age seconds"` // log file をこれより長く書いたら回転します(0 なら回転しません)
+	LogCompress string `json:"log compress"` // 回転した log file の圧縮("" か "gzip")
+}
+
+// 設定を上書きする環境変数の名前
+const (
+	EnvSource = "EYEBIT_SOURCE"
+	EnvScreenWidth = "EYEBIT_SCREEN_WIDTH"
+	EnvScreenHeight = "EYEBIT_SCREEN_HEIGHT"
+	EnvFrameRate = "EYEBIT_FRAME_RATE"
+	EnvTrackerAddress = "EYEBIT_TRACKER_ADDRESS"
+	EnvListenAddress = "EYEBIT_LISTEN_ADDRESS"
+	EnvTLS = "EYEBIT_TLS"
+	EnvCertFile = "EYEBIT_CERT_FILE"
+	EnvKeyFile = "EYEBIT_KEY_FILE"
+	EnvStaticDirectory = "EYEBIT_STATIC_DIRECTORY"
+	EnvBufferSeconds = "EYEBIT_BUFFER_SECONDS"
+	EnvLogFileName = "EYEBIT_LOG_FILE"
+	EnvCheckConfigFileName = "EYEBIT_CHECK_CONFIG_FILE"
+	EnvWatchCheckConfig = "EYEBIT_WATCH_CHECK_CONFIG"
+	EnvShutdownTimeout = "EYEBIT_SHUTDOWN_TIMEOUT_MSEC"
+	EnvSessionDirectory = "EYEBIT_SESSION_DIRECTORY"
+	EnvExperiment = "EYEBIT_EXPERIMENT"
+	EnvLogQueueSize = "EYEBIT_LOG_QUEUE_SIZE"
+	EnvLogSyncMsec = "EYEBIT_LOG_SYNC_MSEC"
+	EnvLogMaxBytes = "EYEBIT_LOG_MAX_BYTES"
+	EnvLogMaxAgeSeconds = "EYEBIT_LOG_MAX_AGE_SECONDS"
+	EnvLogCompress = "EYEBIT_LOG_COMPRESS"
+)
+
+// 今までの決め打ちの値と同じ既定の設定を返します。
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Source: SourceTracker,
+		ScreenWidth: 1920,
+		ScreenHeight: 1080,
+		FrameRate: 30,
+		TrackerAddress: "localhost:6555",
+		ListenAddress: ":8888",
+		TLS: true,
+		CertFile: "ssl_key/server.crt",
+		KeyFile: "ssl_key/server.key",
+		StaticDirectory: "./static/",
+		BufferSeconds: 30,
+		LogFileName: "log.json",
+		CheckConfigFileName: "config.json",
+		WatchCheckConfig: false,
+		ShutdownTimeout: 5000,
+		SessionDirectory: "",
+		Experiment: "default",
+		LogQueueSize: 8192,
+		LogSyncMsec: 1000,
+		LogMaxBytes: 0,
+		LogMaxAgeSeconds: 0,
+		LogCompress: "",
+	}
+}
+
+// 設定ファイル(JSON)を読み込んで上書きします。ファイルに書かれていない項目はそのままです。
+func (c *ServerConfig) LoadFile(fileName string) error {
+	configFile, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer configFile.Close()
+	decoder := json.NewDecoder(configFile)
+	return decoder.Decode(c)
+}
+
+// 環境変数が設定されていれば、その値で上書きします。
+func (c *ServerConfig) LoadEnvironment() error {
+	stringValues := map[string]*string{
+		EnvSource: &c.Source,
+		EnvTrackerAddress: &c.TrackerAddress,
+		EnvListenAddress: &c.ListenAddress,
+		EnvCertFile: &c.CertFile,
+		EnvKeyFile: &c.KeyFile,
+		EnvStaticDirectory: &c.StaticDirectory,
+		EnvLogFileName: &c.LogFileName,
+		EnvCheckConfigFileName: &c.CheckConfigFileName,
+		EnvSessionDirectory: &c.SessionDirectory,
+		EnvExperiment: &c.Experiment,
+		EnvLogCompress: &c.LogCompress,
+	}
+	for name, value := range stringValues {
+		if v, ok := os.LookupEnv(name); ok {
+			*value = v
+		}
+	}
+	if v, ok := os.LookupEnv(EnvTLS); ok {
+		tls, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", EnvTLS, err))
+		}
+		c.TLS = tls
+	}
+	if v, ok := os.LookupEnv(EnvBufferSeconds); ok {
+		second, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", EnvBufferSeconds, err))
+		}
+		c.BufferSeconds = second
+	}
+	if v, ok := os.LookupEnv(EnvWatchCheckConfig); ok {
+		watch, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", EnvWatchCheckConfig, err))
+		}
+		c.WatchCheckConfig = watch
+	}
+	if v, ok := os.LookupEnv(EnvShutdownTimeout); ok {
+		millisecond, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", EnvShutdownTimeout, err))
+		}
+		c.ShutdownTimeout = millisecond
+	}
+	int64Values := map[string]*int64{
+		EnvScreenWidth: &c.ScreenWidth,
+		EnvScreenHeight: &c.ScreenHeight,
+		EnvFrameRate: &c.FrameRate,
+		EnvLogQueueSize: &c.LogQueueSize,
+		EnvLogSyncMsec: &c.LogSyncMsec,
+		EnvLogMaxBytes: &c.LogMaxBytes,
+		EnvLogMaxAgeSeconds: &c.LogMaxAgeSeconds,
+	}
+	for name, value := range int64Values {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s: %s", name, err))
+			}
+			*value = n
+		}
+	}
+	return nil
+}
+
+// log file の書き込み方の設定を返します。
+func (c *ServerConfig) LogFileOptions() eventlog.FileOptions {
+	options := eventlog.DefaultFileOptions()
+	options.QueueSize = int(c.LogQueueSize)
+	options.SyncInterval = time.Duration(c.LogSyncMsec) * time.Millisecond
+	options.MaxBytes = c.LogMaxBytes
+	options.MaxAge = time.Duration(c.LogMaxAgeSeconds) * time.Second
+	options.Compress = c.LogCompress
+	return options
+}
+
+// 設定がおかしくないかを確認します。
+func (c *ServerConfig) Validate() error {
+	kind, _, err := ParseSourceSpec(c.Source)
+	if err != nil {
+		return err
+	}
+	if kind == SourceTracker && c.TrackerAddress == "" {
+		return errors.New("tracker address is empty")
+	}
+	if c.ScreenWidth <= 0 || c.ScreenHeight <= 0 {
+		return errors.New(fmt.Sprintf("screen size must be positive: %dx%d", c.ScreenWidth, c.ScreenHeight))
+	}
+	if c.FrameRate <= 0 {
+		return errors.New(fmt.Sprintf("frame rate must be positive: %d", c.FrameRate))
+	}
+	if c.ListenAddress == "" {
+		return errors.New("listen address is empty")
+	}
+	if c.TLS && (c.CertFile == "" || c.KeyFile == "") {
+		return errors.New("TLS is enabled but cert file or key file is empty")
+	}
+	if c.BufferSeconds <= 0 {
+		return errors.New(fmt.Sprintf("buffer seconds must be positive: %d", c.BufferSeconds))
+	}
+	if c.LogSyncMsec < 0 {
+		return errors.New(fmt.Sprintf("log sync msec must not be negative: %d", c.LogSyncMsec))
+	}
+	options := c.LogFileOptions()
+	return options.Validate()
+}
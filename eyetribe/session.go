@@ -0,0 +1,310 @@
+package eyetribe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+	"../eventlog"
+)
+
+// log の書き出し先を切り替えられるもの
+type LogSwitcher interface {
+	SetLogFile(fileName string) error
+	GetLogFileName() string
+	CloseLogFile() error
+}
+
+// tracker の設定を返せるもの
+type TrackerInfo interface {
+	GetTrackerSettings() TrackerSettings
+}
+
+// セッションの中の一つの試行
+type Trial struct {
+	Name string `json:"name"`
+	Index int `json:"index"` // セッションの中で何番目か(1 から)
+	Condition string `json:"condition,omitempty"` // 実験条件
+	StartTime time.Time `json:"start time"`
+	EndTime *time.Time `json:"end time,omitempty"`
+}
+
+// 一人の参加者の一回のセッション
+// 実験(experiment) → 参加者(participant) → セッション → 試行(trial) の順にまとまります。
+type Session struct {
+	Experiment string `json:"experiment"`
+	Participant string `json:"participant"`
+	Id string `json:"session"`
+	StartTime time.Time `json:"start time"`
+	EndTime *time.Time `json:"end time,omitempty"`
+	LogFileName string `json:"log file,omitempty"` // セッション毎の log file (同じ log に書いていれば "")
+	TrialList []*Trial `json:"trials"`
+}
+
+// セッションの始めに log に書き出す情報
+type SessionHeader struct {
+	Experiment string `json:"experiment"`
+	Participant string `json:"participant"`
+	Id string `json:"session"`
+	StartTime time.Time `json:"start time"`
+	ScreenWidth int64 `json:"screen width"`
+	ScreenHeight int64 `json:"screen height"`
+	Tracker *TrackerSettings `json:"tracker,omitempty"`
+	CheckConfig EyeTrackCheckConfig `json:"check config"`
+	Version string `json:"software version"`
+}
+
+// log に書き出す試行の始まりと終わり
+type TrialLog struct {
+	Trial *Trial `json:"trial"`
+	Session string `json:"session"`
+}
+
+// 今のセッションと終わったセッションを覚えておくもの
+type SessionManager struct {
+	lock sync.Mutex
+	Directory string // セッション毎の log file を作るディレクトリ("" なら今の log に区切りを書くだけにします)
+	Experiment string // experiment が指定されなかった時の実験の名前
+	current *Session
+	previousLogFileName string // セッションを始める前の log file の名前
+	sessionList []*Session
+}
+
+func NewSessionManager() *SessionManager {
+	return &SessionManager{}
+}
+
+// ファイル名に使えない文字を "_" にします。
+var unsafeFileNameCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+func safeFileName(name string) string {
+	name = unsafeFileNameCharacters.ReplaceAllString(name, "_")
+	if name == "" || name[0] == '.' {
+		name = "_" + name
+	}
+	return name
+}
+
+// 記録を一つ log に書き出します。
+func (s *HttpService) putLog(recordType string, payload interface{}) {
+	if s.Log == nil {
+		return
+	}
+	err := s.Log.PutLogRecord(recordType, payload)
+	if err != nil {
+		fmt.Printf("log write error: %q\n", err)
+	}
+}
+
+// セッションを始めます。今のセッションがあれば先に終わらせます。
+// Directory が指定されていれば <Directory>/<experiment>/<participant>/<session>.json に log を書き出します。
+func (s *HttpService) StartSession(experiment string, participant string, id string) (*Session, error) {
+	if participant == "" {
+		return nil, errors.New("participant is required")
+	}
+	m := s.Sessions
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.current != nil {
+		s.endSession()
+	}
+	now := time.Now()
+	if experiment == "" {
+		experiment = m.Experiment
+	}
+	if id == "" {
+		id = now.Format("20060102_150405")
+	}
+	session := &Session{
+		Experiment: experiment,
+		Participant: participant,
+		Id: id,
+		StartTime: now,
+		TrialList: []*Trial{},
+	}
+	if switcher, ok := s.Source.(LogSwitcher); ok && m.Directory != "" {
+		dirName := filepath.Join(m.Directory, safeFileName(experiment), safeFileName(participant))
+		err := os.MkdirAll(dirName, 0770)
+		if err != nil {
+			return nil, err
+		}
+		fileName := filepath.Join(dirName, safeFileName(id) + ".json")
+		previous := switcher.GetLogFileName()
+		err = switcher.SetLogFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		m.previousLogFileName = previous
+		session.LogFileName = fileName
+	}
+
+	header := &SessionHeader{
+		Experiment: experiment,
+		Participant: participant,
+		Id: id,
+		StartTime: now,
+		CheckConfig: s.GetEyeTrackCheckConfig(),
+		Version: Version,
+	}
+	header.ScreenWidth, header.ScreenHeight = s.Source.ScreenSize()
+	if info, ok := s.Source.(TrackerInfo); ok {
+		settings := info.GetTrackerSettings()
+		header.Tracker = &settings
+	}
+	s.putLog(eventlog.TypeSessionStart, header)
+	m.current = session
+	m.sessionList = append(m.sessionList, session)
+	fmt.Printf("session %s started (experiment %s, participant %s)\n", id, experiment, participant)
+	copied := copySession(session)
+	return &copied, nil
+}
+
+// 今のセッションを終わらせます。セッションが無ければ nil を返します。
+func (s *HttpService) EndSession() *Session {
+	m := s.Sessions
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return s.endSession()
+}
+
+// Sessions.lock を持った状態で、今のセッションを終わらせます。
+func (s *HttpService) endSession() *Session {
+	m := s.Sessions
+	session := m.current
+	if session == nil {
+		return nil
+	}
+	now := time.Now()
+	s.endTrial(now)
+	session.EndTime = &now
+	s.putLog(eventlog.TypeSessionEnd, session)
+	if switcher, ok := s.Source.(LogSwitcher); ok && session.LogFileName != "" {
+		// セッションを始める前の log に戻します。
+		var err error
+		if m.previousLogFileName != "" {
+			err = switcher.SetLogFile(m.previousLogFileName)
+		}else{
+			err = switcher.CloseLogFile()
+		}
+		if err != nil {
+			fmt.Printf("log file switch error: %q\n", err)
+		}
+	}
+	m.current = nil
+	fmt.Printf("session %s ended\n", session.Id)
+	copied := copySession(session)
+	return &copied
+}
+
+// 今のセッションで試行を始めます。前の試行が終わっていなければ先に終わらせます。
+func (s *HttpService) StartTrial(name string, condition string) (*Trial, error) {
+	m := s.Sessions
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.current == nil {
+		return nil, errors.New("no session is running")
+	}
+	now := time.Now()
+	s.endTrial(now)
+	trial := &Trial{
+		Name: name,
+		Index: len(m.current.TrialList) + 1,
+		Condition: condition,
+		StartTime: now,
+	}
+	if trial.Name == "" {
+		trial.Name = fmt.Sprintf("trial%d", trial.Index)
+	}
+	m.current.TrialList = append(m.current.TrialList, trial)
+	s.putLog(eventlog.TypeTrialStart, &TrialLog{Trial: trial, Session: m.current.Id})
+	copied := *trial
+	return &copied, nil
+}
+
+// 今の試行を終わらせます。
+func (s *HttpService) EndTrial() (*Trial, error) {
+	m := s.Sessions
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.current == nil {
+		return nil, errors.New("no session is running")
+	}
+	trial := s.endTrial(time.Now())
+	if trial == nil {
+		return nil, errors.New("no trial is running")
+	}
+	copied := *trial
+	return &copied, nil
+}
+
+// Sessions.lock を持った状態で、終わっていない試行を終わらせます。
+func (s *HttpService) endTrial(now time.Time) *Trial {
+	m := s.Sessions
+	if m.current == nil || len(m.current.TrialList) <= 0 {
+		return nil
+	}
+	trial := m.current.TrialList[len(m.current.TrialList) - 1]
+	if trial.EndTime != nil {
+		return nil
+	}
+	trial.EndTime = &now
+	s.putLog(eventlog.TypeTrialEnd, &TrialLog{Trial: trial, Session: m.current.Id})
+	return trial
+}
+
+// 今のセッションを返します。無ければ nil を返します。
+func (s *HttpService) CurrentSession() *Session {
+	m := s.Sessions
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.current == nil {
+		return nil
+	}
+	session := copySession(m.current)
+	return &session
+}
+
+// 今のセッションの ID と、終わっていない試行を返します。
+// セッションが無ければ "" を、試行が無ければ nil を返します。
+func (s *HttpService) CurrentTrial() (string, *Trial) {
+	m := s.Sessions
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.current == nil {
+		return "", nil
+	}
+	if len(m.current.TrialList) <= 0 {
+		return m.current.Id, nil
+	}
+	trial := *m.current.TrialList[len(m.current.TrialList) - 1]
+	if trial.EndTime != nil {
+		return m.current.Id, nil
+	}
+	return m.current.Id, &trial
+}
+
+// 後から書き換えられても良いように、試行まで写したものを返します。
+func copySession(session *Session) Session {
+	result := *session
+	result.TrialList = []*Trial{}
+	for _, trial := range session.TrialList {
+		copied := *trial
+		result.TrialList = append(result.TrialList, &copied)
+	}
+	return result
+}
+
+// このサーバを動かしてから始めた全てのセッションを返します。
+func (s *HttpService) SessionList() []Session {
+	m := s.Sessions
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := []Session{}
+	for _, session := range m.sessionList {
+		result = append(result, copySession(session))
+	}
+	return result
+}
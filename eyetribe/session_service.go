@@ -0,0 +1,81 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// セッション用の HTTP の返事
+type SessionServiceResponse struct {
+	Error string `json:"error,omitempty"`
+	Session *Session `json:"session,omitempty"`
+	Trial *Trial `json:"trial,omitempty"`
+	Sessions []Session `json:"sessions,omitempty"`
+}
+
+// セッション用の返事を JSON で書き出します。
+func writeSessionResponse(w http.ResponseWriter, response *SessionServiceResponse, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		response = &SessionServiceResponse{Error: err.Error()}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(response)
+}
+
+// セッションを始めます。
+// participant(必須), experiment, session(省略すると開始時刻)を受け取ります。
+func (s *HttpService) ServeSessionStart(w http.ResponseWriter, r *http.Request) {
+	session, err := s.StartSession(r.FormValue("experiment"), r.FormValue("participant"), r.FormValue("session"))
+	writeSessionResponse(w, &SessionServiceResponse{Session: session}, err)
+}
+
+// 今のセッションを終わらせます。
+func (s *HttpService) ServeSessionEnd(w http.ResponseWriter, r *http.Request) {
+	writeSessionResponse(w, &SessionServiceResponse{Session: s.EndSession()}, nil)
+}
+
+// 今のセッションを返します。
+func (s *HttpService) ServeSessionCurrent(w http.ResponseWriter, r *http.Request) {
+	writeSessionResponse(w, &SessionServiceResponse{Session: s.CurrentSession()}, nil)
+}
+
+// このサーバを動かしてから始めた全てのセッションを返します。
+func (s *HttpService) ServeSessionList(w http.ResponseWriter, r *http.Request) {
+	writeSessionResponse(w, &SessionServiceResponse{Sessions: s.SessionList()}, nil)
+}
+
+// 今のセッションで試行を始めます。name, condition を受け取ります。
+func (s *HttpService) ServeTrialStart(w http.ResponseWriter, r *http.Request) {
+	trial, err := s.StartTrial(r.FormValue("name"), r.FormValue("condition"))
+	writeSessionResponse(w, &SessionServiceResponse{Trial: trial}, err)
+}
+
+// 今の試行を終わらせます。
+func (s *HttpService) ServeTrialEnd(w http.ResponseWriter, r *http.Request) {
+	trial, err := s.EndTrial()
+	writeSessionResponse(w, &SessionServiceResponse{Trial: trial}, err)
+}
+
+// セッション用の HTTP の入り口を登録します。
+func (s *HttpService) handleSession() {
+	s.Mux.HandleFunc("/session/start.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeSessionStart(w, r)
+	})
+	s.Mux.HandleFunc("/session/end.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeSessionEnd(w, r)
+	})
+	s.Mux.HandleFunc("/session/current.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeSessionCurrent(w, r)
+	})
+	s.Mux.HandleFunc("/session/list.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeSessionList(w, r)
+	})
+	s.Mux.HandleFunc("/session/trial/start.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeTrialStart(w, r)
+	})
+	s.Mux.HandleFunc("/session/trial/end.json", func(w http.ResponseWriter, r *http.Request){
+		s.ServeTrialEnd(w, r)
+	})
+}
@@ -0,0 +1,258 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ライブ配信で流す一つのイベント
+// Type が "gaze" の時は Data に GazeSample が入ります。
+type StreamEvent struct {
+	Type string `json:"type"`
+	Time time.Time `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// ライブ配信を見ている全てのクライアントにイベントを配るもの
+type StreamHub struct {
+	lock sync.Mutex
+	subscribers map[chan *StreamEvent]bool
+	closed bool
+}
+
+// 空の StreamHub を作ります。
+func NewStreamHub() *StreamHub {
+	return &StreamHub{
+		subscribers: map[chan *StreamEvent]bool{},
+	}
+}
+
+// イベントを受け取る channel を作ります。
+// 受け取る側が遅れて bufferSize 以上溜まった分は捨てられます。
+func (h *StreamHub) Subscribe(bufferSize int) chan *StreamEvent {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ch := make(chan *StreamEvent, bufferSize)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subscribers[ch] = true
+	return ch
+}
+
+// Subscribe() で作った channel を閉じて、受け取るのをやめます。
+func (h *StreamHub) Unsubscribe(ch chan *StreamEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.subscribers[ch]; !ok {
+		return
+	}
+	delete(h.subscribers, ch)
+	close(ch)
+}
+
+// イベントを全てのクライアントに配ります。
+func (h *StreamHub) Publish(eventType string, data interface{}) {
+	event := &StreamEvent{
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// 全ての channel を閉じて、ライブ配信を終わらせます。
+// この後の Subscribe() では閉じられた channel が返ります。
+func (h *StreamHub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for ch := range h.subscribers {
+		close(ch)
+	}
+	h.subscribers = map[chan *StreamEvent]bool{}
+	h.closed = true
+}
+
+// GazeSource が流すサンプルを "gaze" イベントとして StreamHub に流し始めます。
+// GazeSource が GazeStreamer でなければ何もしません。
+func (s *HttpService) startGazeStream() {
+	streamer, ok := s.Source.(GazeStreamer)
+	if !ok || s.gazeStream != nil {
+		return
+	}
+	s.gazeStream = streamer.Subscribe(256)
+	go func(ch chan GazeSample){
+		for sample := range ch {
+			sample := s.WithPagePosition(sample)
+			s.Stream.Publish("gaze", &sample)
+		}
+	}(s.gazeStream)
+}
+
+// StreamHub にサンプルを流すのをやめます。
+func (s *HttpService) stopGazeStream() {
+	streamer, ok := s.Source.(GazeStreamer)
+	if !ok || s.gazeStream == nil {
+		return
+	}
+	streamer.Unsubscribe(s.gazeStream)
+	s.gazeStream = nil
+}
+
+// 画面の大きさ
+type ScreenSizeData struct {
+	Width int64 `json:"width"`
+	Height int64 `json:"height"`
+}
+
+// 繋がってきたクライアントに最初に送る、画面の大きさを伝えるイベントを作ります。
+// クライアントはこれを使って視線の座標を自分の表示に合わせます。
+func (s *HttpService) screenEvent() *StreamEvent {
+	width, height := s.Source.ScreenSize()
+	return &StreamEvent{
+		Type: "screen",
+		Time: time.Now(),
+		Data: &ScreenSizeData{Width: width, Height: height},
+	}
+}
+
+// クライアント毎の間引き
+// "gaze" イベントは interval より短い間隔では送らず、それ以外のイベントは全て送ります。
+type streamThrottle struct {
+	interval time.Duration
+	last time.Time
+}
+
+// イベントを送って良いかどうかを返します。
+func (t *streamThrottle) pass(event *StreamEvent) bool {
+	if event.Type != "gaze" || t.interval <= 0 {
+		return true
+	}
+	if event.Time.Sub(t.last) < t.interval {
+		return false
+	}
+	t.last = event.Time
+	return true
+}
+
+// ライブ配信を行います。
+// WebSocket への切り替えを求められたら WebSocket で、そうでなければ Server-Sent Events で流します。
+// interval_msec が指定されていれば、"gaze" イベントをその間隔まで間引きます。
+func (s *HttpService) ServeStream(w http.ResponseWriter, r *http.Request) {
+	throttle := &streamThrottle{}
+	millisecond, err := strconv.Atoi(r.FormValue("interval_msec"))
+	if err == nil {
+		throttle.interval = time.Duration(millisecond) * time.Millisecond
+	}
+	if isWebSocketRequest(r) {
+		s.serveWebSocketStream(w, r, throttle)
+		return
+	}
+	s.serveEventStream(w, r, throttle)
+}
+
+// WebSocket でイベントを流します。
+func (s *HttpService) serveWebSocketStream(w http.ResponseWriter, r *http.Request, throttle *streamThrottle) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		fmt.Printf("websocket upgrade error: %q\n", err)
+		return
+	}
+	defer conn.Close()
+	closed := make(chan bool)
+	go conn.readLoop(closed)
+
+	ch := s.Stream.Subscribe(256)
+	defer s.Stream.Unsubscribe(ch)
+	data, err := json.Marshal(s.screenEvent())
+	if err == nil {
+		conn.WriteText(data)
+	}
+	for {
+		select {
+		case <- closed:
+			return
+		case event, ok := <- ch:
+			if !ok {
+				// サーバが止められました。
+				return
+			}
+			if !throttle.pass(event) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			err = conn.WriteText(data)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Server-Sent Events でイベントを流します。
+func (s *HttpService) serveEventStream(w http.ResponseWriter, r *http.Request, throttle *streamThrottle) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := s.Stream.Subscribe(256)
+	defer s.Stream.Unsubscribe(ch)
+	data, err := json.Marshal(s.screenEvent())
+	if err == nil {
+		fmt.Fprintf(w, "event: screen\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <- r.Context().Done():
+			return
+		case <- keepAlive.C:
+			// 途中の proxy 等に切られないように、コメントだけを送ります。
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <- ch:
+			if !ok {
+				// サーバが止められました。
+				return
+			}
+			if !throttle.pass(event) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
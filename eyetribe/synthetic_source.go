@@ -0,0 +1,83 @@
+package eyetribe
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 決められた視線の座標のリストをなぞるサンプルを作り続ける GazeSource
+type SyntheticGazeSource struct {
+	*GazeSampleBuffer
+	GazePath []Point // なぞる視線の座標のリスト(最後まで行ったら最初に戻ります)
+	FrameRate int64
+	Noise float64 // 座標に加えるゆらぎの大きさ(ピクセル)
+	quit chan bool
+}
+
+// だいたい second[秒] 分のサンプルを溜め込む SyntheticGazeSource を作ります。
+// path が空であれば画面の中心を回る円をなぞります。
+func NewSyntheticGazeSource(path []Point, frameRate int64, second int64, screenWidth int64, screenHeight int64) *SyntheticGazeSource {
+	if frameRate <= 0 {
+		frameRate = 30
+	}
+	if len(path) <= 0 {
+		path = CircleGazePath(float64(screenWidth) / 2, float64(screenHeight) / 2, 200, 90)
+	}
+	return &SyntheticGazeSource{
+		GazeSampleBuffer: NewGazeSampleBuffer(int(second * frameRate), screenWidth, screenHeight),
+		GazePath: path,
+		FrameRate: frameRate,
+	}
+}
+
+// n 番目のサンプルを作ります。
+func (g *SyntheticGazeSource) createSample(n int) GazeSample {
+	now := time.Now()
+	p := g.GazePath[n % len(g.GazePath)]
+	x := p.X
+	y := p.Y
+	if g.Noise > 0 {
+		x += rand.NormFloat64() * g.Noise
+		y += rand.NormFloat64() * g.Noise
+	}
+	eye := &EyeSample{X: x, Y: y, PupilSize: 20}
+	return GazeSample{
+		Time: now,
+		TrackerTime: float64(now.UnixNano() / int64(time.Millisecond)),
+		X: x,
+		Y: y,
+		RawX: x,
+		RawY: y,
+		Valid: true,
+		State: 7, // STATE_TRACKING_GAZE | STATE_TRACKING_EYES | STATE_TRACKING_PRESENCE
+		LeftEye: eye,
+		RightEye: eye,
+	}
+}
+
+// サンプルを作り始めます。
+// 終了させるには Stop() を呼び出します。
+func (g *SyntheticGazeSource) Start() {
+	g.quit = make(chan bool)
+	ticker := time.NewTicker(time.Second / time.Duration(g.FrameRate))
+	go func(quit chan bool){
+		defer ticker.Stop()
+		for n := 0; ; n++ {
+			select {
+			case <- quit:
+				return
+			case <- ticker.C:
+				g.AddSample(g.createSample(n))
+			}
+		}
+	}(g.quit)
+}
+
+// サンプルを作るのを終了します。
+func (g *SyntheticGazeSource) Stop() {
+	if g.quit == nil {
+		return
+	}
+	close(g.quit)
+	g.quit = nil
+}
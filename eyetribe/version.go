@@ -0,0 +1,5 @@
+package eyetribe
+
+// eyebit server の版
+// session の header に書き出して、どの版で取った log なのかを分かるようにします。
+const Version = "1.1.0"
@@ -0,0 +1,92 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"../aoi"
+	"../eventlog"
+	"../viewport"
+)
+
+// 覚えておくページの表示の報告の数
+const MaxViewportStates = 100000
+
+// 視線のページ(document)上の位置
+type PagePosition struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Inside bool `json:"inside"` // ページの見えている所(viewport)の中か
+}
+
+// AOI の確認に使う視線の位置を返します。ページ上の位置が分からなければ画面の座標を使います。
+func (g *GazeSample) Gaze() aoi.Gaze {
+	if g.Page == nil {
+		return aoi.ScreenGaze(g.X, g.Y)
+	}
+	return aoi.Gaze{X: g.X, Y: g.Y, PageX: g.Page.X, PageY: g.Page.Y, OnPage: g.Page.Inside}
+}
+
+// 時刻 t にページがどう表示されていたかを返します。
+// 今のページを開いてから報告されたものだけを使い、分からなければ false を返します。
+func (s *HttpService) ViewportAt(t time.Time) (viewport.State, bool) {
+	state, ok := s.Viewports.At(t)
+	if !ok {
+		return state, false
+	}
+	if view, ok := s.Pages.At(t); ok && state.Time.Before(view.Time) {
+		// 前のページで報告されたものです。
+		return viewport.State{}, false
+	}
+	return state, true
+}
+
+// 画面上の座標 (x, y) を、時刻 t のページ上の位置にします。分からなければ nil を返します。
+func (s *HttpService) PagePositionAt(t time.Time, x float64, y float64) *PagePosition {
+	state, ok := s.ViewportAt(t)
+	if !ok {
+		return nil
+	}
+	pageX, pageY, inside := state.Map(x, y)
+	return &PagePosition{X: pageX, Y: pageY, Inside: inside}
+}
+
+// サンプルにページ上の位置を入れたものを返します。
+func (s *HttpService) WithPagePosition(sample GazeSample) GazeSample {
+	if sample.Valid {
+		sample.Page = s.PagePositionAt(sample.Time, sample.X, sample.Y)
+	}
+	return sample
+}
+
+// ページから表示の様子を受け取ります。
+// POST で State の JSON を受け取って覚え、log とライブ配信に流します。GET では最後に受け取ったものを返します。
+func (s *HttpService) ServeViewport(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	if r.Method != "POST" {
+		state, ok := s.Viewports.Current()
+		if !ok {
+			encoder.Encode(nil)
+			return
+		}
+		encoder.Encode(&state)
+		return
+	}
+	var state viewport.State
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, MaxMarkerBytes))
+	err := decoder.Decode(&state)
+	if err == nil {
+		err = state.Validate()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	state.Time = time.Now()
+	s.Viewports.Add(state)
+	s.putLog(eventlog.TypeViewport, &state)
+	s.Stream.Publish("viewport", &state)
+	encoder.Encode(&state)
+}
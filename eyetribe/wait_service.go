@@ -0,0 +1,113 @@
+package eyetribe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// /wait.json で待つ時間の既定値と最大値
+const (
+	DefaultWaitTimeout = 30 * time.Second
+	MaxWaitTimeout = 10 * time.Minute
+	DefaultWaitMinMsec = 100.0 // fixation.DefaultMinMsec と同じ長さ見続ければ注視したものとします
+)
+
+// /wait.json で返すもの
+type WaitResult struct {
+	AOI string `json:"aoi"`
+	Looked bool `json:"looked"` // 条件を満たしたかどうか
+	Timeout bool `json:"timeout"` // 条件を満たさないまま待ち時間が過ぎたかどうか
+	WaitedMsec int64 `json:"waited msec"` // 待っていた時間
+	Url string `json:"url"` // 条件を満たした時に表示していたページ
+	Time time.Time `json:"time"` // 条件を満たした(か、待つのをやめた)時刻
+}
+
+// aoi を minMsec 以上見続けるまで、受け取ったサンプルを見ながら待ちます。
+// 待ち時間が過ぎるか、cancel が閉じられるか、ライブ配信が終わったら見ていないものとして返します。
+func (s *HttpService) WaitForLook(aoiName string, minMsec float64, timeout time.Duration, cancel <-chan struct{}) WaitResult {
+	start := time.Now()
+	result := WaitResult{AOI: aoiName}
+	rule := &GazeRule{Name: "wait", Type: RuleTypeDwell, AOI: aoiName, MinMsec: minMsec}
+	state := &ruleState{}
+	events := s.Stream.Subscribe(256)
+	defer s.Stream.Unsubscribe(events)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event, ok := <- events:
+			if !ok {
+				result.Time = time.Now()
+				result.WaitedMsec = int64(result.Time.Sub(start) / time.Millisecond)
+				return result
+			}
+			sample, isGaze := event.Data.(*GazeSample)
+			if event.Type != "gaze" || !isGaze {
+				continue
+			}
+			url := ""
+			if view, ok := s.Pages.Current(); ok {
+				url = view.Url
+			}
+			config := s.GetEyeTrackCheckConfig()
+			targetList, _ := config.TargetsFor(url)
+			if checkRule(rule, state, findTarget(targetList, aoiName), nil, sample, sample.Time, start) {
+				result.Looked = true
+				result.Url = url
+				result.Time = sample.Time
+				result.WaitedMsec = int64(sample.Time.Sub(start) / time.Millisecond)
+				return result
+			}
+		case <- timer.C:
+			result.Timeout = true
+			result.Time = time.Now()
+			result.WaitedMsec = int64(result.Time.Sub(start) / time.Millisecond)
+			return result
+		case <- cancel:
+			result.Time = time.Now()
+			result.WaitedMsec = int64(result.Time.Sub(start) / time.Millisecond)
+			return result
+		}
+	}
+}
+
+// 指定された対象を見るまで待ってから返します。
+// aoi: 対象の名前(必須、今表示しているページの対象に無ければ 400 を返します), min_msec: 見続ける時間(default 100), timeout_msec: 待つ時間(default 30秒)
+func (s *HttpService) ServeWait(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	aoiName := r.FormValue("aoi")
+	if aoiName == "" {
+		http.Error(w, "aoi is required", http.StatusBadRequest)
+		return
+	}
+	// 今表示しているページに無い対象は、待っても見られることがありません。
+	url := ""
+	if view, ok := s.Pages.Current(); ok {
+		url = view.Url
+	}
+	config := s.GetEyeTrackCheckConfig()
+	targetList, _ := config.TargetsFor(url)
+	if findTarget(targetList, aoiName) == nil {
+		http.Error(w, fmt.Sprintf("unknown aoi: %s", aoiName), http.StatusBadRequest)
+		return
+	}
+	minMsec := DefaultWaitMinMsec
+	value, err := strconv.ParseFloat(r.FormValue("min_msec"), 64)
+	if err == nil && value > 0 {
+		minMsec = value
+	}
+	timeout := DefaultWaitTimeout
+	millisecond, err := strconv.Atoi(r.FormValue("timeout_msec"))
+	if err == nil && millisecond > 0 {
+		timeout = time.Duration(millisecond) * time.Millisecond
+	}
+	if timeout > MaxWaitTimeout {
+		timeout = MaxWaitTimeout
+	}
+	result := s.WaitForLook(aoiName, minMsec, timeout, r.Context().Done())
+	encoder := json.NewEncoder(w)
+	encoder.Encode(&result)
+}
@@ -0,0 +1,195 @@
+package eyetribe
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WebSocket の opcode
+const (
+	webSocketOpText = 0x1
+	webSocketOpClose = 0x8
+	webSocketOpPing = 0x9
+	webSocketOpPong = 0xA
+)
+
+// Sec-WebSocket-Accept を作るための決まった文字列(RFC 6455)
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// サーバ側から送るだけの簡単な WebSocket の接続
+// クライアントから来るメッセージは close と ping 以外は読み捨てます。
+type webSocketConn struct {
+	reader io.Reader
+	writer io.Writer
+	flush func() error
+	close func() error
+	writeLock sync.Mutex
+}
+
+// リクエストが WebSocket への切り替えを求めているかどうかを返します。
+func isWebSocketRequest(r *http.Request) bool {
+	if r.Method == "CONNECT" {
+		// HTTP/2 の extended CONNECT (RFC 8441)
+		return r.Header.Get(":protocol") == "websocket" || r.Proto == "websocket"
+	}
+	return strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// HTTP の接続を WebSocket に切り替えます。
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*webSocketConn, error) {
+	if r.Method == "CONNECT" {
+		// HTTP/2 では stream をそのまま使います。
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			return nil, errors.New("websocket: response can not flush")
+		}
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		return &webSocketConn{
+			reader: r.Body,
+			writer: w,
+			flush: func() error { flusher.Flush(); return nil },
+			close: r.Body.Close,
+		}, nil
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("websocket: bad handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response can not hijack")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	accept := base64.StdEncoding.EncodeToString(hash[:])
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	err = rw.Flush()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &webSocketConn{
+		reader: rw.Reader,
+		writer: rw.Writer,
+		flush: rw.Writer.Flush,
+		close: conn.Close,
+	}, nil
+}
+
+// 一つのフレームを送ります。
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	header := []byte{0x80 | opcode}
+	length := len(payload)
+	switch {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	_, err := c.writer.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = c.writer.Write(payload)
+	if err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+// テキストのメッセージを一つ送ります。
+func (c *webSocketConn) WriteText(data []byte) error {
+	return c.writeFrame(webSocketOpText, data)
+}
+
+// 一つのフレームを受け取ります。
+func (c *webSocketConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1] & 0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > 1024 * 1024 {
+		return 0, nil, errors.New("websocket: frame too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i % 4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// クライアントから来るフレームを読み続け、接続が閉じられたら closed を閉じます。
+// ping には pong を返し、close には close を返します。
+func (c *webSocketConn) readLoop(closed chan bool) {
+	defer close(closed)
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case webSocketOpClose:
+			c.writeFrame(webSocketOpClose, payload)
+			return
+		case webSocketOpPing:
+			c.writeFrame(webSocketOpPong, payload)
+		}
+	}
+}
+
+// 接続を切ります。
+func (c *webSocketConn) Close() error {
+	return c.close()
+}
@@ -0,0 +1,198 @@
+package fixation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// 注視の判定に使う一つの視線のサンプル
+type Sample struct {
+	Time float64 // tracker の時刻(ミリ秒)
+	X float64 // 画面上のピクセル
+	Y float64
+	Valid bool // 視線が取れているかどうか
+}
+
+// 一つの注視
+// 時刻は Sample.Time と同じ tracker の時刻(ミリ秒)です。
+type Fixation struct {
+	Start float64 `json:"start"`
+	End float64 `json:"end"`
+	Duration float64 `json:"duration"`
+	X float64 `json:"x"` // 重心
+	Y float64 `json:"y"`
+	Dispersion float64 `json:"dispersion"` // (x の幅) + (y の幅) [ピクセル]
+	StartIndex int `json:"start_index"` // Detect() に渡したサンプルの何番目から
+	EndIndex int `json:"end_index"` // 何番目までか(この番号のものも含みます)
+}
+
+// 注視を見つけるもの
+type Detector interface {
+	// 時刻の順に並んだサンプルから注視を見つけて、時刻の順に返します。
+	Detect(samples []Sample) []Fixation
+}
+
+// 注視の判定の設定
+// config.json の "fixation" に書きます。
+type Config struct {
+	Algorithm string `json:"algorithm"` // "idt"(default) か "ivt"
+	Unit string `json:"unit"` // 閾値の単位。"pixel"(default) か "degree"(視角)
+	MaxDistance float64 `json:"max distance"` // I-DT: 注視とみなす dispersion の最大値
+	MaxVelocity float64 `json:"max velocity"` // I-VT: 注視とみなす速度の最大値(一秒あたり)
+	MinMsec float64 `json:"min msec"` // 注視とみなす最短の時間(ミリ秒)
+	MaxGapMsec float64 `json:"max gap msec"` // これより長く視線が取れなければ、注視を区切ります
+	ViewingDistanceMM float64 `json:"viewing distance mm"` // degree の時に使う、目と画面の距離
+	ScreenWidthMM float64 `json:"screen width mm"` // degree の時に使う、画面の幅
+	ScreenWidthPixel float64 `json:"screen width pixel"` // degree の時に使う、画面の幅(0 なら tracker の値を使います)
+}
+
+// 既定値
+const (
+	DefaultMaxDistance = 50.0 // ピクセル
+	DefaultMaxDistanceDegree = 1.0
+	DefaultMaxVelocity = 1000.0 // ピクセル/秒
+	DefaultMaxVelocityDegree = 30.0
+	DefaultMinMsec = 100.0
+	DefaultMaxGapMsec = 100.0
+)
+
+// 1度の視角が画面上で何ピクセルになるかを返します。
+func PixelsPerDegree(viewingDistanceMM float64, screenWidthMM float64, screenWidthPixel float64) float64 {
+	mmPerDegree := 2.0 * viewingDistanceMM * math.Tan(math.Pi / 360.0)
+	return mmPerDegree * screenWidthPixel / screenWidthMM
+}
+
+// 設定に書かれた画面の大きさと距離から、1度の視角が何ピクセルになるかを返します。
+// 分からない時は 0 を返します。
+func (c *Config) PixelsPerDegree() float64 {
+	if c == nil || c.ViewingDistanceMM <= 0 || c.ScreenWidthMM <= 0 || c.ScreenWidthPixel <= 0 {
+		return 0
+	}
+	return PixelsPerDegree(c.ViewingDistanceMM, c.ScreenWidthMM, c.ScreenWidthPixel)
+}
+
+// 閾値をピクセルにするための倍率を返します。
+func (c *Config) pixelScale() (float64, error) {
+	switch c.Unit {
+	case "", "pixel":
+		return 1.0, nil
+	case "degree":
+		if c.ViewingDistanceMM <= 0 || c.ScreenWidthMM <= 0 || c.ScreenWidthPixel <= 0 {
+			return 0, errors.New("degree unit needs viewing distance mm, screen width mm and screen width pixel")
+		}
+		return PixelsPerDegree(c.ViewingDistanceMM, c.ScreenWidthMM, c.ScreenWidthPixel), nil
+	}
+	return 0, errors.New(fmt.Sprintf("unknown fixation unit: %s", c.Unit))
+}
+
+// 設定から Detector を作ります。config が nil なら既定の I-DT を作ります。
+func NewDetector(config *Config) (Detector, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	scale, err := config.pixelScale()
+	if err != nil {
+		return nil, err
+	}
+	degree := config.Unit == "degree"
+	minMsec := config.MinMsec
+	if minMsec <= 0 {
+		minMsec = DefaultMinMsec
+	}
+	maxGap := config.MaxGapMsec
+	if maxGap <= 0 {
+		maxGap = DefaultMaxGapMsec
+	}
+	switch config.Algorithm {
+	case "", "idt":
+		maxDistance := config.MaxDistance
+		if maxDistance <= 0 {
+			maxDistance = DefaultMaxDistance
+			if degree {
+				maxDistance = DefaultMaxDistanceDegree
+			}
+		}
+		return &IDT{
+			MaxDispersion: maxDistance * scale,
+			MinDuration: minMsec,
+			MaxGap: maxGap,
+		}, nil
+	case "ivt":
+		maxVelocity := config.MaxVelocity
+		if maxVelocity <= 0 {
+			maxVelocity = DefaultMaxVelocity
+			if degree {
+				maxVelocity = DefaultMaxVelocityDegree
+			}
+		}
+		return &IVT{
+			MaxVelocity: maxVelocity * scale,
+			MinDuration: minMsec,
+			MaxGap: maxGap,
+		}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown fixation algorithm: %s", config.Algorithm))
+}
+
+// 視線の取れているサンプルだけを、maxGap より長く途切れた所で区切って返します。
+// 返すのは元のサンプルの番号のリストです。
+func splitValidSegments(samples []Sample, maxGap float64) [][]int {
+	result := [][]int{}
+	current := []int{}
+	for i := range samples {
+		if !samples[i].Valid {
+			continue
+		}
+		if len(current) > 0 && samples[i].Time - samples[current[len(current) - 1]].Time > maxGap {
+			result = append(result, current)
+			current = []int{}
+		}
+		current = append(current, i)
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+// indexList のサンプルを一つの注視にまとめます。
+func newFixation(samples []Sample, indexList []int) Fixation {
+	first := samples[indexList[0]]
+	last := samples[indexList[len(indexList) - 1]]
+	sumX := 0.0
+	sumY := 0.0
+	for _, i := range indexList {
+		sumX += samples[i].X
+		sumY += samples[i].Y
+	}
+	n := float64(len(indexList))
+	return Fixation{
+		Start: first.Time,
+		End: last.Time,
+		Duration: last.Time - first.Time,
+		X: sumX / n,
+		Y: sumY / n,
+		Dispersion: dispersion(samples, indexList),
+		StartIndex: indexList[0],
+		EndIndex: indexList[len(indexList) - 1],
+	}
+}
+
+// indexList のサンプルの (x の幅) + (y の幅) を返します。
+func dispersion(samples []Sample, indexList []int) float64 {
+	if len(indexList) <= 0 {
+		return 0
+	}
+	minX := samples[indexList[0]].X
+	maxX := minX
+	minY := samples[indexList[0]].Y
+	maxY := minY
+	for _, i := range indexList {
+		minX = math.Min(minX, samples[i].X)
+		maxX = math.Max(maxX, samples[i].X)
+		minY = math.Min(minY, samples[i].Y)
+		maxY = math.Max(maxY, samples[i].Y)
+	}
+	return (maxX - minX) + (maxY - minY)
+}
@@ -0,0 +1,190 @@
+package fixation
+
+import (
+	"math"
+	"testing"
+)
+
+// from から to (ミリ秒、to を含みます) まで 10 ミリ秒毎に (x, y) を見ているサンプルを返します。
+func still(from float64, to float64, x float64, y float64, valid bool) []Sample {
+	result := []Sample{}
+	for t := from; t <= to; t += 10 {
+		result = append(result, Sample{Time: t, X: x, Y: y, Valid: valid})
+	}
+	return result
+}
+
+func join(list ...[]Sample) []Sample {
+	result := []Sample{}
+	for _, samples := range list {
+		result = append(result, samples...)
+	}
+	return result
+}
+
+// x が左右に 2 ピクセルづつ揺れるサンプルを返します。
+func jitter(from float64, to float64, x float64, y float64) []Sample {
+	result := still(from, to, x, y, true)
+	for i := range result {
+		if i % 2 == 0 {
+			result[i].X -= 2
+		} else {
+			result[i].X += 2
+		}
+	}
+	return result
+}
+
+var detectTests = []struct {
+	name string
+	samples []Sample
+	want []Fixation
+}{
+	{
+		name: "one fixation",
+		samples: still(0, 290, 100, 100, true),
+		want: []Fixation{
+			{Start: 0, End: 290, Duration: 290, X: 100, Y: 100, StartIndex: 0, EndIndex: 29},
+		},
+	},
+	{
+		name: "jitter inside a fixation",
+		samples: jitter(0, 190, 100, 100),
+		want: []Fixation{
+			{Start: 0, End: 190, Duration: 190, X: 100, Y: 100, Dispersion: 4, StartIndex: 0, EndIndex: 19},
+		},
+	},
+	{
+		name: "saccade between two fixations",
+		samples: join(still(0, 190, 100, 100, true), still(200, 390, 500, 500, true)),
+		want: []Fixation{
+			{Start: 0, End: 190, Duration: 190, X: 100, Y: 100, StartIndex: 0, EndIndex: 19},
+			{Start: 200, End: 390, Duration: 190, X: 500, Y: 500, StartIndex: 20, EndIndex: 39},
+		},
+	},
+	{
+		name: "too short",
+		samples: still(0, 50, 100, 100, true),
+		want: []Fixation{},
+	},
+	{
+		name: "long gap splits a fixation",
+		samples: join(still(0, 190, 100, 100, true), still(200, 390, 0, 0, false), still(400, 590, 100, 100, true)),
+		want: []Fixation{
+			{Start: 0, End: 190, Duration: 190, X: 100, Y: 100, StartIndex: 0, EndIndex: 19},
+			{Start: 400, End: 590, Duration: 190, X: 100, Y: 100, StartIndex: 40, EndIndex: 59},
+		},
+	},
+	{
+		name: "no valid sample",
+		samples: still(0, 290, 0, 0, false),
+		want: []Fixation{},
+	},
+}
+
+func TestDetect(t *testing.T) {
+	detectorList := []struct {
+		name string
+		detector Detector
+	}{
+		{"idt", &IDT{MaxDispersion: DefaultMaxDistance, MinDuration: DefaultMinMsec, MaxGap: DefaultMaxGapMsec}},
+		{"ivt", &IVT{MaxVelocity: DefaultMaxVelocity, MinDuration: DefaultMinMsec, MaxGap: DefaultMaxGapMsec}},
+	}
+	for _, d := range detectorList {
+		for _, test := range detectTests {
+			got := d.detector.Detect(test.samples)
+			if len(got) != len(test.want) {
+				t.Errorf("%s %s: got %d fixations, want %d: %+v", d.name, test.name, len(got), len(test.want), got)
+				continue
+			}
+			for i := range got {
+				if !sameFixation(got[i], test.want[i]) {
+					t.Errorf("%s %s: fixation %d = %+v, want %+v", d.name, test.name, i, got[i], test.want[i])
+				}
+			}
+		}
+	}
+}
+
+func sameFixation(a Fixation, b Fixation) bool {
+	near := func(x float64, y float64) bool {
+		return math.Abs(x - y) < 1e-9
+	}
+	return near(a.Start, b.Start) && near(a.End, b.End) && near(a.Duration, b.Duration) &&
+		near(a.X, b.X) && near(a.Y, b.Y) && near(a.Dispersion, b.Dispersion) &&
+		a.StartIndex == b.StartIndex && a.EndIndex == b.EndIndex
+}
+
+func TestVelocity(t *testing.T) {
+	tests := []struct {
+		a Sample
+		b Sample
+		want float64
+	}{
+		{Sample{Time: 0, X: 0, Y: 0}, Sample{Time: 10, X: 3, Y: 4}, 500},
+		{Sample{Time: 0, X: 0, Y: 0}, Sample{Time: 1000, X: 0, Y: 0}, 0},
+		{Sample{Time: 10, X: 0, Y: 0}, Sample{Time: 10, X: 100, Y: 0}, 0}, // 時刻が同じなら 0
+	}
+	for _, test := range tests {
+		got := Velocity(test.a, test.b)
+		if got != test.want {
+			t.Errorf("Velocity(%+v, %+v) = %f, want %f", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestNewDetector(t *testing.T) {
+	ppd := PixelsPerDegree(600, 520, 1920)
+	tests := []struct {
+		name string
+		config *Config
+		want Detector
+		err bool
+	}{
+		{"nil", nil, &IDT{MaxDispersion: 50, MinDuration: 100, MaxGap: 100}, false},
+		{"idt", &Config{Algorithm: "idt", MaxDistance: 30, MinMsec: 80, MaxGapMsec: 75}, &IDT{MaxDispersion: 30, MinDuration: 80, MaxGap: 75}, false},
+		{"ivt", &Config{Algorithm: "ivt"}, &IVT{MaxVelocity: 1000, MinDuration: 100, MaxGap: 100}, false},
+		{"idt degree", &Config{Unit: "degree", ViewingDistanceMM: 600, ScreenWidthMM: 520, ScreenWidthPixel: 1920}, &IDT{MaxDispersion: ppd, MinDuration: 100, MaxGap: 100}, false},
+		{"ivt degree", &Config{Algorithm: "ivt", Unit: "degree", ViewingDistanceMM: 600, ScreenWidthMM: 520, ScreenWidthPixel: 1920}, &IVT{MaxVelocity: 30 * ppd, MinDuration: 100, MaxGap: 100}, false},
+		{"degree without screen", &Config{Unit: "degree"}, nil, true},
+		{"unknown unit", &Config{Unit: "inch"}, nil, true},
+		{"unknown algorithm", &Config{Algorithm: "hmm"}, nil, true},
+	}
+	for _, test := range tests {
+		got, err := NewDetector(test.config)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: want an error, got %+v", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		switch want := test.want.(type) {
+		case *IDT:
+			d, ok := got.(*IDT)
+			if !ok || math.Abs(d.MaxDispersion - want.MaxDispersion) > 1e-9 || d.MinDuration != want.MinDuration || d.MaxGap != want.MaxGap {
+				t.Errorf("%s: got %+v, want %+v", test.name, got, want)
+			}
+		case *IVT:
+			d, ok := got.(*IVT)
+			if !ok || math.Abs(d.MaxVelocity - want.MaxVelocity) > 1e-9 || d.MinDuration != want.MinDuration || d.MaxGap != want.MaxGap {
+				t.Errorf("%s: got %+v, want %+v", test.name, got, want)
+			}
+		}
+	}
+}
+
+func TestPixelsPerDegree(t *testing.T) {
+	// 57.3cm 離れると 1cm がほぼ 1 度になります。
+	got := PixelsPerDegree(573, 100, 100)
+	if math.Abs(got - 10) > 0.01 {
+		t.Errorf("PixelsPerDegree(573, 100, 100) = %f, want about 10", got)
+	}
+	var config *Config
+	if config.PixelsPerDegree() != 0 {
+		t.Errorf("nil config should give 0")
+	}
+}
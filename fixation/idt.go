@@ -0,0 +1,45 @@
+package fixation
+
+// dispersion の閾値で注視を見つける I-DT (Dispersion-Threshold Identification)
+// MinDuration の長さの窓の dispersion が MaxDispersion 以下であれば注視の始まりとし、
+// dispersion が MaxDispersion を超えるまで窓を広げたものを一つの注視とします。
+type IDT struct {
+	MaxDispersion float64 // ピクセル
+	MinDuration float64 // ミリ秒
+	MaxGap float64 // ミリ秒
+}
+
+func (d *IDT) Detect(samples []Sample) []Fixation {
+	result := []Fixation{}
+	for _, segment := range splitValidSegments(samples, d.MaxGap) {
+		result = append(result, d.detectSegment(samples, segment)...)
+	}
+	return result
+}
+
+// 途切れの無い一続きのサンプルから注視を見つけます。
+func (d *IDT) detectSegment(samples []Sample, segment []int) []Fixation {
+	result := []Fixation{}
+	start := 0
+	for start < len(segment) {
+		// MinDuration の長さになるまで窓を広げます。
+		end := start
+		for end < len(segment) && samples[segment[end]].Time - samples[segment[start]].Time < d.MinDuration {
+			end += 1
+		}
+		if end >= len(segment) {
+			break
+		}
+		if dispersion(samples, segment[start:end + 1]) > d.MaxDispersion {
+			start += 1
+			continue
+		}
+		// dispersion が閾値を超えるまで窓を広げます。
+		for end + 1 < len(segment) && dispersion(samples, segment[start:end + 2]) <= d.MaxDispersion {
+			end += 1
+		}
+		result = append(result, newFixation(samples, segment[start:end + 1]))
+		start = end + 1
+	}
+	return result
+}
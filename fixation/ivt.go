@@ -0,0 +1,60 @@
+package fixation
+
+import (
+	"math"
+)
+
+// 速度の閾値で注視を見つける I-VT (Velocity-Threshold Identification)
+// 前のサンプルからの速度が MaxVelocity より遅いサンプルが続いている所を、
+// MinDuration 以上続いていれば一つの注視とします。
+type IVT struct {
+	MaxVelocity float64 // ピクセル/秒
+	MinDuration float64 // ミリ秒
+	MaxGap float64 // ミリ秒
+}
+
+func (d *IVT) Detect(samples []Sample) []Fixation {
+	result := []Fixation{}
+	for _, segment := range splitValidSegments(samples, d.MaxGap) {
+		result = append(result, d.detectSegment(samples, segment)...)
+	}
+	return result
+}
+
+// サンプル a から b への速度(ピクセル/秒)を返します。
+func Velocity(a Sample, b Sample) float64 {
+	dt := b.Time - a.Time
+	if dt <= 0 {
+		return 0
+	}
+	return math.Hypot(b.X - a.X, b.Y - a.Y) / dt * 1000.0
+}
+
+// 途切れの無い一続きのサンプルから注視を見つけます。
+func (d *IVT) detectSegment(samples []Sample, segment []int) []Fixation {
+	result := []Fixation{}
+	group := []int{}
+	flush := func() {
+		if len(group) > 1 && samples[group[len(group) - 1]].Time - samples[group[0]].Time >= d.MinDuration {
+			result = append(result, newFixation(samples, group))
+		}
+		group = []int{}
+	}
+	for k, i := range segment {
+		if k == 0 {
+			group = append(group, i)
+			continue
+		}
+		if Velocity(samples[segment[k - 1]], samples[i]) < d.MaxVelocity {
+			if len(group) <= 0 {
+				// 速い動きの終わりの点から注視が始まります。
+				group = append(group, segment[k - 1])
+			}
+			group = append(group, i)
+			continue
+		}
+		flush()
+	}
+	flush()
+	return result
+}
@@ -0,0 +1,244 @@
+package gazeevent
+
+import (
+	"math"
+	"../fixation"
+)
+
+// EyeTribe の state の bit
+const (
+	StateTrackingGaze = 0x1
+	StateTrackingEyes = 0x2
+	StateTrackingPresence = 0x4
+	StateTrackingFail = 0x8
+	StateTrackingLost = 0x10
+)
+
+// イベントの種類
+const (
+	TypeFixation = "fixation"
+	TypeSaccade = "saccade"
+	TypeBlink = "blink"
+	TypeTrackLoss = "track_loss"
+)
+
+// イベントの判定に使う一つの視線のサンプル
+type Sample struct {
+	fixation.Sample
+	State int64 // tracker の状態(EyeTribe の state と同じ bitfield。分からなければ 0)
+}
+
+// 視線の一つのイベント
+// 時刻は tracker の時刻(ミリ秒)です。種類によって使わない値は 0 になります。
+type Event struct {
+	Type string `json:"type"`
+	Start float64 `json:"start"`
+	End float64 `json:"end"`
+	Duration float64 `json:"duration"`
+	X float64 `json:"x"` // fixation: 重心, saccade: 始点, blink/track_loss: 見失う前の座標
+	Y float64 `json:"y"`
+	EndX float64 `json:"end_x,omitempty"` // saccade: 終点
+	EndY float64 `json:"end_y,omitempty"`
+	Dispersion float64 `json:"dispersion,omitempty"` // fixation
+	Amplitude float64 `json:"amplitude,omitempty"` // saccade: ピクセル
+	AmplitudeDegree float64 `json:"amplitude_degree,omitempty"` // saccade: 視角(分かる時だけ)
+	PeakVelocity float64 `json:"peak_velocity,omitempty"` // saccade: ピクセル/秒
+	Direction float64 `json:"direction"` // saccade: 右が 0 度、下が 90 度
+	StartIndex int `json:"start_index"`
+	EndIndex int `json:"end_index"`
+}
+
+// イベントの判定の設定
+// config.json の "events" に書きます。
+type Config struct {
+	MinBlinkMsec float64 `json:"min blink msec"` // これより短い途切れは無視します
+	MaxBlinkMsec float64 `json:"max blink msec"` // これより長い途切れは track loss とします
+}
+
+// 既定値
+// 途切れの長さは前後の取れているサンプルの間で測るので、30Hz だと一つ抜けただけで 66ms ほどになります。
+// それを瞬きと数えないように、瞬きとして短すぎない 100ms を下限にします。
+const (
+	DefaultMinBlinkMsec = 100.0
+	DefaultMaxBlinkMsec = 500.0
+)
+
+// 視線のサンプルを fixation, saccade, blink, track loss に分けるもの
+type Classifier struct {
+	Detector fixation.Detector // 注視を見つけるもの
+	MinBlinkMsec float64
+	MaxBlinkMsec float64
+	PixelsPerDegree float64 // 0 でなければ saccade の大きさを視角でも返します
+}
+
+// 設定から Classifier を作ります。config が nil なら既定の設定にします。
+func NewClassifier(detector fixation.Detector, config *Config, pixelsPerDegree float64) *Classifier {
+	c := &Classifier{
+		Detector: detector,
+		MinBlinkMsec: DefaultMinBlinkMsec,
+		MaxBlinkMsec: DefaultMaxBlinkMsec,
+		PixelsPerDegree: pixelsPerDegree,
+	}
+	if config != nil && config.MinBlinkMsec > 0 {
+		c.MinBlinkMsec = config.MinBlinkMsec
+	}
+	if config != nil && config.MaxBlinkMsec > 0 {
+		c.MaxBlinkMsec = config.MaxBlinkMsec
+	}
+	return c
+}
+
+// 視線が取れているかどうかを返します。
+// 座標が (0, 0) 以下のものと、state で視線を追えていないとされているものは取れていないものとします。
+func (s *Sample) HasGaze() bool {
+	if !s.Valid || (s.X <= 0 && s.Y <= 0) {
+		return false
+	}
+	if s.State != 0 && s.State & StateTrackingGaze == 0 {
+		return false
+	}
+	return true
+}
+
+// 時刻の順に並んだサンプルをイベントに分けて、時刻の順に返します。
+func (c *Classifier) Classify(samples []Sample) []Event {
+	fixationSamples := make([]fixation.Sample, len(samples))
+	for i := range samples {
+		fixationSamples[i] = samples[i].Sample
+		fixationSamples[i].Valid = samples[i].HasGaze()
+	}
+	gapList := c.findGaps(samples)
+	fixationList := c.Detector.Detect(fixationSamples)
+
+	result := []Event{}
+	gapIndex := 0
+	for i := range fixationList {
+		f := fixationList[i]
+		// この注視より前の途切れを先に入れます。
+		for gapIndex < len(gapList) && gapList[gapIndex].EndIndex < f.StartIndex {
+			result = append(result, gapList[gapIndex])
+			gapIndex += 1
+		}
+		if i > 0 {
+			prev := fixationList[i - 1]
+			if !c.hasGapBetween(gapList, prev.EndIndex, f.StartIndex) {
+				result = append(result, c.newSaccade(fixationSamples, prev.EndIndex, f.StartIndex))
+			}
+		}
+		result = append(result, Event{
+			Type: TypeFixation,
+			Start: f.Start,
+			End: f.End,
+			Duration: f.Duration,
+			X: f.X,
+			Y: f.Y,
+			Dispersion: f.Dispersion,
+			StartIndex: f.StartIndex,
+			EndIndex: f.EndIndex,
+		})
+	}
+	result = append(result, gapList[gapIndex:]...)
+	return result
+}
+
+// from から to の間に blink か track loss があるかどうかを返します。
+func (c *Classifier) hasGapBetween(gapList []Event, from int, to int) bool {
+	for i := range gapList {
+		if gapList[i].StartIndex > from && gapList[i].EndIndex < to {
+			return true
+		}
+	}
+	return false
+}
+
+// 視線が取れていない所を探して、blink か track loss にします。
+func (c *Classifier) findGaps(samples []Sample) []Event {
+	result := []Event{}
+	i := 0
+	for i < len(samples) {
+		if samples[i].HasGaze() {
+			i += 1
+			continue
+		}
+		start := i
+		for i < len(samples) && !samples[i].HasGaze() {
+			i += 1
+		}
+		end := i - 1
+		// 前後の取れているサンプルの間を、視線が途切れていた時間とします。
+		startTime := samples[start].Time
+		endTime := samples[end].Time
+		event := Event{Type: TypeTrackLoss, StartIndex: start, EndIndex: end}
+		if start > 0 {
+			startTime = samples[start - 1].Time
+			event.X = samples[start - 1].X
+			event.Y = samples[start - 1].Y
+		}
+		if i < len(samples) {
+			endTime = samples[i].Time
+		}
+		event.Start = startTime
+		event.End = endTime
+		event.Duration = endTime - startTime
+		if event.Duration < c.MinBlinkMsec {
+			continue
+		}
+		if event.Duration <= c.MaxBlinkMsec && start > 0 && i < len(samples) && presentDuring(samples[start:end + 1]) {
+			event.Type = TypeBlink
+		}
+		result = append(result, event)
+	}
+	return result
+}
+
+// 視線は取れていなくても、人が画面の前に居続けていたかどうかを返します。
+// state が分からない(0 の)サンプルは居たものとします。
+func presentDuring(samples []Sample) bool {
+	for i := range samples {
+		state := samples[i].State
+		if state == 0 {
+			continue
+		}
+		if state & StateTrackingLost != 0 || state & StateTrackingPresence == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// from 番目から to 番目のサンプルを一つの saccade にします。
+func (c *Classifier) newSaccade(samples []fixation.Sample, from int, to int) Event {
+	start := samples[from]
+	end := samples[to]
+	peakVelocity := 0.0
+	prev := from
+	for i := from + 1; i <= to; i++ {
+		if !samples[i].Valid {
+			continue
+		}
+		peakVelocity = math.Max(peakVelocity, fixation.Velocity(samples[prev], samples[i]))
+		prev = i
+	}
+	dx := end.X - start.X
+	dy := end.Y - start.Y
+	amplitude := math.Hypot(dx, dy)
+	event := Event{
+		Type: TypeSaccade,
+		Start: start.Time,
+		End: end.Time,
+		Duration: end.Time - start.Time,
+		X: start.X,
+		Y: start.Y,
+		EndX: end.X,
+		EndY: end.Y,
+		Amplitude: amplitude,
+		PeakVelocity: peakVelocity,
+		Direction: math.Atan2(dy, dx) * 180.0 / math.Pi,
+		StartIndex: from,
+		EndIndex: to,
+	}
+	if c.PixelsPerDegree > 0 {
+		event.AmplitudeDegree = amplitude / c.PixelsPerDegree
+	}
+	return event
+}
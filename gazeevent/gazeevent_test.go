@@ -0,0 +1,207 @@
+package gazeevent
+
+import (
+	"math"
+	"testing"
+	"../fixation"
+)
+
+// 視線を追えている時と、人は居るが視線が取れていない時と、見失った時の state
+const (
+	stateGaze = StateTrackingGaze | StateTrackingEyes | StateTrackingPresence
+	statePresent = StateTrackingPresence
+	stateLost = StateTrackingLost
+)
+
+// from から to (ミリ秒、to を含みます) まで 33 ミリ秒(30Hz)毎のサンプルを返します。
+// state が stateGaze でなければ視線の取れていないサンプルにします。
+func run(from float64, to float64, x float64, y float64, state int64) []Sample {
+	result := []Sample{}
+	for t := from; t <= to; t += 33 {
+		sample := Sample{Sample: fixation.Sample{Time: t, X: x, Y: y, Valid: true}, State: state}
+		if state != stateGaze {
+			sample.X = 0
+			sample.Y = 0
+			sample.Valid = false
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+func join(list ...[]Sample) []Sample {
+	result := []Sample{}
+	for _, samples := range list {
+		result = append(result, samples...)
+	}
+	return result
+}
+
+func newTestClassifier() *Classifier {
+	detector, _ := fixation.NewDetector(nil)
+	return NewClassifier(detector, nil, 50)
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		samples []Sample
+		want []Event
+	}{
+		{
+			name: "one dropped frame is not a blink",
+			samples: join(run(0, 330, 100, 100, stateGaze), run(363, 363, 0, 0, statePresent), run(396, 726, 100, 100, stateGaze)),
+			want: []Event{
+				{Type: TypeFixation, Start: 0, End: 726, Duration: 726, X: 100, Y: 100},
+			},
+		},
+		{
+			name: "blink between two fixations",
+			samples: join(run(0, 330, 100, 100, stateGaze), run(363, 495, 0, 0, statePresent), run(528, 858, 500, 500, stateGaze)),
+			want: []Event{
+				{Type: TypeFixation, Start: 0, End: 330, Duration: 330, X: 100, Y: 100},
+				{Type: TypeBlink, Start: 330, End: 528, Duration: 198, X: 100, Y: 100},
+				{Type: TypeFixation, Start: 528, End: 858, Duration: 330, X: 500, Y: 500},
+			},
+		},
+		{
+			name: "short gap while the tracker lost the person",
+			samples: join(run(0, 330, 100, 100, stateGaze), run(363, 495, 0, 0, stateLost), run(528, 858, 100, 100, stateGaze)),
+			want: []Event{
+				{Type: TypeFixation, Start: 0, End: 330, Duration: 330, X: 100, Y: 100},
+				{Type: TypeTrackLoss, Start: 330, End: 528, Duration: 198, X: 100, Y: 100},
+				{Type: TypeFixation, Start: 528, End: 858, Duration: 330, X: 100, Y: 100},
+			},
+		},
+		{
+			name: "long gap is a track loss",
+			samples: join(run(0, 330, 100, 100, stateGaze), run(363, 990, 0, 0, statePresent), run(1023, 1353, 100, 100, stateGaze)),
+			want: []Event{
+				{Type: TypeFixation, Start: 0, End: 330, Duration: 330, X: 100, Y: 100},
+				{Type: TypeTrackLoss, Start: 330, End: 1023, Duration: 693, X: 100, Y: 100},
+				{Type: TypeFixation, Start: 1023, End: 1353, Duration: 330, X: 100, Y: 100},
+			},
+		},
+		{
+			name: "gap at the end is a track loss",
+			samples: join(run(0, 330, 100, 100, stateGaze), run(363, 495, 0, 0, statePresent)),
+			want: []Event{
+				{Type: TypeFixation, Start: 0, End: 330, Duration: 330, X: 100, Y: 100},
+				{Type: TypeTrackLoss, Start: 330, End: 495, Duration: 165, X: 100, Y: 100},
+			},
+		},
+		{
+			name: "saccade between two fixations",
+			samples: join(run(0, 330, 100, 100, stateGaze), run(363, 693, 400, 500, stateGaze)),
+			want: []Event{
+				{Type: TypeFixation, Start: 0, End: 330, Duration: 330, X: 100, Y: 100},
+				{Type: TypeSaccade, Start: 330, End: 363, Duration: 33, X: 100, Y: 100, EndX: 400, EndY: 500},
+				{Type: TypeFixation, Start: 363, End: 693, Duration: 330, X: 400, Y: 500},
+			},
+		},
+	}
+	for _, test := range tests {
+		got := newTestClassifier().Classify(test.samples)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %d events, want %d: %+v", test.name, len(got), len(test.want), got)
+			continue
+		}
+		for i := range got {
+			g := got[i]
+			w := test.want[i]
+			if g.Type != w.Type || g.Start != w.Start || g.End != w.End || g.Duration != w.Duration ||
+				g.X != w.X || g.Y != w.Y || g.EndX != w.EndX || g.EndY != w.EndY {
+				t.Errorf("%s: event %d = %+v, want %+v", test.name, i, g, w)
+			}
+		}
+	}
+}
+
+func TestSaccade(t *testing.T) {
+	samples := join(run(0, 330, 100, 100, stateGaze), run(363, 693, 400, 500, stateGaze))
+	events := newTestClassifier().Classify(samples)
+	if len(events) != 3 || events[1].Type != TypeSaccade {
+		t.Fatalf("want fixation, saccade, fixation: %+v", events)
+	}
+	saccade := events[1]
+	if saccade.Amplitude != 500 {
+		t.Errorf("amplitude = %f, want 500", saccade.Amplitude)
+	}
+	if saccade.AmplitudeDegree != 10 {
+		t.Errorf("amplitude degree = %f, want 10", saccade.AmplitudeDegree)
+	}
+	if math.Abs(saccade.PeakVelocity - 500 / 33.0 * 1000) > 1e-6 {
+		t.Errorf("peak velocity = %f, want %f", saccade.PeakVelocity, 500 / 33.0 * 1000)
+	}
+	if math.Abs(saccade.Direction - math.Atan2(400, 300) * 180 / math.Pi) > 1e-9 {
+		t.Errorf("direction = %f", saccade.Direction)
+	}
+}
+
+func TestHasGaze(t *testing.T) {
+	tests := []struct {
+		name string
+		sample Sample
+		want bool
+	}{
+		{"valid", Sample{Sample: fixation.Sample{X: 10, Y: 10, Valid: true}, State: stateGaze}, true},
+		{"unknown state", Sample{Sample: fixation.Sample{X: 10, Y: 10, Valid: true}}, true},
+		{"not valid", Sample{Sample: fixation.Sample{X: 10, Y: 10, Valid: false}}, false},
+		{"zero point", Sample{Sample: fixation.Sample{X: 0, Y: 0, Valid: true}}, false},
+		{"eyes without gaze", Sample{Sample: fixation.Sample{X: 10, Y: 10, Valid: true}, State: StateTrackingEyes | StateTrackingPresence}, false},
+	}
+	for _, test := range tests {
+		got := test.sample.HasGaze()
+		if got != test.want {
+			t.Errorf("%s: HasGaze() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewClassifier(t *testing.T) {
+	tests := []struct {
+		config *Config
+		minBlink float64
+		maxBlink float64
+	}{
+		{nil, DefaultMinBlinkMsec, DefaultMaxBlinkMsec},
+		{&Config{}, DefaultMinBlinkMsec, DefaultMaxBlinkMsec},
+		{&Config{MinBlinkMsec: 80, MaxBlinkMsec: 400}, 80, 400},
+	}
+	for _, test := range tests {
+		c := NewClassifier(nil, test.config, 0)
+		if c.MinBlinkMsec != test.minBlink || c.MaxBlinkMsec != test.maxBlink {
+			t.Errorf("NewClassifier(%+v) = %f, %f, want %f, %f", test.config, c.MinBlinkMsec, c.MaxBlinkMsec, test.minBlink, test.maxBlink)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	events := []Event{
+		{Type: TypeFixation, Duration: 200},
+		{Type: TypeSaccade, Amplitude: 100},
+		{Type: TypeFixation, Duration: 400},
+		{Type: TypeBlink, Duration: 150},
+		{Type: TypeSaccade, Amplitude: 300},
+		{Type: TypeBlink, Duration: 150},
+		{Type: TypeTrackLoss, Duration: 700},
+	}
+	got := Summarize(events, 30000)
+	want := Summary{
+		Duration: 30000,
+		FixationCount: 2,
+		MeanFixationDuration: 300,
+		SaccadeCount: 2,
+		MeanSaccadeAmplitude: 200,
+		BlinkCount: 2,
+		BlinkRate: 4,
+		TrackLossCount: 1,
+		TrackLossDuration: 700,
+	}
+	if got != want {
+		t.Errorf("Summarize() = %+v, want %+v", got, want)
+	}
+	if empty := Summarize(nil, 0); empty != (Summary{}) {
+		t.Errorf("Summarize(nil, 0) = %+v", empty)
+	}
+}
@@ -0,0 +1,56 @@
+package gazeevent
+
+// イベントのまとめ
+// 瞬きの回数や saccade の回数は作業負荷の目安に使います。
+type Summary struct {
+	Duration float64 `json:"duration"` // 対象の時間(ミリ秒)
+	FixationCount int `json:"fixation_count"`
+	MeanFixationDuration float64 `json:"mean_fixation_duration"`
+	SaccadeCount int `json:"saccade_count"`
+	MeanSaccadeAmplitude float64 `json:"mean_saccade_amplitude"` // ピクセル
+	BlinkCount int `json:"blink_count"`
+	BlinkRate float64 `json:"blink_rate"` // 一分あたりの瞬きの回数
+	TrackLossCount int `json:"track_loss_count"`
+	TrackLossDuration float64 `json:"track_loss_duration"`
+}
+
+// duration ミリ秒の間のイベントをまとめます。
+func Summarize(events []Event, duration float64) Summary {
+	result := Summary{Duration: duration}
+	totalFixationDuration := 0.0
+	totalAmplitude := 0.0
+	for i := range events {
+		e := events[i]
+		switch e.Type {
+		case TypeFixation:
+			result.FixationCount += 1
+			totalFixationDuration += e.Duration
+		case TypeSaccade:
+			result.SaccadeCount += 1
+			totalAmplitude += e.Amplitude
+		case TypeBlink:
+			result.BlinkCount += 1
+		case TypeTrackLoss:
+			result.TrackLossCount += 1
+			result.TrackLossDuration += e.Duration
+		}
+	}
+	if result.FixationCount > 0 {
+		result.MeanFixationDuration = totalFixationDuration / float64(result.FixationCount)
+	}
+	if result.SaccadeCount > 0 {
+		result.MeanSaccadeAmplitude = totalAmplitude / float64(result.SaccadeCount)
+	}
+	if duration > 0 {
+		result.BlinkRate = float64(result.BlinkCount) / (duration / 60000.0)
+	}
+	return result
+}
+
+// サンプルの最初から最後までの時間(ミリ秒)を返します。
+func SamplesDuration(samples []Sample) float64 {
+	if len(samples) <= 0 {
+		return 0
+	}
+	return samples[len(samples) - 1].Time - samples[0].Time
+}
@@ -0,0 +1,120 @@
+package heatmap
+
+import (
+	"math"
+)
+
+// 視線の濃さを溜める格子
+// 速くするために sigma の 1/3 くらいの大きさの升目で溜めて、描く時に補間します。
+type Accumulator struct {
+	Width int // 画像の大きさ(ピクセル)
+	Height int
+	Sigma float64 // Gaussian kernel の標準偏差(ピクセル)
+	cell float64 // 升目の大きさ(ピクセル)
+	cols int
+	rows int
+	grid []float64
+}
+
+// width x height ピクセルの画像のための Accumulator を作ります。
+func NewAccumulator(width int, height int, sigma float64) *Accumulator {
+	if sigma <= 0 {
+		sigma = DefaultSigma
+	}
+	cell := math.Max(1.0, math.Floor(sigma / 3.0))
+	cols := int(math.Ceil(float64(width) / cell))
+	rows := int(math.Ceil(float64(height) / cell))
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return &Accumulator{
+		Width: width,
+		Height: height,
+		Sigma: sigma,
+		cell: cell,
+		cols: cols,
+		rows: rows,
+		grid: make([]float64, cols * rows),
+	}
+}
+
+// (x, y) に weight の重みの Gaussian kernel を加えます。kernel の中心の高さが weight になります。
+func (a *Accumulator) Add(x float64, y float64, weight float64) {
+	if weight <= 0 || math.IsNaN(x) || math.IsNaN(y) {
+		return
+	}
+	sigma := a.Sigma / a.cell
+	radius := int(math.Ceil(3.0 * sigma))
+	// 升目の中心が (i + 0.5) * cell なので、升目の座標にします。
+	fx := x / a.cell - 0.5
+	fy := y / a.cell - 0.5
+	cx := int(math.Floor(fx + 0.5))
+	cy := int(math.Floor(fy + 0.5))
+	if cx + radius < 0 || cy + radius < 0 || cx - radius >= a.cols || cy - radius >= a.rows {
+		return
+	}
+	wx := make([]float64, 2 * radius + 1)
+	for i := range wx {
+		d := float64(cx - radius + i) - fx
+		wx[i] = math.Exp(-d * d / (2.0 * sigma * sigma))
+	}
+	for j := -radius; j <= radius; j++ {
+		row := cy + j
+		if row < 0 || row >= a.rows {
+			continue
+		}
+		d := float64(row) - fy
+		wy := weight * math.Exp(-d * d / (2.0 * sigma * sigma))
+		for i := -radius; i <= radius; i++ {
+			col := cx + i
+			if col < 0 || col >= a.cols {
+				continue
+			}
+			a.grid[row * a.cols + col] += wy * wx[i + radius]
+		}
+	}
+}
+
+// 一番濃い所の値を返します。
+func (a *Accumulator) Max() float64 {
+	max := 0.0
+	for _, v := range a.grid {
+		max = math.Max(max, v)
+	}
+	return max
+}
+
+// (x, y) の濃さを返します。
+func (a *Accumulator) At(x float64, y float64) float64 {
+	fx := x / a.cell - 0.5
+	fy := y / a.cell - 0.5
+	x0 := int(math.Floor(fx))
+	y0 := int(math.Floor(fy))
+	tx := fx - float64(x0)
+	ty := fy - float64(y0)
+	v00 := a.cellValue(x0, y0)
+	v10 := a.cellValue(x0 + 1, y0)
+	v01 := a.cellValue(x0, y0 + 1)
+	v11 := a.cellValue(x0 + 1, y0 + 1)
+	return (v00 * (1 - tx) + v10 * tx) * (1 - ty) + (v01 * (1 - tx) + v11 * tx) * ty
+}
+
+// 升目の値を返します。外側は一番近い升目の値を使います。
+func (a *Accumulator) cellValue(col int, row int) float64 {
+	if col < 0 {
+		col = 0
+	}
+	if col >= a.cols {
+		col = a.cols - 1
+	}
+	if row < 0 {
+		row = 0
+	}
+	if row >= a.rows {
+		row = a.rows - 1
+	}
+	return a.grid[row * a.cols + col]
+}
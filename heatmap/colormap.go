@@ -0,0 +1,56 @@
+package heatmap
+
+import (
+	"image/color"
+	"math"
+)
+
+// 色の目盛り。0 から 1 を等間隔に分けた所の色です。
+var colorMaps = map[string][]color.NRGBA{
+	"jet": {
+		{0, 0, 127, 255}, {0, 0, 255, 255}, {0, 127, 255, 255}, {0, 255, 255, 255},
+		{127, 255, 127, 255}, {255, 255, 0, 255}, {255, 127, 0, 255}, {255, 0, 0, 255}, {127, 0, 0, 255},
+	},
+	"hot": {
+		{0, 0, 0, 255}, {255, 0, 0, 255}, {255, 255, 0, 255}, {255, 255, 255, 255},
+	},
+	"viridis": {
+		{68, 1, 84, 255}, {59, 82, 139, 255}, {33, 145, 140, 255}, {94, 201, 98, 255}, {253, 231, 37, 255},
+	},
+	"gray": {
+		{0, 0, 0, 255}, {255, 255, 255, 255},
+	},
+}
+
+// 使える色の目盛りの名前を返します。
+func ColorMapNames() []string {
+	return []string{"jet", "hot", "viridis", "gray"}
+}
+
+// 名前が name の色の目盛りでの、v (0 から 1) の色を返します。無い名前なら false を返します。
+func ColorAt(name string, v float64) (color.NRGBA, bool) {
+	stops, ok := colorMaps[name]
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	return interpolate(stops, v), true
+}
+
+// stops の間を線形に補間して、v (0 から 1) の色を返します。
+func interpolate(stops []color.NRGBA, v float64) color.NRGBA {
+	if v <= 0 {
+		return stops[0]
+	}
+	if v >= 1 {
+		return stops[len(stops) - 1]
+	}
+	f := v * float64(len(stops) - 1)
+	i := int(math.Floor(f))
+	t := f - float64(i)
+	a := stops[i]
+	b := stops[i + 1]
+	mix := func(x uint8, y uint8) uint8 {
+		return uint8(math.Floor(float64(x) * (1 - t) + float64(y) * t + 0.5))
+	}
+	return color.NRGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
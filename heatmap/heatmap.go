@@ -0,0 +1,268 @@
+package heatmap
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// 重みの付け方
+const (
+	WeightTime = "time" // サンプル毎に、次のサンプルまでの時間で重みを付けます
+	WeightSample = "sample" // サンプルの数で数えます
+	WeightFixation = "fixation" // 注視の重心に、注視の長さで重みを付けます
+)
+
+// 色の付け方の基準
+const (
+	ScaleRelative = "relative" // 一番濃い所を一番上の色にします
+	ScaleAbsolute = "absolute" // Max の濃さを一番上の色にします(画像同士を比べられます)
+)
+
+// 既定値
+const (
+	DefaultSigma = 30.0 // ピクセル
+	DefaultSigmaDegree = 1.0
+	DefaultMaxMsec = 1000.0 // absolute の時の Max (time, fixation)
+	DefaultMaxSamples = 30.0 // absolute の時の Max (sample)
+	DefaultColorMap = "jet"
+	DefaultAlpha = 0.8
+	DefaultThreshold = 0.05
+	DefaultMaxIntervalMsec = 100.0 // これより間の空いたサンプルの時間は数えません
+)
+
+// heatmap の描き方の設定
+// config.json の "heatmap" に書きます。
+type Config struct {
+	Sigma float64 `json:"sigma"` // Gaussian kernel の標準偏差
+	Unit string `json:"unit"` // Sigma の単位。"pixel"(default) か "degree"(視角)
+	Weight string `json:"weight"` // "time"(default), "sample" か "fixation"
+	Scale string `json:"scale"` // "relative"(default) か "absolute"
+	Max float64 `json:"max"` // absolute: 一番上の色にする濃さ(time, fixation はミリ秒, sample は数)
+	ColorMap string `json:"color map"` // "jet"(default), "hot", "viridis" か "gray"
+	Alpha float64 `json:"alpha"` // 一番濃い所の不透明度(0 から 1)
+	Threshold float64 `json:"threshold"` // 一番上の色に対してこれより薄い所は描きません(0 から 1)
+}
+
+// 一つの視線のサンプルか注視
+type Point struct {
+	X float64 // ピクセル
+	Y float64
+	Time float64 // ミリ秒
+	Duration float64 // 注視の長さ(ミリ秒, 注視の時だけ使います)
+	Valid bool
+}
+
+// 設定に従って heatmap を描くもの
+type Renderer struct {
+	Sigma float64 // ピクセル
+	Weight string
+	Scale string
+	Max float64
+	ColorMap string
+	Alpha float64
+	Threshold float64
+	MaxIntervalMsec float64
+	stops []color.NRGBA
+}
+
+// 設定に誤りが無いかを確認します。値が 0 の項目は既定値を使います。
+func (c *Config) Validate() error {
+	switch c.Unit {
+	case "", "pixel", "degree":
+	default:
+		return errors.New(fmt.Sprintf("unknown heatmap unit: %s", c.Unit))
+	}
+	switch c.Weight {
+	case "", WeightTime, WeightSample, WeightFixation:
+	default:
+		return errors.New(fmt.Sprintf("unknown heatmap weight: %s", c.Weight))
+	}
+	switch c.Scale {
+	case "", ScaleRelative, ScaleAbsolute:
+	default:
+		return errors.New(fmt.Sprintf("unknown heatmap scale: %s", c.Scale))
+	}
+	if _, ok := colorMaps[c.ColorMap]; c.ColorMap != "" && !ok {
+		return errors.New(fmt.Sprintf("unknown heatmap color map: %s", c.ColorMap))
+	}
+	if c.Sigma < 0 || c.Max < 0 {
+		return errors.New("heatmap sigma and max must not be negative")
+	}
+	if c.Alpha < 0 || c.Alpha > 1 || c.Threshold < 0 || c.Threshold >= 1 {
+		return errors.New("heatmap alpha must be in 0 to 1 and threshold in 0 to below 1")
+	}
+	return nil
+}
+
+// 設定から Renderer を作ります。config が nil なら既定の設定にします。
+// pixelsPerDegree は Sigma が視角で書かれている時に使います(分からなければ 0)。
+func NewRenderer(config *Config, pixelsPerDegree float64) (*Renderer, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	r := &Renderer{
+		Sigma: config.Sigma,
+		Weight: config.Weight,
+		Scale: config.Scale,
+		Max: config.Max,
+		ColorMap: config.ColorMap,
+		Alpha: config.Alpha,
+		Threshold: config.Threshold,
+		MaxIntervalMsec: DefaultMaxIntervalMsec,
+	}
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if r.Sigma <= 0 {
+		r.Sigma = DefaultSigma
+	}
+	if config.Unit == "degree" {
+		if pixelsPerDegree <= 0 {
+			return nil, errors.New("heatmap degree unit needs the screen size and viewing distance of the fixation config")
+		}
+		if config.Sigma <= 0 {
+			r.Sigma = DefaultSigmaDegree
+		}
+		r.Sigma *= pixelsPerDegree
+	}
+	if r.Weight == "" {
+		r.Weight = WeightTime
+	}
+	if r.Scale == "" {
+		r.Scale = ScaleRelative
+	}
+	if r.Max <= 0 {
+		r.Max = DefaultMaxMsec
+		if r.Weight == WeightSample {
+			r.Max = DefaultMaxSamples
+		}
+	}
+	if r.ColorMap == "" {
+		r.ColorMap = DefaultColorMap
+	}
+	r.stops = colorMaps[r.ColorMap]
+	if r.Alpha <= 0 {
+		r.Alpha = DefaultAlpha
+	}
+	if r.Threshold <= 0 {
+		r.Threshold = DefaultThreshold
+	}
+	return r, nil
+}
+
+// 注視を使って描くかどうかを返します。
+func (r *Renderer) UsesFixations() bool {
+	return r.Weight == WeightFixation
+}
+
+// width x height の画像のための Accumulator を作ります。
+func (r *Renderer) NewAccumulator(width int, height int) *Accumulator {
+	return NewAccumulator(width, height, r.Sigma)
+}
+
+// 点毎の重みを返します。UsesFixations() なら点は注視で、その長さを重みにします。
+// そうでなければ点は時刻の順に並んだサンプルで、Weight に従います。
+func (r *Renderer) Weights(points []Point) []float64 {
+	weights := make([]float64, len(points))
+	for i := range points {
+		p := points[i]
+		if !p.Valid {
+			continue
+		}
+		switch r.Weight {
+		case WeightFixation:
+			weights[i] = p.Duration
+		case WeightSample:
+			weights[i] = 1.0
+		default:
+			for j := i + 1; j < len(points); j++ {
+				if points[j].Valid {
+					weights[i] = points[j].Time - p.Time
+					break
+				}
+			}
+			if weights[i] > r.MaxIntervalMsec || weights[i] < 0 {
+				weights[i] = 0
+			}
+		}
+	}
+	return weights
+}
+
+// 点の重みの合計を返します。
+func (r *Renderer) TotalWeight(points []Point) float64 {
+	total := 0.0
+	for _, w := range r.Weights(points) {
+		total += w
+	}
+	return total
+}
+
+// 点を設定の重みで加えます。
+func (r *Renderer) Add(a *Accumulator, points []Point) {
+	r.AddScaled(a, points, 1.0)
+}
+
+// 点を設定の重みの scale 倍で加えます(参加者毎に揃える時に使います)。
+func (r *Renderer) AddScaled(a *Accumulator, points []Point, scale float64) {
+	weights := r.Weights(points)
+	for i := range points {
+		if points[i].Valid {
+			a.Add(points[i].X, points[i].Y, weights[i] * scale)
+		}
+	}
+}
+
+// 0 から 1 の値の色を返します。
+func (r *Renderer) Color(v float64) color.NRGBA {
+	return interpolate(r.stops, v)
+}
+
+// 溜めた濃さを色にした画像を作ります。
+func (r *Renderer) Render(a *Accumulator) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, a.Width, a.Height))
+	norm := r.Max
+	if r.Scale == ScaleRelative {
+		norm = a.Max()
+	}
+	if norm <= 0 {
+		return img
+	}
+	for y := 0; y < a.Height; y++ {
+		for x := 0; x < a.Width; x++ {
+			v := a.At(float64(x) + 0.5, float64(y) + 0.5) / norm
+			if v < r.Threshold {
+				continue
+			}
+			v = math.Min(v, 1.0)
+			c := r.Color(v)
+			// 薄い所は透けるようにして、半分より濃い所は Alpha にします。
+			c.A = uint8(255.0 * r.Alpha * math.Min(1.0, 2.0 * v))
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// 色の目盛りの画像(左が薄く、右が濃い)を作ります。
+func (r *Renderer) Legend(width int, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		v := 0.0
+		if width > 1 {
+			v = float64(x) / float64(width - 1)
+		}
+		c := r.Color(v)
+		if v < r.Threshold {
+			c = color.NRGBA{255, 255, 255, 255}
+		}
+		for y := 0; y < height; y++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
@@ -0,0 +1,220 @@
+package heatmap
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func near(a float64, b float64) bool {
+	return math.Abs(a - b) < 1e-9
+}
+
+// 点を一つだけ加えると、その点の濃さは重みになり、sigma 離れた所では exp(-1/2) 倍になります。
+func TestAccumulatorSinglePoint(t *testing.T) {
+	tests := []struct {
+		name string
+		sigma float64
+		x float64 // 升目の中心に来る点
+		y float64
+	}{
+		{"one pixel cells", 3, 10.5, 10.5},
+		{"ten pixel cells", 30, 55, 55},
+	}
+	for _, test := range tests {
+		a := NewAccumulator(200, 200, test.sigma)
+		a.Add(test.x, test.y, 2)
+		if got := a.Max(); !near(got, 2) {
+			t.Errorf("%s: Max() = %v, want 2", test.name, got)
+		}
+		if got := a.At(test.x, test.y); !near(got, 2) {
+			t.Errorf("%s: At(center) = %v, want 2", test.name, got)
+		}
+		want := 2 * math.Exp(-0.5)
+		if got := a.At(test.x + test.sigma, test.y); !near(got, want) {
+			t.Errorf("%s: At(center + sigma, center) = %v, want %v", test.name, got, want)
+		}
+		if got := a.At(test.x, test.y + test.sigma); !near(got, want) {
+			t.Errorf("%s: At(center, center + sigma) = %v, want %v", test.name, got, want)
+		}
+		if got := a.At(test.x + 4 * test.sigma, test.y); got != 0 {
+			t.Errorf("%s: At(center + 4 sigma, center) = %v, want 0", test.name, got)
+		}
+	}
+}
+
+// 重みが無い点や、画像から遠く離れた点は何も加えません。
+func TestAccumulatorIgnoredPoints(t *testing.T) {
+	a := NewAccumulator(100, 100, 3)
+	a.Add(50, 50, 0)
+	a.Add(50, 50, -1)
+	a.Add(math.NaN(), 50, 1)
+	a.Add(-100, 50, 1)
+	a.Add(50, 1000, 1)
+	if got := a.Max(); got != 0 {
+		t.Errorf("Max() = %v, want 0", got)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	points := []Point{
+		{X: 10, Y: 10, Time: 0, Duration: 200, Valid: true},
+		{X: 10, Y: 10, Time: 30, Valid: false},
+		{X: 10, Y: 10, Time: 60, Duration: 300, Valid: true},
+		{X: 10, Y: 10, Time: 100, Valid: true},
+		{X: 10, Y: 10, Time: 400, Valid: true},
+	}
+	tests := []struct {
+		weight string
+		want []float64
+	}{
+		// 無効なサンプルは飛ばして次の有効なサンプルまでの時間を数え、MaxIntervalMsec より長い間と最後のサンプルは 0 にします。
+		{WeightTime, []float64{60, 0, 40, 0, 0}},
+		{WeightSample, []float64{1, 0, 1, 1, 1}},
+		{WeightFixation, []float64{200, 0, 300, 0, 0}},
+	}
+	for _, test := range tests {
+		r, err := NewRenderer(&Config{Weight: test.weight}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := r.Weights(points)
+		for i := range test.want {
+			if !near(got[i], test.want[i]) {
+				t.Errorf("%s: Weights() = %v, want %v", test.weight, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestWeightsMaxInterval(t *testing.T) {
+	points := []Point{
+		{Time: 0, Valid: true},
+		{Time: DefaultMaxIntervalMsec, Valid: true},
+		{Time: 2 * DefaultMaxIntervalMsec + 1, Valid: true},
+	}
+	r, err := NewRenderer(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := r.Weights(points)
+	if got[0] != DefaultMaxIntervalMsec || got[1] != 0 || got[2] != 0 {
+		t.Errorf("Weights() = %v, want [%v 0 0]", got, DefaultMaxIntervalMsec)
+	}
+	if total := r.TotalWeight(points); total != DefaultMaxIntervalMsec {
+		t.Errorf("TotalWeight() = %v, want %v", total, DefaultMaxIntervalMsec)
+	}
+}
+
+// relative なら一番濃い所が色の目盛りの一番上になり、absolute なら Max に対する割合の色になります。
+func TestRenderScale(t *testing.T) {
+	jet := colorMaps["jet"]
+	tests := []struct {
+		scale string
+		want color.NRGBA // 点のあるピクセルの色
+	}{
+		{ScaleRelative, color.NRGBA{jet[8].R, jet[8].G, jet[8].B, 204}},
+		{ScaleAbsolute, color.NRGBA{jet[4].R, jet[4].G, jet[4].B, 204}},
+	}
+	for _, test := range tests {
+		r, err := NewRenderer(&Config{Sigma: 3, Scale: test.scale, Max: 100}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := r.NewAccumulator(40, 30)
+		a.Add(10.5, 10.5, 50)
+		img := r.Render(a)
+		if got := img.Bounds().Size(); got.X != 40 || got.Y != 30 {
+			t.Errorf("%s: image size = %v, want 40x30", test.scale, got)
+		}
+		if got := img.NRGBAAt(10, 10); got != test.want {
+			t.Errorf("%s: color at the point = %v, want %v", test.scale, got, test.want)
+		}
+		// Threshold より薄い所は透明のままです。
+		if got := img.NRGBAAt(35, 25); got.A != 0 {
+			t.Errorf("%s: color far from the point = %v, want transparent", test.scale, got)
+		}
+	}
+}
+
+func TestRenderEmpty(t *testing.T) {
+	r, err := NewRenderer(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := r.Render(r.NewAccumulator(10, 10))
+	for _, v := range img.Pix {
+		if v != 0 {
+			t.Fatalf("empty heatmap is not transparent")
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		config Config
+		ok bool
+	}{
+		{"default", Config{}, true},
+		{"all set", Config{Sigma: 2, Unit: "degree", Weight: WeightFixation, Scale: ScaleAbsolute, Max: 500, ColorMap: "viridis", Alpha: 1, Threshold: 0.1}, true},
+		{"unknown unit", Config{Unit: "inch"}, false},
+		{"unknown weight", Config{Weight: "pupil"}, false},
+		{"unknown scale", Config{Scale: "log"}, false},
+		{"unknown color map", Config{ColorMap: "rainbow"}, false},
+		{"negative sigma", Config{Sigma: -1}, false},
+		{"negative max", Config{Max: -1}, false},
+		{"alpha over 1", Config{Alpha: 1.5}, false},
+		{"negative alpha", Config{Alpha: -0.1}, false},
+		{"threshold 1", Config{Threshold: 1}, false},
+	}
+	for _, test := range tests {
+		err := test.config.Validate()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Validate() = %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+}
+
+func TestNewRendererDegree(t *testing.T) {
+	_, err := NewRenderer(&Config{Unit: "degree"}, 0)
+	if err == nil {
+		t.Errorf("NewRenderer() with degree unit and no pixels per degree should fail")
+	}
+	r, err := NewRenderer(&Config{Unit: "degree"}, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Sigma != DefaultSigmaDegree * 40 {
+		t.Errorf("Sigma = %v, want %v", r.Sigma, DefaultSigmaDegree * 40)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	gray := colorMaps["gray"]
+	jet := colorMaps["jet"]
+	tests := []struct {
+		name string
+		stops []color.NRGBA
+		v float64
+		want color.NRGBA
+	}{
+		{"below 0", gray, -0.5, gray[0]},
+		{"0", gray, 0, gray[0]},
+		{"middle", gray, 0.5, color.NRGBA{128, 128, 128, 255}},
+		{"1", gray, 1, gray[1]},
+		{"above 1", gray, 2, gray[1]},
+		{"jet 0", jet, 0, jet[0]},
+		{"jet stop", jet, 0.25, jet[2]},
+		{"jet 1", jet, 1, jet[8]},
+	}
+	for _, test := range tests {
+		if got := interpolate(test.stops, test.v); got != test.want {
+			t.Errorf("%s: interpolate(%v) = %v, want %v", test.name, test.v, got, test.want)
+		}
+	}
+	if _, ok := ColorAt("rainbow", 0.5); ok {
+		t.Errorf("ColorAt() with an unknown color map should fail")
+	}
+}
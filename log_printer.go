@@ -1,18 +1,34 @@
 package main
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 	"image"
 	"image/png"
 	"image/draw"
-	"bufio"
 	"io"
-	"strings"
 	"flag"
 	"io/ioutil"
+	"strings"
+	"html"
+	"math"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"./clocksync"
+	"./fixation"
+	"./gazeevent"
+	"./eventlog"
+	"./heatmap"
+	"./scanpath"
+	"./replay"
+	"./aoi"
+	"./viewport"
 )
 
 // こちらからのリクエスト型(汎用)
@@ -71,27 +87,88 @@ type Frame struct {
 	LeftEye *EyeData `json:"lefteye"`
 	RightEye *EyeData `json:"righteye"`
 	GoTime time.Time `json:"GoTime"`
+	ServerTime float64 `json:"-"` // tracker の時刻をサーバの時計(Unix 時間のミリ秒)に直したもの
+	Page *PagePosition `json:"-"` // ページ(document)上の位置(ページが表示の様子を報告していなければ nil)
 }
 
-// 一つだけのフレームのメッセージ
-type OneFrameMessage struct {
-	Category string `json:"category"`
-	Request string `json:"request"`
-	StatusCode int `json:"statuscode"`
-	Values map[string]*Frame `json:"values"`
+// 視線のページ上の位置
+type PagePosition struct {
+	X float64
+	Y float64
+	Inside bool // ページの見えている所(viewport)の中か
+}
+
+// tracker との接続が切れた・繋がり直した事を示す log
+type ConnectionEvent struct {
+	eventlog.Connection
+	GoTime time.Time
+}
+
+// tracker のキャリブレーションの結果を示す log (点毎の値は読み飛ばします)
+type CalibrationResult struct {
+	Result bool `json:"result"`
+	Deg float64 `json:"deg"`
+	DegL float64 `json:"degl"`
+	DegR float64 `json:"degr"`
+}
+
+type CalibrationLog struct {
+	Result *CalibrationResult
+	GoTime time.Time
+}
+
+// 視線に応じた規則が発火した事を示す log
+type RuleEvent struct {
+	Rule string `json:"rule"`
+	Type string `json:"type"`
+	AOI string `json:"aoi"`
+	Url string `json:"url"`
+	Time time.Time `json:"time"`
+}
+
+// セッションの始めに書き出される log (tracker や AOI の設定は読み飛ばします)
+type SessionInfo struct {
+	Experiment string `json:"experiment"`
+	Participant string `json:"participant"`
+	Id string `json:"session"`
+}
+
+// セッションの中の一つの試行の始まりと終わりを示す log
+type Trial struct {
+	Name string `json:"name"`
+	Index int `json:"index"`
+	Condition string `json:"condition"`
+	StartTime time.Time `json:"start time"`
+	EndTime *time.Time `json:"end time"`
 }
 
-type RequestPath struct {
-	RequestPath string `json:"request path"`
-	UnixTime int64 `json:"unix time"`
+// ページから打たれた目印の log
+type MarkerLog struct {
+	eventlog.Marker
+	GoTime time.Time
 }
 
 // 一つのWebPage用のlog
+// -segmentMarkers で指定された目印が打たれた時も、そこで区切ります。
 type OneWebPageTrackLog struct {
 	FrameArray []*Frame
 	Url string
-	UnixTime int64 // log の取られたUnix時間
+	StartTime float64 // この区切りが始まった時刻(サーバの時計の Unix 時間のミリ秒)
 	ImageFileNameList []string // 生成された画像ファイルの名前リスト
+	GapList []ConnectionEvent // tracker との接続が切れていた記録
+	FixationList []fixation.Fixation // 見つかった注視
+	EventList []gazeevent.Event // 見つかった fixation, saccade, blink, track loss
+	EventSummary gazeevent.Summary // EventList のまとめ
+	CalibrationList []CalibrationLog // その間に行われたキャリブレーションの記録
+	RuleEventList []RuleEvent // その間に発火した規則の記録
+	Session *SessionInfo // どの参加者のセッションか(分からなければ nil)
+	TrialList []Trial // その間に始まった(か終わった)試行
+	MarkerList []MarkerLog // その間に打たれた目印
+	ClockList []eventlog.Clock // その間に報告されたブラウザの時計のずれ
+	ViewportList []viewport.State // その間に報告されたページの表示の様子
+	TrackerClock *clocksync.Estimate // 区切りの終わりでの tracker の時計のずれ
+	SegmentMarker string // この区切りを始めた目印の名前(ページの移動で始まったなら "")
+	ReplayFileName string // 生成された動画のファイル(png ならディレクトリ)の名前
 }
 
 func LoadPngImage(fileName string) (*image.Image, error) {
@@ -107,43 +184,267 @@ func LoadPngImage(fileName string) (*image.Image, error) {
 	return &img, nil
 }
 
-func CreateHeatMapImage(heatMapImage *image.Image, log OneWebPageTrackLog, ScreenWidth int, ScreenHeight int, maxSecond int64, startUnixTime int64) (*image.RGBA, error) {
-	img := image.NewRGBA(image.Rect(0, 0, int(ScreenWidth), int(ScreenHeight)) )
-	draw.Draw(img, img.Bounds(), image.Transparent, image.ZP, draw.Src)
+// 画像を PNG で書き出します。
+func SavePngImage(fileName string, img image.Image) error {
+	imgFile, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(imgFile, img)
+	if err != nil {
+		imgFile.Close()
+		return err
+	}
+	return imgFile.Close()
+}
+
+// time.Time をサーバの時計の Unix 時間のミリ秒にします。
+func UnixMillisecond(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
+// startTime (Unix 時間のミリ秒) から maxSecond 秒までのフレームで heatmap を作ります。maxSecond が 0 以下なら全てのフレームを使います。
+// ページが表示の様子を報告していれば、ページ全体の大きさの画像にページ上の位置で描きます。
+// renderer が注視で描く設定なら、log.FixationList を使います。
+func CreateHeatMapImage(renderer *heatmap.Renderer, log OneWebPageTrackLog, ScreenWidth int, ScreenHeight int, maxSecond int64, startTime float64) (*image.NRGBA, error) {
+	usePage := len(log.ViewportList) > 0
+	if usePage {
+		ScreenWidth, ScreenHeight = PageSize(log.ViewportList)
+	}
+	accumulator := renderer.NewAccumulator(ScreenWidth, ScreenHeight)
+	renderer.Add(accumulator, HeatMapPoints(renderer, log, maxSecond, startTime, usePage))
+	return renderer.Render(accumulator), nil
+}
+
+// startTime から maxSecond 秒までの、heatmap に加える点を返します。
+// renderer が注視で描く設定なら注視を、そうでなければフレームを点にします。usePage ならページ上の位置にします。
+func HeatMapPoints(renderer *heatmap.Renderer, log OneWebPageTrackLog, maxSecond int64, startTime float64, usePage bool) []heatmap.Point {
+	maxTime := startTime + float64(maxSecond) * 1000.0
+	// maxSecond が 0より大きい指定であれば、その時間までしか見ないで良いです。
+	inTime := func(t float64) bool {
+		return maxSecond <= 0 || t <= maxTime
+	}
 
-	drawImage := heatMapImage
-	drawWidth := 100.0
-	drawHeight := 100.0
-	maxTime := time.Unix(startUnixTime + maxSecond, 0)
+	pointList := []heatmap.Point{}
+	if renderer.UsesFixations() {
+		for i := range log.FixationList {
+			f := log.FixationList[i]
+			start := log.FrameArray[f.StartIndex]
+			if !inTime(start.ServerTime) {
+				break
+			}
+			x, y, ok := f.X, f.Y, true
+			if usePage {
+				x, y, ok = FixationPagePosition(log.FrameArray, f)
+			}
+			pointList = append(pointList, heatmap.Point{X: x, Y: y, Duration: f.Duration, Valid: ok})
+		}
+		return pointList
+	}
 	for i := 0; i < len(log.FrameArray) ; i++ {
 		frame := log.FrameArray[i]
 		if frame == nil || frame.Avg == nil {
 			continue
 		}
+		if !inTime(frame.ServerTime) {
+			break
+		}
+		point := heatmap.Point{X: frame.Avg.X, Y: frame.Avg.Y, Time: frame.ServerTime, Valid: true}
 		if frame.Avg.X <= 0.0 && frame.Avg.Y <= 0.0 {
 			// 外れ値っぽいので無視します。
-			continue
+			point.Valid = false
 		}
-		if maxSecond > 0 && maxTime.Sub(frame.GoTime) < 0 {
-			// maxSecond が 0より大きい指定であれば、その時間までしか見ないで良いです。
-			break
+		if usePage {
+			if frame.Page == nil || !frame.Page.Inside {
+				// ページの表示が分かる前か、ページの外を見ていたものです。
+				point.Valid = false
+			} else {
+				point.X = frame.Page.X
+				point.Y = frame.Page.Y
+			}
 		}
-		x := frame.Avg.X
-		y := frame.Avg.Y
-		x_start := x - drawWidth / 2.0
-		y_start := y - drawHeight / 2.0
-		x_end := x + drawWidth / 2.0
-		y_end := y + drawHeight / 2.0
-		draw.Draw(img, image.Rect(int(x_start), int(y_start), int(x_end), int(y_end)),
-			*drawImage, image.ZP, draw.Over)
+		pointList = append(pointList, point)
+	}
+	return pointList
+}
+
+// 注視の間のフレームのページ上の位置の平均を返します。ページの中を見ていたフレームが無ければ false を返します。
+func FixationPagePosition(frameArray []*Frame, f fixation.Fixation) (float64, float64, bool) {
+	x := 0.0
+	y := 0.0
+	count := 0
+	for i := f.StartIndex; i <= f.EndIndex && i < len(frameArray); i++ {
+		frame := frameArray[i]
+		if frame == nil || frame.Page == nil || !frame.Page.Inside {
+			continue
+		}
+		x += frame.Page.X
+		y += frame.Page.Y
+		count++
+	}
+	if count <= 0 {
+		return 0, 0, false
+	}
+	return x / float64(count), y / float64(count), true
+}
+
+// フレームの tracker の時刻をサーバの時計に直します。
+// サーバと同じように、それまでのフレームの tracker の時刻と受け取った時刻から時計のずれを求めて使います。
+// tracker の時刻が無いフレームは受け取った時刻のままにします。
+func AlignFrameTime(clock *clocksync.Estimator, frame *Frame) float64 {
+	received := UnixMillisecond(frame.GoTime)
+	if frame.Time <= 0 || frame.GoTime.IsZero() {
+		return received
+	}
+	clock.Add(frame.Time, received)
+	estimate, ok := clock.Estimate()
+	if !ok {
+		return received
+	}
+	return estimate.ToLocal(frame.Time)
+}
+
+// 一番大きかったページ全体の大きさを返します(ピクセル、一辺は 16384 まで)。
+func PageSize(stateList []viewport.State) (int, int) {
+	width := 0.0
+	height := 0.0
+	for i := range stateList {
+		w, h := stateList[i].DocumentSize()
+		width = math.Max(width, w)
+		height = math.Max(height, h)
+	}
+	return int(math.Min(width, 16384)), int(math.Min(height, 16384))
+}
+
+// フレームのページ上の位置を、最後に報告されたページの表示の様子から求めます。
+func MapFrameToPage(log *OneWebPageTrackLog, frame *Frame) {
+	if len(log.ViewportList) <= 0 || frame.Avg == nil {
+		return
+	}
+	state := log.ViewportList[len(log.ViewportList) - 1]
+	x, y, inside := state.Map(frame.Avg.X, frame.Avg.Y)
+	frame.Page = &PagePosition{X: x, Y: y, Inside: inside}
+}
+
+// 今の tracker の時計のずれを返します。まだ分からなければ nil を返します。
+func trackerClockEstimate(clock *clocksync.Estimator) *clocksync.Estimate {
+	estimate, ok := clock.Estimate()
+	if !ok {
+		return nil
 	}
-	return img, nil
+	return &estimate
 }
 
 type MainFlags struct {
 	LogFileName string
 	DirectoryName string
 	ImageConfigFileName string
+	CheckConfigFileName string
+	SegmentMarkers string
+	ScanPathFromSecond float64
+	ScanPathToSecond float64
+	Aggregate bool
+	KeepQuery bool
+	NormalizePerParticipant bool
+	Replay replay.Options
+}
+
+// 区切りを始める目印かどうかを返します。names は "," 区切りの名前のリストで、"*" なら全ての目印で区切ります。
+func IsSegmentMarker(names string, name string) bool {
+	for _, n := range strings.Split(names, ",") {
+		n = strings.TrimSpace(n)
+		if n != "" && (n == "*" || n == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// eyebit server の config.json のうち、注視やイベントの判定と、画像の描き方と AOI の設定だけを読み込むためのもの
+type CheckConfig struct {
+	Fixation *fixation.Config `json:"fixation"`
+	Events *gazeevent.Config `json:"events"`
+	HeatMap *heatmap.Config `json:"heatmap"`
+	ScanPath *scanpath.Config `json:"scanpath"`
+	TargetList []*aoi.Area `json:"targets"`
+	AOISetList []*aoi.Set `json:"aoi sets"`
+}
+
+// url のページで使う AOI のリストを返します(eyebit server と同じく、合う組が無ければ "targets" を使います)。
+func (c *CheckConfig) TargetsFor(url string) []*aoi.Area {
+	set := aoi.FindSet(c.AOISetList, url)
+	if set == nil {
+		return c.TargetList
+	}
+	return set.Targets
+}
+
+// 注視やイベントの判定の設定を読み込みます。
+// エラーは返さず、読めなければ既定の設定(中身が nil のもの)を返します。
+func LoadCheckConfig(fileName string) CheckConfig {
+	var config CheckConfig
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return CheckConfig{}
+	}
+	err = json.Unmarshal(buf, &config)
+	if err != nil {
+		fmt.Printf("file %s json decode error: %q\n", fileName, err)
+		return CheckConfig{}
+	}
+	return config
+}
+
+// フレームを注視の判定に使うサンプルにします。
+func FramesToFixationSamples(frameArray []*Frame) []fixation.Sample {
+	result := make([]fixation.Sample, len(frameArray))
+	for i := range frameArray {
+		frame := frameArray[i]
+		if frame == nil || frame.Avg == nil {
+			continue
+		}
+		result[i] = fixation.Sample{
+			Time: frame.Time,
+			X: frame.Avg.X,
+			Y: frame.Avg.Y,
+			Valid: !(frame.Avg.X <= 0.0 && frame.Avg.Y <= 0.0),
+		}
+	}
+	return result
+}
+
+// フレームをイベントの判定に使うサンプルにします。
+func FramesToEventSamples(frameArray []*Frame) []gazeevent.Sample {
+	fixationSamples := FramesToFixationSamples(frameArray)
+	result := make([]gazeevent.Sample, len(frameArray))
+	for i := range frameArray {
+		result[i].Sample = fixationSamples[i]
+		if frameArray[i] != nil {
+			result[i].State = frameArray[i].State
+		}
+	}
+	return result
+}
+
+// イベントのリストとそのまとめを JSON で書き出します。
+func SaveEventList(fileName string, eventList []gazeevent.Event, summary gazeevent.Summary) error {
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"summary": summary,
+		"events": eventList,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0666)
+}
+
+// 注視のリストを JSON で書き出します。
+func SaveFixationList(fileName string, fixationList []fixation.Fixation) error {
+	data, err := json.MarshalIndent(fixationList, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0666)
 }
 
 type ImageConfigUnit struct {
@@ -168,9 +469,9 @@ func LoadImageConfig(fileName string) map[string]string {
 	return result
 }
 
-func SaveHeatMapImage(fileName string, log OneWebPageTrackLog, width int, height int, maxSecond int64, startUnixTime int64, heatMapImage *image.Image, addImage *image.Image) error {
+func SaveHeatMapImage(fileName string, log OneWebPageTrackLog, width int, height int, maxSecond int64, startTime float64, renderer *heatmap.Renderer, addImage *image.Image) error {
 	fmt.Printf("  creating image %s (%s)...\n", fileName, log.Url)
-	img, err := CreateHeatMapImage(heatMapImage, log, width, height, maxSecond, startUnixTime)
+	heatMap, err := CreateHeatMapImage(renderer, log, width, height, maxSecond, startTime)
 	if err != nil {
 		fmt.Printf("heatmap image create error: %q\n", err)
 		return err
@@ -180,14 +481,13 @@ func SaveHeatMapImage(fileName string, log OneWebPageTrackLog, width int, height
 		fmt.Printf("heatmap image file create error: %q\n", err)
 		return err
 	}
+	var img image.Image = heatMap
 	if addImage != nil {
 		// addImage があれば、それに対して heatMap の画像を重ねた画像を作ります
-		newImg := image.NewRGBA(image.Rect(0, 0, int(width), int(height)) )
+		newImg := image.NewRGBA(heatMap.Bounds())
 		draw.Draw(newImg, newImg.Bounds(), image.Transparent, image.ZP, draw.Src)
-		draw.Draw(newImg, image.Rect(0, 0, int(width), int(height)),
-			*addImage, image.ZP, draw.Over)
-		draw.Draw(newImg, image.Rect(0, 0, int(width), int(height)),
-			img, image.ZP, draw.Over)
+		draw.Draw(newImg, newImg.Bounds(), *addImage, image.ZP, draw.Over)
+		draw.Draw(newImg, newImg.Bounds(), heatMap, image.ZP, draw.Over)
 		img = newImg
 	}
 
@@ -196,11 +496,124 @@ func SaveHeatMapImage(fileName string, log OneWebPageTrackLog, width int, height
 	return nil
 }
 
+// 区切りの始まりから fromSecond 秒から toSecond 秒までに始まった注視を、gaze plot に描く注視にします。
+// toSecond が 0 以下なら区切りの終わりまでです。ページが表示の様子を報告していれば、ページ上の位置にします。
+func ScanPathFixations(log OneWebPageTrackLog, fromSecond float64, toSecond float64) []scanpath.Fixation {
+	usePage := len(log.ViewportList) > 0
+	result := []scanpath.Fixation{}
+	for i := range log.FixationList {
+		f := log.FixationList[i]
+		second := (log.FrameArray[f.StartIndex].ServerTime - log.StartTime) / 1000.0
+		if second < fromSecond {
+			continue
+		}
+		if toSecond > 0 && second > toSecond {
+			break
+		}
+		x, y, ok := f.X, f.Y, true
+		if usePage {
+			x, y, ok = FixationPagePosition(log.FrameArray, f)
+		}
+		if ok {
+			result = append(result, scanpath.Fixation{X: x, Y: y, Start: f.Start, Duration: f.Duration})
+		}
+	}
+	return result
+}
+
+// gaze plot の画像と、backgroundImage があればそれに重ねた画像を作って save します。
+func SaveScanPathImageSet(fileNameBase string, log *OneWebPageTrackLog, width int, height int, fromSecond float64, toSecond float64, renderer *scanpath.Renderer, areaList []*aoi.Area, backgroundImage *image.Image) error {
+	if len(log.ViewportList) > 0 {
+		width, height = PageSize(log.ViewportList)
+		// 画面の座標の対象は、ページ全体の画像には描けません。
+		areaList = aoi.InSpace(areaList, aoi.SpacePage)
+	}
+	fixationList := ScanPathFixations(*log, fromSecond, toSecond)
+	fileName := fmt.Sprintf("%s_scanpath.png", fileNameBase)
+	fmt.Printf("  creating image %s (%s)...\n", fileName, log.Url)
+	err := SavePngImage(fileName, renderer.Render(width, height, fixationList, areaList))
+	if err != nil {
+		return err
+	}
+	log.ImageFileNameList = append(log.ImageFileNameList, fileName)
+	if backgroundImage == nil {
+		return nil
+	}
+	fileName = fmt.Sprintf("%s_bg_scanpath.png", fileNameBase)
+	fmt.Printf("  creating image %s (%s)...\n", fileName, log.Url)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), *backgroundImage, image.ZP, draw.Src)
+	renderer.Draw(img, fixationList, areaList)
+	err = SavePngImage(fileName, img)
+	if err != nil {
+		return err
+	}
+	log.ImageFileNameList = append(log.ImageFileNameList, fileName)
+	return nil
+}
+
+// フレームを動画に描くサンプルにします。usePage ならページ上の位置にします。
+func ReplaySamples(frameArray []*Frame, usePage bool) []replay.Sample {
+	fixationSamples := FramesToFixationSamples(frameArray)
+	result := make([]replay.Sample, 0, len(frameArray))
+	for i := range frameArray {
+		frame := frameArray[i]
+		if frame == nil {
+			continue
+		}
+		sample := replay.Sample{Time: frame.ServerTime, X: fixationSamples[i].X, Y: fixationSamples[i].Y, Valid: fixationSamples[i].Valid}
+		if usePage {
+			if !sample.Valid || frame.Page == nil || !frame.Page.Inside {
+				sample.Valid = false
+			} else {
+				sample.X = frame.Page.X
+				sample.Y = frame.Page.Y
+			}
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+// 区切りの始まりから最後のフレームまでを再生する動画を作って save します。
+func SaveReplay(fileNameBase string, log *OneWebPageTrackLog, width int, height int, options replay.Options, backgroundImage *image.Image) error {
+	if len(log.FrameArray) == 0 {
+		return nil
+	}
+	usePage := len(log.ViewportList) > 0
+	if usePage {
+		width, height = PageSize(log.ViewportList)
+	}
+	fileName := fmt.Sprintf("%s_replay%s", fileNameBase, replay.Extension(options.Format))
+	fmt.Printf("  creating replay %s (%s)...\n", fileName, log.Url)
+	frameWidth, frameHeight := replay.FrameSize(options, width, height)
+	writer, err := replay.NewWriter(options, fileName, frameWidth, frameHeight)
+	if err != nil {
+		return err
+	}
+	var background image.Image
+	if backgroundImage != nil {
+		background = *backgroundImage
+	}
+	end := log.FrameArray[len(log.FrameArray) - 1].ServerTime
+	_, err = replay.Render(writer, options, ReplaySamples(log.FrameArray, usePage), background, width, height, log.StartTime, end)
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	log.ReplayFileName = fileName
+	return nil
+}
+
 // 指定された秒だけ眺めるheatMapの画像を作って save します。
-func SaveHeatMapImageSet(fileNameBase string, log *OneWebPageTrackLog, width int, height int, maxSecond int64, startUnixTime int64, heatMapImage *image.Image, backgroundImage *image.Image) error {
+func SaveHeatMapImageSet(fileNameBase string, log *OneWebPageTrackLog, width int, height int, maxSecond int64, startTime float64, renderer *heatmap.Renderer, backgroundImage *image.Image) error {
 	// まずは素の eyetrack のデータを書き出します
 	fileName := fmt.Sprintf("%s_%dsec.png", fileNameBase, maxSecond)
-	err := SaveHeatMapImage(fileName, *log, width, height, maxSecond, startUnixTime, heatMapImage, nil)
+	err := SaveHeatMapImage(fileName, *log, width, height, maxSecond, startTime, renderer, nil)
 	if err != nil {
 		fmt.Printf("heatmap image create error: %q\n", err)
 		return err
@@ -209,7 +622,7 @@ func SaveHeatMapImageSet(fileNameBase string, log *OneWebPageTrackLog, width int
 
 	// backgroundImage があれば、その画像ファイルと合成した画像も作ります
 	fileName = fmt.Sprintf("%s_bg_%dsec.png", fileNameBase, maxSecond)
-	err = SaveHeatMapImage(fileName, *log, width, height, maxSecond, startUnixTime, heatMapImage, backgroundImage)
+	err = SaveHeatMapImage(fileName, *log, width, height, maxSecond, startTime, renderer, backgroundImage)
 	if err != nil {
 		fmt.Printf("heatmap image create error: %q\n", err)
 		return err
@@ -218,70 +631,843 @@ func SaveHeatMapImageSet(fileNameBase string, log *OneWebPageTrackLog, width int
 	return nil
 }
 
-func main(){
-	var flags MainFlags
-	flag.StringVar(&flags.LogFileName, "logFileName", "", "log file name")
-	flag.StringVar(&flags.DirectoryName, "directoryName", time.Now().Format("20060102_030405"), "output directory name")
-	flag.StringVar(&flags.ImageConfigFileName, "imageConfigFileName", "imageConfig.json", "image config file name (JSON format required)")
-	flag.Parse()
-	os.Args = flag.Args()
-
-	logFileName := flags.LogFileName
-	logFile, err := os.Open(logFileName)
+// log file を読み込んで、ページの移動(と segmentMarkers の目印)毎に区切ったものを返します。
+// logFileNameList は回転して分かれた一つの log のファイルの古い順のリストで、続けて一つの log として読みます。
+// 読めない行は表示して読み飛ばします。
+func LoadTrackLog(logFileNameList []string, segmentMarkers string) ([]OneWebPageTrackLog, error) {
+	logFile, err := eventlog.OpenLogs(logFileNameList)
 	if err != nil {
-		fmt.Printf("log file '%s' open error: %q\n", logFileName, err)
-		return
+		return nil, err
 	}
-
-	imageConfig := LoadImageConfig(flags.ImageConfigFileName)
-	
-	reader := bufio.NewReaderSize(logFile, 20480)
+	defer logFile.Close()
+	reader := eventlog.NewReader(logFile)
 
 	all_log := []OneWebPageTrackLog{}
 	current_log := OneWebPageTrackLog{}
 	current_log.Url = "UNKNOWN URL"
-
-	// 一行づつ読み込みます
+	var current_session *SessionInfo
+	trackerClock := clocksync.NewEstimator()
 	for {
-		bin, _, err := reader.ReadLine()
+		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
+		if _, ok := err.(*eventlog.LineError); ok {
+			fmt.Printf("log read error: %q\n", err)
+			continue
+		}
 		if err != nil {
 			fmt.Printf("read error: %q\n", err)
+			break
 		}
-		line := string(bin)
-		if strings.Contains(line, "request path") {
-			// URLをクリックした行
-			var requestPath RequestPath
-			err = json.Unmarshal(bin, &requestPath)
+		switch record.Type {
+		case eventlog.TypeSessionStart:
+			// セッションを始めた記録
+			var session SessionInfo
+			err = record.Decode(&session)
+			if err != nil {
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
+				continue
+			}
+			current_session = &session
+			current_log.Session = current_session
+		case eventlog.TypeSessionEnd:
+			// セッションを終えた記録
+			current_session = nil
+		case eventlog.TypeTrialStart, eventlog.TypeTrialEnd:
+			// 試行を始めた・終えた記録
+			var payload eventlog.TrialPayload
+			var trial Trial
+			err = record.Decode(&payload)
+			if err == nil {
+				err = json.Unmarshal(payload.Trial, &trial)
+			}
+			if err != nil {
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
+				continue
+			}
+			if record.Type == eventlog.TypeTrialStart {
+				current_log.TrialList = append(current_log.TrialList, trial)
+				continue
+			}
+			found := false
+			for j := range current_log.TrialList {
+				if current_log.TrialList[j].Index == trial.Index {
+					current_log.TrialList[j].EndTime = trial.EndTime
+					found = true
+				}
+			}
+			if !found {
+				// 前のページで始まった試行です。
+				current_log.TrialList = append(current_log.TrialList, trial)
+			}
+		case eventlog.TypeConnection:
+			// tracker との接続が切れた・繋がり直した記録
+			event := ConnectionEvent{GoTime: record.Time}
+			err = record.Decode(&event.Connection)
+			if err != nil {
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
+				continue
+			}
+			current_log.GapList = append(current_log.GapList, event)
+		case eventlog.TypeCalibration:
+			// キャリブレーションを行った記録
+			calibration := CalibrationLog{GoTime: record.Time}
+			err = record.Decode(&calibration.Result)
+			if err != nil || calibration.Result == nil {
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
+				continue
+			}
+			current_log.CalibrationList = append(current_log.CalibrationList, calibration)
+		case eventlog.TypeAOIEvent:
+			// 視線に応じた規則が発火した記録
+			var ruleEvent RuleEvent
+			err = record.Decode(&ruleEvent)
+			if err != nil {
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
+				continue
+			}
+			current_log.RuleEventList = append(current_log.RuleEventList, ruleEvent)
+		case eventlog.TypeMarker:
+			// ページから目印を打たれた記録
+			marker := MarkerLog{GoTime: record.Time}
+			err = record.Decode(&marker.Marker)
+			if err != nil {
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
+				continue
+			}
+			if IsSegmentMarker(segmentMarkers, marker.Name) {
+				// 同じページのまま、目印の所から新しい区切りにします。
+				current_log.TrackerClock = trackerClockEstimate(trackerClock)
+				all_log = append(all_log, current_log)
+				url := current_log.Url
+				viewportList := current_log.ViewportList
+				current_log = OneWebPageTrackLog{}
+				current_log.Url = url
+				if len(viewportList) > 0 {
+					// 同じページなので、表示の様子は続いています。
+					current_log.ViewportList = viewportList[len(viewportList) - 1:]
+				}
+				current_log.StartTime = marker.Time()
+				current_log.Session = current_session
+				current_log.SegmentMarker = marker.Name
+			}
+			current_log.MarkerList = append(current_log.MarkerList, marker)
+		case eventlog.TypeClock:
+			// 時計のずれの記録(tracker のものは自分でフレームから求めるので、ブラウザのものだけを使います)
+			var clock eventlog.Clock
+			err = record.Decode(&clock)
 			if err != nil {
-				fmt.Printf("json decode error: %q -> %s\n", err, line)
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
 				continue
 			}
+			if clock.Source == "browser" {
+				current_log.ClockList = append(current_log.ClockList, clock)
+			}
+		case eventlog.TypeViewport:
+			// ページの表示の様子(scroll 等)が変わった記録
+			var state viewport.State
+			err = record.Decode(&state)
+			if err != nil {
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
+				continue
+			}
+			current_log.ViewportList = append(current_log.ViewportList, state)
+		case eventlog.TypeNavigation:
+			// URLをクリックした記録
+			var navigation eventlog.Navigation
+			err = record.Decode(&navigation)
+			if err != nil {
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
+				continue
+			}
+			current_log.TrackerClock = trackerClockEstimate(trackerClock)
 			all_log = append(all_log, current_log)
 			current_log = OneWebPageTrackLog{}
-			current_log.Url = requestPath.RequestPath
-			current_log.UnixTime = requestPath.UnixTime
-		}else{
+			current_log.Url = navigation.Url
+			current_log.StartTime = UnixMillisecond(record.Time)
+			current_log.Session = current_session
+		case eventlog.TypeFrame:
 			// フレーム
-			var responseMessage OneFrameMessage
-			err = json.Unmarshal(bin, &responseMessage)
+			var frame Frame
+			err = record.Decode(&frame)
 			if err != nil {
-				fmt.Printf("json decode error: %q -> %s\n", err, line)
+				fmt.Printf("json decode error: %q -> %s\n", err, record.Payload)
 				continue
 			}
-			var ok bool
-			frame, ok := responseMessage.Values["frame"]
-			if ok == false {
-				fmt.Printf("response has no frame field")
-				return
-			}
-			current_log.FrameArray = append(current_log.FrameArray, frame)
+			frame.ServerTime = AlignFrameTime(trackerClock, &frame)
+			MapFrameToPage(&current_log, &frame)
+			current_log.FrameArray = append(current_log.FrameArray, &frame)
 		}
 	}
 	// 最後の分が入っていないはずなので入れます
+	current_log.TrackerClock = trackerClockEstimate(trackerClock)
 	all_log = append(all_log, current_log)
+	return all_log, nil
+
+}
+
+// 区切りの始まりの時刻を決めて、注視と fixation, saccade, blink, track loss を見つけます。
+func AnalyzeTrackLog(log *OneWebPageTrackLog, fixationDetector fixation.Detector, eventClassifier *gazeevent.Classifier) {
+	if log.StartTime <= 0 && len(log.FrameArray) > 0 {
+		// ページの移動より前の分は、最初のフレームから数えます。
+		log.StartTime = log.FrameArray[0].ServerTime
+	}
+	log.FixationList = fixationDetector.Detect(FramesToFixationSamples(log.FrameArray))
+	eventSamples := FramesToEventSamples(log.FrameArray)
+	log.EventList = eventClassifier.Classify(eventSamples)
+	log.EventSummary = gazeevent.Summarize(log.EventList, gazeevent.SamplesDuration(eventSamples))
+}
+
+// これより間の空いたフレームは、見ていた時間に数えません(ミリ秒, eyebit server と同じです)
+const MaxSampleIntervalMsec = 100.0
+
+// 一つの区切りの一つの AOI の指標(AOI の指標の表の一行)
+// 時刻は区切りの始まりからのミリ秒です。
+type AOIMetricsRow struct {
+	Participant string `json:"participant"`
+	Experiment string `json:"experiment"`
+	Session string `json:"session"`
+	Trial string `json:"trial"` // 区切りの間に始まった(か終わった)最初の試行
+	Stimulus string `json:"stimulus"` // 揃えた URL
+	Url string `json:"url"`
+	LogFile string `json:"log file"`
+	Segment int `json:"segment"` // log file の中の何番目の区切りか
+	SegmentMarker string `json:"segment marker"`
+	AOI string `json:"aoi"`
+	Space string `json:"space"`
+	SegmentMsec float64 `json:"segment msec"` // 区切りの長さ
+	Hit bool `json:"hit"`
+	DwellMsec float64 `json:"dwell msec"` // サンプルで数えた見ていた時間
+	DwellPercent float64 `json:"dwell percent"` // DwellMsec の区切りの長さに対する割合
+	FixationDwellMsec float64 `json:"fixation dwell msec"` // 注視で数えた見ていた時間
+	SampleCount int `json:"sample count"`
+	FixationCount int `json:"fixation count"`
+	TimeToFirstFixationMsec float64 `json:"time to first fixation msec"` // 注視が無ければ -1
+	FirstFixationMsec float64 `json:"first fixation msec"`
+	RevisitCount int `json:"revisit count"`
+	LastLookMsec float64 `json:"last look msec"` // 見ていなければ -1
+}
+
+// フレームの AOI の確認に使う視線の位置を返します。ページ上の位置が分からなければ画面の座標を使います。
+func FrameGaze(frame *Frame) aoi.Gaze {
+	if frame.Page == nil {
+		return aoi.ScreenGaze(frame.Avg.X, frame.Avg.Y)
+	}
+	return aoi.Gaze{X: frame.Avg.X, Y: frame.Avg.Y, PageX: frame.Page.X, PageY: frame.Page.Y, OnPage: frame.Page.Inside}
+}
+
+// 区切りの AOI 毎の指標を求めます。
+// 見ていた時間はサンプルと注視の両方で数え、それ以外は aoi.MetricsCounter と同じです。
+func AOIMetrics(log *OneWebPageTrackLog, targetList []*aoi.Area) (map[string]*aoi.Metrics, map[string]*aoi.Metrics) {
+	sampleCounter := aoi.NewMetricsCounter(log.StartTime, false)
+	fixationCounter := aoi.NewMetricsCounter(log.StartTime, true)
+	sampleCounter.AddTargets(targetList)
+	fixationCounter.AddTargets(targetList)
+
+	sampleList := FramesToFixationSamples(log.FrameArray)
+	for i := range sampleList {
+		if !sampleList[i].Valid {
+			// 外れ値っぽいので無視します。
+			continue
+		}
+		frame := log.FrameArray[i]
+		t := frame.ServerTime
+		duration := 0.0
+		for j := i + 1; j < len(sampleList); j++ {
+			if sampleList[j].Valid {
+				duration = log.FrameArray[j].ServerTime - t
+				break
+			}
+		}
+		if duration > MaxSampleIntervalMsec {
+			duration = 0
+		}
+		sampleCounter.AddSample(targetList, t, duration, FrameGaze(frame))
+	}
+
+	usePage := len(log.ViewportList) > 0
+	for i := range log.FixationList {
+		f := log.FixationList[i]
+		gaze := aoi.ScreenGaze(f.X, f.Y)
+		if usePage {
+			pageX, pageY, inside := FixationPagePosition(log.FrameArray, f)
+			gaze = aoi.Gaze{X: f.X, Y: f.Y, PageX: pageX, PageY: pageY, OnPage: inside}
+		}
+		start := log.FrameArray[f.StartIndex].ServerTime
+		end := log.FrameArray[f.EndIndex].ServerTime
+		sampleCounter.AddFixation(targetList, start, end, f.Duration, gaze)
+		fixationCounter.AddFixation(targetList, start, end, f.Duration, gaze)
+	}
+	return sampleCounter.Result(), fixationCounter.Result()
+}
+
+// 区切りの AOI 毎の指標を、表の行にします。AOI は targetList の順に並べます。
+func AOIMetricsRows(log *OneWebPageTrackLog, logFileName string, segment int, stimulus string, targetList []*aoi.Area) []AOIMetricsRow {
+	sampleMetrics, fixationMetrics := AOIMetrics(log, targetList)
+	segmentMsec := SegmentDuration(log)
+	rowList := []AOIMetricsRow{}
+	for _, area := range targetList {
+		if area == nil {
+			continue
+		}
+		m := sampleMetrics[area.Name]
+		row := AOIMetricsRow{
+			Participant: logFileName,
+			Stimulus: stimulus,
+			Url: log.Url,
+			LogFile: logFileName,
+			Segment: segment,
+			SegmentMarker: log.SegmentMarker,
+			AOI: area.Name,
+			Space: aoi.SpacePage,
+			SegmentMsec: segmentMsec,
+			Hit: m.Hit || fixationMetrics[area.Name].Hit,
+			DwellMsec: m.DwellMsec,
+			FixationDwellMsec: fixationMetrics[area.Name].DwellMsec,
+			SampleCount: m.SampleCount,
+			FixationCount: m.FixationCount,
+			TimeToFirstFixationMsec: m.TimeToFirstFixationMsec,
+			FirstFixationMsec: m.FirstFixationMsec,
+			RevisitCount: m.RevisitCount,
+			LastLookMsec: -1,
+		}
+		if area.Space == aoi.SpaceScreen {
+			row.Space = aoi.SpaceScreen
+		}
+		if log.Session != nil {
+			row.Experiment = log.Session.Experiment
+			row.Session = log.Session.Id
+			if log.Session.Participant != "" {
+				row.Participant = log.Session.Participant
+			}
+		}
+		if len(log.TrialList) > 0 {
+			row.Trial = log.TrialList[0].Name
+		}
+		if segmentMsec > 0 {
+			row.DwellPercent = 100.0 * m.DwellMsec / segmentMsec
+		}
+		if m.LastLook > 0 {
+			row.LastLookMsec = m.LastLook - log.StartTime
+		}
+		rowList = append(rowList, row)
+	}
+	return rowList
+}
+
+// AOI の指標の表を <dirName>/aoi_metrics.csv と <dirName>/aoi_metrics.json に書き出します。
+// CSV は R や pandas で読みやすいように、一行が参加者 x 刺激 x AOI で、列の名前は snake_case にします。
+func SaveAOIMetrics(dirName string, rowList []AOIMetricsRow) error {
+	data, err := json.MarshalIndent(rowList, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(fmt.Sprintf("%s/aoi_metrics.json", dirName), data, 0666)
+	if err != nil {
+		return err
+	}
+	csvFile, err := os.Create(fmt.Sprintf("%s/aoi_metrics.csv", dirName))
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(csvFile)
+	writer.Write([]string{
+		"participant", "experiment", "session", "trial", "stimulus", "url", "log_file", "segment", "segment_marker",
+		"aoi", "space", "segment_msec", "hit", "dwell_msec", "dwell_percent", "fixation_dwell_msec", "sample_count",
+		"fixation_count", "time_to_first_fixation_msec", "first_fixation_msec", "revisit_count", "last_look_msec",
+	})
+	number := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 3, 64)
+	}
+	for _, row := range rowList {
+		writer.Write([]string{
+			row.Participant, row.Experiment, row.Session, row.Trial, row.Stimulus, row.Url, row.LogFile,
+			strconv.Itoa(row.Segment), row.SegmentMarker, row.AOI, row.Space, number(row.SegmentMsec),
+			strconv.FormatBool(row.Hit), number(row.DwellMsec), number(row.DwellPercent), number(row.FixationDwellMsec),
+			strconv.Itoa(row.SampleCount), strconv.Itoa(row.FixationCount), number(row.TimeToFirstFixationMsec),
+			number(row.FirstFixationMsec), strconv.Itoa(row.RevisitCount), number(row.LastLookMsec),
+		})
+	}
+	writer.Flush()
+	err = writer.Error()
+	if err != nil {
+		csvFile.Close()
+		return err
+	}
+	return csvFile.Close()
+}
+
+// 集計する、一人の参加者の一つの区切り
+type AggregateSegment struct {
+	Participant string
+	FileName string
+	Log *OneWebPageTrackLog
+}
+
+// 値の並びのまとめ
+type Stats struct {
+	Count int `json:"count"`
+	Mean float64 `json:"mean"`
+	SD float64 `json:"sd"` // 標本標準偏差(二つ未満なら 0)
+	Median float64 `json:"median"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// 値の並びをまとめます。
+func Describe(values []float64) Stats {
+	result := Stats{Count: len(values)}
+	if len(values) == 0 {
+		return result
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	result.Mean = sum / float64(len(sorted))
+	if len(sorted) > 1 {
+		squares := 0.0
+		for _, v := range sorted {
+			squares += (v - result.Mean) * (v - result.Mean)
+		}
+		result.SD = math.Sqrt(squares / float64(len(sorted) - 1))
+	}
+	middle := len(sorted) / 2
+	result.Median = sorted[middle]
+	if len(sorted) % 2 == 0 {
+		result.Median = (sorted[middle - 1] + sorted[middle]) / 2.0
+	}
+	result.Min = sorted[0]
+	result.Max = sorted[len(sorted) - 1]
+	return result
+}
+
+// 一人の参加者の、一つの URL での値(何度か開いていれば足し合わせます)
+type ParticipantSummary struct {
+	Participant string `json:"participant"`
+	Segments int `json:"segments"`
+	ViewingMsec float64 `json:"viewing msec"`
+	FixationCount int `json:"fixation count"`
+	MeanFixationMsec float64 `json:"mean fixation msec"`
+	SaccadeCount int `json:"saccade count"`
+	BlinkCount int `json:"blink count"`
+	BlinkRate float64 `json:"blink rate"` // 一分あたり
+	ValidSampleRatio float64 `json:"valid sample ratio"` // 視線が取れていたフレームの割合
+	frameCount int
+	validCount int
+	fixationMsec float64
+}
+
+// 一つの URL の、参加者全員のまとめ
+type UrlSummary struct {
+	Url string `json:"url"`
+	Participants int `json:"participants"`
+	Segments int `json:"segments"`
+	ViewingMsec Stats `json:"viewing msec"`
+	FixationCount Stats `json:"fixation count"`
+	MeanFixationMsec Stats `json:"mean fixation msec"`
+	SaccadeCount Stats `json:"saccade count"`
+	BlinkRate Stats `json:"blink rate"`
+	ValidSampleRatio Stats `json:"valid sample ratio"`
+	ImageFileNameList []string `json:"images"`
+	ParticipantList []*ParticipantSummary `json:"participant list"`
+}
+
+// 区切りの長さ(最後のフレームまで、ミリ秒)を返します。
+func SegmentDuration(log *OneWebPageTrackLog) float64 {
+	if len(log.FrameArray) == 0 {
+		return 0
+	}
+	return math.Max(0, log.FrameArray[len(log.FrameArray) - 1].ServerTime - log.StartTime)
+}
+
+// 同じ刺激のページが同じ名前になるように URL を揃えます。
+// scheme, host, "#" 以降と、keepQuery でなければ "?" 以降を除き、最後の "/" と、最後の部分が "index.html" ならそれを落とします。
+func NormalizeUrl(rawUrl string, keepQuery bool) string {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return rawUrl
+	}
+	path := u.EscapedPath()
+	path = strings.TrimSuffix(path, "/index.html")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if path == "" {
+		path = "/"
+	}
+	if u.Scheme == "" && u.Host == "" && !strings.HasPrefix(u.Path, "/") {
+		// "UNKNOWN URL" 等の URL ではないものです。
+		path = u.Path
+	}
+	if keepQuery && u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+// ファイルとディレクトリ(の中の .json と .json.gz)を log file のリストにします。
+func CollectLogFiles(pathList []string) ([]string, error) {
+	result := []string{}
+	for _, path := range pathList {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			result = append(result, path)
+			continue
+		}
+		err = filepath.Walk(path, func(fileName string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && (strings.HasSuffix(fileName, ".json") || strings.HasSuffix(fileName, ".json.gz")) {
+				result = append(result, fileName)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// 参加者毎に、一つの URL での値をまとめます。
+func SummarizeUrl(url string, segmentList []AggregateSegment) *UrlSummary {
+	participantMap := map[string]*ParticipantSummary{}
+	participantList := []*ParticipantSummary{}
+	for _, segment := range segmentList {
+		p, ok := participantMap[segment.Participant]
+		if !ok {
+			p = &ParticipantSummary{Participant: segment.Participant}
+			participantMap[segment.Participant] = p
+			participantList = append(participantList, p)
+		}
+		log := segment.Log
+		p.Segments++
+		p.ViewingMsec += SegmentDuration(log)
+		p.FixationCount += len(log.FixationList)
+		for i := range log.FixationList {
+			p.fixationMsec += log.FixationList[i].Duration
+		}
+		p.SaccadeCount += log.EventSummary.SaccadeCount
+		p.BlinkCount += log.EventSummary.BlinkCount
+		for _, sample := range FramesToFixationSamples(log.FrameArray) {
+			p.frameCount++
+			if sample.Valid {
+				p.validCount++
+			}
+		}
+	}
+	sort.Slice(participantList, func(i int, j int) bool {
+		return participantList[i].Participant < participantList[j].Participant
+	})
+	result := &UrlSummary{Url: url, Participants: len(participantList), Segments: len(segmentList), ParticipantList: participantList}
+	var viewing, fixationCount, fixationMsec, saccadeCount, blinkRate, validRatio []float64
+	for _, p := range participantList {
+		if p.FixationCount > 0 {
+			p.MeanFixationMsec = p.fixationMsec / float64(p.FixationCount)
+			fixationMsec = append(fixationMsec, p.MeanFixationMsec)
+		}
+		if p.ViewingMsec > 0 {
+			p.BlinkRate = float64(p.BlinkCount) / (p.ViewingMsec / 60000.0)
+		}
+		if p.frameCount > 0 {
+			p.ValidSampleRatio = float64(p.validCount) / float64(p.frameCount)
+		}
+		viewing = append(viewing, p.ViewingMsec)
+		fixationCount = append(fixationCount, float64(p.FixationCount))
+		saccadeCount = append(saccadeCount, float64(p.SaccadeCount))
+		blinkRate = append(blinkRate, p.BlinkRate)
+		validRatio = append(validRatio, p.ValidSampleRatio)
+	}
+	result.ViewingMsec = Describe(viewing)
+	result.FixationCount = Describe(fixationCount)
+	result.MeanFixationMsec = Describe(fixationMsec)
+	result.SaccadeCount = Describe(saccadeCount)
+	result.BlinkRate = Describe(blinkRate)
+	result.ValidSampleRatio = Describe(validRatio)
+	return result
+}
+
+// 一つの URL の全員分の heatmap を作ります。
+// 全ての区切りがページの表示の様子を報告していればページ上の位置で、そうでなければ画面の座標で描きます。
+// normalize なら、参加者毎の重みの合計が揃うようにして、長く見ていた人だけが目立たないようにします。
+func CreateAggregateHeatMapImage(renderer *heatmap.Renderer, segmentList []AggregateSegment, width int, height int, normalize bool) *image.NRGBA {
+	usePage := true
+	for _, segment := range segmentList {
+		if len(segment.Log.ViewportList) == 0 {
+			usePage = false
+		}
+	}
+	if usePage {
+		width, height = 0, 0
+		for _, segment := range segmentList {
+			w, h := PageSize(segment.Log.ViewportList)
+			width = int(math.Max(float64(width), float64(w)))
+			height = int(math.Max(float64(height), float64(h)))
+		}
+	}
+	pointMap := map[string][][]heatmap.Point{}
+	totalMap := map[string]float64{}
+	for _, segment := range segmentList {
+		points := HeatMapPoints(renderer, *segment.Log, -1, segment.Log.StartTime, usePage)
+		pointMap[segment.Participant] = append(pointMap[segment.Participant], points)
+		totalMap[segment.Participant] += renderer.TotalWeight(points)
+	}
+	// 揃える時は、一人あたりの平均に合わせます(absolute の "max" がそのまま使えます)。
+	meanTotal := 0.0
+	for _, total := range totalMap {
+		meanTotal += total / float64(len(totalMap))
+	}
+	accumulator := renderer.NewAccumulator(width, height)
+	for participant, pointsList := range pointMap {
+		scale := 1.0
+		if normalize && totalMap[participant] > 0 {
+			scale = meanTotal / totalMap[participant]
+		}
+		for _, points := range pointsList {
+			renderer.AddScaled(accumulator, points, scale)
+		}
+	}
+	return renderer.Render(accumulator)
+}
+
+// URL 毎のまとめを CSV で書き出します。Stats の値は "<名前>_mean" のように列を分けます。
+func SaveUrlSummaryCsv(fileName string, summaryList []*UrlSummary) error {
+	csvFile, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(csvFile)
+	statsNames := []string{"viewing_msec", "fixation_count", "mean_fixation_msec", "saccade_count", "blink_rate", "valid_sample_ratio"}
+	header := []string{"url", "participants", "segments"}
+	for _, name := range statsNames {
+		header = append(header, name + "_mean", name + "_sd", name + "_median", name + "_min", name + "_max")
+	}
+	writer.Write(header)
+	for _, summary := range summaryList {
+		row := []string{summary.Url, strconv.Itoa(summary.Participants), strconv.Itoa(summary.Segments)}
+		for _, stats := range []Stats{summary.ViewingMsec, summary.FixationCount, summary.MeanFixationMsec, summary.SaccadeCount, summary.BlinkRate, summary.ValidSampleRatio} {
+			for _, v := range []float64{stats.Mean, stats.SD, stats.Median, stats.Min, stats.Max} {
+				row = append(row, strconv.FormatFloat(v, 'f', 3, 64))
+			}
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	err = writer.Error()
+	if err != nil {
+		csvFile.Close()
+		return err
+	}
+	return csvFile.Close()
+}
+
+// 複数の log file (かセッションのディレクトリ)を読み込んで、URL 毎に全員分の heatmap とまとめを作ります。
+func RunAggregate(flags MainFlags, pathList []string, checkConfig CheckConfig, imageConfig map[string]string, fixationDetector fixation.Detector, eventClassifier *gazeevent.Classifier) error {
+	fileNameList, err := CollectLogFiles(pathList)
+	if err != nil {
+		return err
+	}
+	if len(fileNameList) == 0 {
+		return errors.New("no log files to aggregate")
+	}
+	renderer, err := heatmap.NewRenderer(checkConfig.HeatMap, checkConfig.Fixation.PixelsPerDegree())
+	if err != nil {
+		return err
+	}
+	dirName := flags.DirectoryName
+	err = os.MkdirAll(dirName, os.ModeDir | 0755)
+	if err != nil {
+		return err
+	}
+
+	segmentMap := map[string][]AggregateSegment{}
+	aoiRowList := []AOIMetricsRow{}
+	originalUrlMap := map[string]string{} // 揃えた URL -> 最初に見つかった元の URL (背景の画像を探すのに使います)
+	// 回転して分かれた log は、繋げて一つの log として読みます。
+	for _, rotatedLog := range eventlog.GroupRotatedLogs(fileNameList) {
+		fileName := rotatedLog.Name
+		fmt.Printf("  loading %s (%d files)...\n", fileName, len(rotatedLog.FileNameList))
+		logList, err := LoadTrackLog(rotatedLog.FileNameList, flags.SegmentMarkers)
+		if err != nil {
+			fmt.Printf("log file '%s' open error: %q\n", fileName, err)
+			continue
+		}
+		for i := range logList {
+			log := &logList[i]
+			if len(log.FrameArray) == 0 {
+				continue
+			}
+			AnalyzeTrackLog(log, fixationDetector, eventClassifier)
+			participant := fileName
+			if log.Session != nil && log.Session.Participant != "" {
+				participant = log.Session.Participant
+			}
+			url := NormalizeUrl(log.Url, flags.KeepQuery)
+			if _, ok := originalUrlMap[url]; !ok {
+				originalUrlMap[url] = log.Url
+			}
+			segmentMap[url] = append(segmentMap[url], AggregateSegment{Participant: participant, FileName: fileName, Log: log})
+			aoiRowList = append(aoiRowList, AOIMetricsRows(log, fileName, i, url, checkConfig.TargetsFor(log.Url))...)
+		}
+	}
+	urlList := []string{}
+	for url := range segmentMap {
+		urlList = append(urlList, url)
+	}
+	sort.Strings(urlList)
+	fmt.Printf("%d log files, %d urls. create directory \"%s\" for aggregate heatmap images.\n", len(fileNameList), len(urlList), dirName)
+
+	summaryList := []*UrlSummary{}
+	for k, url := range urlList {
+		segmentList := segmentMap[url]
+		summary := SummarizeUrl(url, segmentList)
+		summaryList = append(summaryList, summary)
+
+		fileName := fmt.Sprintf("%s/%d_heatmap.png", dirName, k)
+		fmt.Printf("  creating image %s (%s, %d participants)...\n", fileName, url, summary.Participants)
+		img := CreateAggregateHeatMapImage(renderer, segmentList, 1920, 1080, flags.NormalizePerParticipant)
+		err = SavePngImage(fileName, img)
+		if err != nil {
+			return err
+		}
+		summary.ImageFileNameList = append(summary.ImageFileNameList, fileName)
+
+		imageFile := imageConfig[url]
+		if imageFile == "" {
+			imageFile = imageConfig[originalUrlMap[url]]
+		}
+		if imageFile == "" {
+			continue
+		}
+		backgroundImage, err := LoadPngImage(imageFile)
+		if err != nil {
+			fmt.Printf("%s load error: %q. image file MUST need PNG file format.\n", imageFile, err)
+			continue
+		}
+		bgImg := image.NewRGBA(img.Bounds())
+		draw.Draw(bgImg, bgImg.Bounds(), *backgroundImage, image.ZP, draw.Src)
+		draw.Draw(bgImg, bgImg.Bounds(), img, image.ZP, draw.Over)
+		fileName = fmt.Sprintf("%s/%d_bg_heatmap.png", dirName, k)
+		err = SavePngImage(fileName, bgImg)
+		if err != nil {
+			return err
+		}
+		summary.ImageFileNameList = append(summary.ImageFileNameList, fileName)
+	}
+
+	data, err := json.MarshalIndent(summaryList, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(fmt.Sprintf("%s/summary.json", dirName), data, 0666)
+	if err != nil {
+		return err
+	}
+	err = SaveUrlSummaryCsv(fmt.Sprintf("%s/summary.csv", dirName), summaryList)
+	if err != nil {
+		return err
+	}
+	err = SaveAOIMetrics(dirName, aoiRowList)
+	if err != nil {
+		return err
+	}
+	err = SavePngImage(fmt.Sprintf("%s/legend.png", dirName), renderer.Legend(256, 16))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("  creating index.html\n")
+	indexFile, err := os.Create(fmt.Sprintf("%s/index.html", dirName))
+	if err != nil {
+		return err
+	}
+	defer indexFile.Close()
+	fmt.Fprintf(indexFile, "<html><head><title>aggregate heatmap: %s</title></head><body>", dirName)
+	fmt.Fprintf(indexFile, "%d log files. heatmap: %s weighted, %s scale", len(fileNameList), renderer.Weight, renderer.Scale)
+	if flags.NormalizePerParticipant {
+		fmt.Fprintf(indexFile, ", normalized per participant")
+	}
+	fmt.Fprintf(indexFile, " <img src=\"legend.png\"> <a href=\"summary.csv\">summary.csv</a> <a href=\"summary.json\">summary.json</a>")
+	fmt.Fprintf(indexFile, " <a href=\"aoi_metrics.csv\">aoi_metrics.csv</a> <a href=\"aoi_metrics.json\">aoi_metrics.json</a><br>")
+	for _, summary := range summaryList {
+		fmt.Fprintf(indexFile, "<hr>%s<br>", html.EscapeString(summary.Url))
+		fmt.Fprintf(indexFile, "%d participants, %d segments, viewing %.0f msec (sd %.0f, median %.0f), %.1f fixations (mean %.0f msec), %.1f blinks /min<br>",
+			summary.Participants, summary.Segments, summary.ViewingMsec.Mean, summary.ViewingMsec.SD, summary.ViewingMsec.Median,
+			summary.FixationCount.Mean, summary.MeanFixationMsec.Mean, summary.BlinkRate.Mean)
+		for _, fileName := range summary.ImageFileNameList {
+			base := filepath.Base(fileName)
+			fmt.Fprintf(indexFile, "<a href=\"%s\"><img src=\"%s\" width=\"200\"></a> ", base, base)
+		}
+	}
+	fmt.Fprintf(indexFile, "</body></html>")
+	return nil
+}
+
+func main(){
+	var flags MainFlags
+	flag.StringVar(&flags.LogFileName, "logFileName", "", "log file name")
+	flag.StringVar(&flags.DirectoryName, "directoryName", time.Now().Format("20060102_030405"), "output directory name")
+	flag.StringVar(&flags.ImageConfigFileName, "imageConfigFileName", "imageConfig.json", "image config file name (JSON format required)")
+	flag.StringVar(&flags.CheckConfigFileName, "checkConfigFileName", "config.json", "eyebit server config file name for the fixation and heatmap settings (JSON format required)")
+	flag.StringVar(&flags.SegmentMarkers, "segmentMarkers", "", "comma separated marker names that start a new segment (\"*\": every marker)")
+	flag.Float64Var(&flags.ScanPathFromSecond, "scanpathFromSecond", 0, "draw the fixations of the gaze plot from this second of each segment")
+	flag.Float64Var(&flags.ScanPathToSecond, "scanpathToSecond", 0, "draw the fixations of the gaze plot up to this second of each segment (0: to the end)")
+	flag.BoolVar(&flags.Aggregate, "aggregate", false, "aggregate the log files and session directories given as arguments into one heatmap per url")
+	flag.BoolVar(&flags.KeepQuery, "keepQuery", false, "keep the query string when grouping urls for -aggregate")
+	flag.BoolVar(&flags.NormalizePerParticipant, "normalizePerParticipant", false, "give every participant the same total weight in -aggregate heatmaps")
+	defaultReplay := replay.DefaultOptions()
+	flag.StringVar(&flags.Replay.Format, "replay", "", fmt.Sprintf("write an animated replay of each segment: \"gif\" (up to %d frames of 960x540 or the same pixels in larger frames, kept in memory), \"png\" (numbered frames) or \"avi\" (Motion JPEG); use png or avi for long segments and long pages", replay.MaxGifPixels / (960 * 540)))
+	flag.Float64Var(&flags.Replay.Fps, "replayFps", defaultReplay.Fps, "frames per second of the replay")
+	flag.Float64Var(&flags.Replay.Speed, "replaySpeed", defaultReplay.Speed, "playback speed of the replay (2: twice as fast)")
+	flag.Float64Var(&flags.Replay.TrailMsec, "replayTrailMsec", defaultReplay.TrailMsec, "how long the fading gaze trail of the replay is (msec)")
+	flag.Float64Var(&flags.Replay.Scale, "replayScale", defaultReplay.Scale, "size of the replay relative to the screen or page")
+	flag.IntVar(&flags.Replay.Quality, "replayQuality", defaultReplay.Quality, "JPEG quality of the avi replay (1-100)")
+	flag.Parse()
+	os.Args = flag.Args()
+
+	if flags.Replay.Format != "" {
+		err := flags.Replay.Validate()
+		if err != nil {
+			fmt.Printf("replay option error: %q\n", err)
+			return
+		}
+	}
+	imageConfig := LoadImageConfig(flags.ImageConfigFileName)
+	checkConfig := LoadCheckConfig(flags.CheckConfigFileName)
+	fixationDetector, err := fixation.NewDetector(checkConfig.Fixation)
+	if err != nil {
+		fmt.Printf("fixation config error: %q\n", err)
+		return
+	}
+	eventClassifier := gazeevent.NewClassifier(fixationDetector, checkConfig.Events, checkConfig.Fixation.PixelsPerDegree())
+
+	if flags.Aggregate {
+		// 複数の log をまとめて、URL 毎に全員分の heatmap を作ります。
+		fileNameList := flag.Args()
+		if flags.LogFileName != "" {
+			fileNameList = append([]string{flags.LogFileName}, fileNameList...)
+		}
+		err = RunAggregate(flags, fileNameList, checkConfig, imageConfig, fixationDetector, eventClassifier)
+		if err != nil {
+			fmt.Printf("aggregate error: %q\n", err)
+		}
+		return
+	}
+
+	logFileName := flags.LogFileName
+	all_log, err := LoadTrackLog([]string{logFileName}, flags.SegmentMarkers)
+	if err != nil {
+		fmt.Printf("log file '%s' open error: %q\n", logFileName, err)
+		return
+	}
 
 	// ここまでで、all_log にそれぞれのページ毎のデータが入っているはず。
 
@@ -300,11 +1486,22 @@ func main(){
 		len(all_log), dirName)
 
 	// heatMap の画像をそのディレクトリに作ります
-	heatMapImage, err := LoadPngImage("heatmap_brush.png")
+	renderer, err := heatmap.NewRenderer(checkConfig.HeatMap, checkConfig.Fixation.PixelsPerDegree())
+	if err != nil {
+		fmt.Printf("heatmap config error: %q\n", err)
+		return
+	}
+	err = SavePngImage(fmt.Sprintf("%s/legend.png", dirName), renderer.Legend(256, 16))
+	if err != nil {
+		fmt.Printf("heatmap legend save error: %q\n", err)
+		return
+	}
+	scanPathRenderer, err := scanpath.NewRenderer(checkConfig.ScanPath)
 	if err != nil {
-		fmt.Printf("heatmap_brush.png load error: %q\n",  err)
+		fmt.Printf("scanpath config error: %q\n", err)
 		return
 	}
+	aoiRowList := []AOIMetricsRow{}
 	for i := 0; i < len(all_log); i++ {
 		log := &all_log[i]
 		width := 1920
@@ -324,30 +1521,63 @@ func main(){
 			}
 		}
 
+		// 注視と fixation, saccade, blink, track loss を見つけて書き出します
+		AnalyzeTrackLog(log, fixationDetector, eventClassifier)
+		err = SaveFixationList(fmt.Sprintf("%s_fixations.json", fileNameBase), log.FixationList)
+		if err != nil {
+			fmt.Printf("fixation list save error: %q\n", err)
+			return
+		}
+		err = SaveEventList(fmt.Sprintf("%s_events.json", fileNameBase), log.EventList, log.EventSummary)
+		if err != nil {
+			fmt.Printf("event list save error: %q\n", err)
+			return
+		}
+
+		// 注視の順番を示す gaze plot
+		err = SaveScanPathImageSet(fileNameBase, log, width, height, flags.ScanPathFromSecond, flags.ScanPathToSecond, scanPathRenderer, checkConfig.TargetsFor(log.Url), backgroundImage)
+		if err != nil {
+			fmt.Printf("scanpath image create error: %q\n", err)
+			return
+		}
 		// 最初は全てのもの
-		err = SaveHeatMapImageSet(fileNameBase, log, width, height, -1, log.UnixTime, heatMapImage, backgroundImage)
+		err = SaveHeatMapImageSet(fileNameBase, log, width, height, -1, log.StartTime, renderer, backgroundImage)
 		if err != nil {
 			fmt.Printf("heatmap image create error: %q\n", err)
 			return
 		}
 		// 5秒まで
-		err = SaveHeatMapImageSet(fileNameBase, log, width, height, 5, log.UnixTime, heatMapImage, backgroundImage)
+		err = SaveHeatMapImageSet(fileNameBase, log, width, height, 5, log.StartTime, renderer, backgroundImage)
 		if err != nil {
 			fmt.Printf("heatmap image create error: %q\n", err)
 			return
 		}
 		// 10秒まで
-		err = SaveHeatMapImageSet(fileNameBase, log, width, height, 10, log.UnixTime, heatMapImage, backgroundImage)
+		err = SaveHeatMapImageSet(fileNameBase, log, width, height, 10, log.StartTime, renderer, backgroundImage)
 		if err != nil {
 			fmt.Printf("heatmap image create error: %q\n", err)
 			return
 		}
 		// 15秒まで
-		err = SaveHeatMapImageSet(fileNameBase, log, width, height, 15, log.UnixTime, heatMapImage, backgroundImage)
+		err = SaveHeatMapImageSet(fileNameBase, log, width, height, 15, log.StartTime, renderer, backgroundImage)
 		if err != nil {
 			fmt.Printf("heatmap image create error: %q\n", err)
 			return
 		}
+		aoiRowList = append(aoiRowList, AOIMetricsRows(log, logFileName, i, NormalizeUrl(log.Url, flags.KeepQuery), checkConfig.TargetsFor(log.Url))...)
+		if flags.Replay.Format != "" {
+			// 視線の動きを再生する動画
+			err = SaveReplay(fileNameBase, log, width, height, flags.Replay, backgroundImage)
+			if err != nil {
+				// 動画が作れなくても、他の区切りと aoi の値は書き出します。
+				fmt.Printf("replay create error: %q\n", err)
+			}
+		}
+	}
+	err = SaveAOIMetrics(dirName, aoiRowList)
+	if err != nil {
+		fmt.Printf("aoi metrics save error: %q\n", err)
+		return
 	}
 	
 	fmt.Printf("  creating index.html\n")
@@ -358,9 +1588,83 @@ func main(){
 		return
 	}
 	fmt.Fprintf(indexFile, "<html><head><title>heatmap: %s</title></head><body>", dirName)
+	fmt.Fprintf(indexFile, "AOI metrics: <a href=\"../%s/aoi_metrics.csv\">aoi_metrics.csv</a> <a href=\"../%s/aoi_metrics.json\">aoi_metrics.json</a><br>", dirName, dirName)
+	fmt.Fprintf(indexFile, "heatmap: %s weighted, %s scale, sigma %.0f px, %s <img src=\"../%s/legend.png\"><br>", renderer.Weight, renderer.Scale, renderer.Sigma, renderer.ColorMap, dirName)
 	for i := 0; i < len(all_log); i++ {
-		fmt.Fprintf(indexFile, "<hr>%s<br>", all_log[i].Url)
 		log := all_log[i]
+		if log.SegmentMarker != "" {
+			fmt.Fprintf(indexFile, "<hr>%s (from marker %s)<br>", log.Url, log.SegmentMarker)
+		} else {
+			fmt.Fprintf(indexFile, "<hr>%s<br>", log.Url)
+		}
+		if log.Session != nil {
+			fmt.Fprintf(indexFile, "experiment %s, participant %s, session %s<br>", log.Session.Experiment, log.Session.Participant, log.Session.Id)
+		}
+		for j := 0; j < len(log.TrialList); j++ {
+			trial := log.TrialList[j]
+			end := "-"
+			if trial.EndTime != nil {
+				end = trial.EndTime.Format("15:04:05.000")
+			}
+			fmt.Fprintf(indexFile, "trial %d %s (%s) %s - %s<br>", trial.Index, trial.Name, trial.Condition, trial.StartTime.Format("15:04:05.000"), end)
+		}
+		for j := 0; j < len(log.MarkerList); j++ {
+			marker := log.MarkerList[j]
+			fmt.Fprintf(indexFile, "marker %s", marker.Name)
+			if marker.Trial != "" {
+				fmt.Fprintf(indexFile, " (trial %s)", marker.Trial)
+			}
+			fmt.Fprintf(indexFile, " at %s", marker.GoTime.Format("15:04:05.000"))
+			if marker.ClientTime != nil && marker.ClientOffset != nil {
+				fmt.Fprintf(indexFile, " (%+.0f msec after arrival time)", marker.Time() - marker.ServerTime)
+			} else if marker.ClientTime != nil {
+				fmt.Fprintf(indexFile, ", client clock %+.0f msec", *marker.ClientTime - marker.ServerTime)
+			}
+			if len(marker.Data) > 0 {
+				fmt.Fprintf(indexFile, " %s", html.EscapeString(string(marker.Data)))
+			}
+			fmt.Fprintf(indexFile, "<br>")
+		}
+		if len(log.ViewportList) > 0 {
+			pageWidth, pageHeight := PageSize(log.ViewportList)
+			fmt.Fprintf(indexFile, "heatmaps in page coordinates (%dx%d, %d viewport reports)<br>", pageWidth, pageHeight, len(log.ViewportList))
+		}
+		if log.TrackerClock != nil {
+			fmt.Fprintf(indexFile, "tracker clock offset %.1f msec, drift %.1f ppm, jitter %.1f msec", log.TrackerClock.Offset, log.TrackerClock.Drift, log.TrackerClock.Jitter)
+			if log.TrackerClock.Resets > 0 {
+				fmt.Fprintf(indexFile, ", %d resets", log.TrackerClock.Resets)
+			}
+			fmt.Fprintf(indexFile, "<br>")
+		}
+		for j := 0; j < len(log.ClockList); j++ {
+			clock := log.ClockList[j]
+			fmt.Fprintf(indexFile, "browser %s clock offset %.1f msec (round trip %.1f msec)<br>", html.EscapeString(clock.Client), clock.Offset, clock.RoundTrip)
+		}
+		for j := 0; j < len(log.GapList); j++ {
+			fmt.Fprintf(indexFile, "tracker %s at %s %s<br>", log.GapList[j].Event, log.GapList[j].GoTime.Format("15:04:05.000"), log.GapList[j].Reason)
+		}
+		if len(log.FixationList) > 0 {
+			totalDuration := 0.0
+			for j := 0; j < len(log.FixationList); j++ {
+				totalDuration += log.FixationList[j].Duration
+			}
+			fmt.Fprintf(indexFile, "%d fixations, mean duration %.0f msec <a href=\"../%s/%d_fixations.json\">fixations</a><br>", len(log.FixationList), totalDuration / float64(len(log.FixationList)), dirName, i)
+		}
+		if len(log.EventList) > 0 {
+			summary := log.EventSummary
+			fmt.Fprintf(indexFile, "%d saccades (mean amplitude %.0f px), %d blinks (%.1f /min), %d track losses <a href=\"../%s/%d_events.json\">events</a><br>", summary.SaccadeCount, summary.MeanSaccadeAmplitude, summary.BlinkCount, summary.BlinkRate, summary.TrackLossCount, dirName, i)
+		}
+		for j := 0; j < len(log.CalibrationList); j++ {
+			result := log.CalibrationList[j].Result
+			fmt.Fprintf(indexFile, "calibration at %s result %t (%.2f deg, left %.2f, right %.2f)<br>", log.CalibrationList[j].GoTime.Format("15:04:05.000"), result.Result, result.Deg, result.DegL, result.DegR)
+		}
+		for j := 0; j < len(log.RuleEventList); j++ {
+			event := log.RuleEventList[j]
+			fmt.Fprintf(indexFile, "rule %s (%s %s) fired at %s<br>", event.Rule, event.Type, event.AOI, event.Time.Format("15:04:05.000"))
+		}
+		if log.ReplayFileName != "" {
+			fmt.Fprintf(indexFile, "<a href=\"../%s\">replay</a><br>", log.ReplayFileName)
+		}
 		for j := 0; j < len(log.ImageFileNameList); j++{
 			fmt.Fprintf(indexFile, "<a href=\"../%s\"><img src=\"../%s\" width=\"100\"></a> ", log.ImageFileNameList[j], log.ImageFileNameList[j])
 		}
@@ -3,48 +3,221 @@ package main
 import (
 	"fmt"
 	"bufio"
+	"flag"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"./eyetribe"
 )
 
+type MainFlags struct {
+	ServerConfigFileName string
+}
+
+// コマンドライン引数を config に結びつけます。
+func bindServerConfigFlags(config *eyetribe.ServerConfig) {
+	flag.StringVar(&config.Source, "source", config.Source, "where gaze samples come from: \"tracker\", \"replay:<log file>\" or \"synthetic\"")
+	flag.Int64Var(&config.ScreenWidth, "screenWidth", config.ScreenWidth, "screen width for replay and synthetic sources (pixel)")
+	flag.Int64Var(&config.ScreenHeight, "screenHeight", config.ScreenHeight, "screen height for replay and synthetic sources (pixel)")
+	flag.Int64Var(&config.FrameRate, "frameRate", config.FrameRate, "frame rate of the synthetic source (Hz)")
+	flag.StringVar(&config.TrackerAddress, "trackerAddress", config.TrackerAddress, "EyeTribe server address (hostname:port)")
+	flag.StringVar(&config.ListenAddress, "listenAddress", config.ListenAddress, "HTTP listen address (hostname:port)")
+	flag.BoolVar(&config.TLS, "tls", config.TLS, "serve HTTPS instead of HTTP")
+	flag.StringVar(&config.CertFile, "certFile", config.CertFile, "TLS certificate file")
+	flag.StringVar(&config.KeyFile, "keyFile", config.KeyFile, "TLS key file")
+	flag.StringVar(&config.StaticDirectory, "staticDirectory", config.StaticDirectory, "static file directory")
+	flag.Int64Var(&config.BufferSeconds, "bufferSeconds", config.BufferSeconds, "seconds of frames to keep in memory")
+	flag.StringVar(&config.LogFileName, "logFileName", config.LogFileName, "log file name (empty: no log)")
+	flag.StringVar(&config.CheckConfigFileName, "checkConfigFileName", config.CheckConfigFileName, "eye track check config file name")
+	flag.BoolVar(&config.WatchCheckConfig, "watchCheckConfig", config.WatchCheckConfig, "reload the eye track check config file when it is modified")
+	flag.Int64Var(&config.ShutdownTimeout, "shutdownTimeout", config.ShutdownTimeout, "time to wait for running requests on shutdown (msec)")
+	flag.StringVar(&config.SessionDirectory, "sessionDirectory", config.SessionDirectory, "directory for per-session log files (empty: write sessions into the log file)")
+	flag.StringVar(&config.Experiment, "experiment", config.Experiment, "experiment name used when a session does not give one")
+	flag.Int64Var(&config.LogQueueSize, "logQueueSize", config.LogQueueSize, "log records that can wait to be written (more are dropped)")
+	flag.Int64Var(&config.LogSyncMsec, "logSyncMsec", config.LogSyncMsec, "fsync interval of the log file (msec, 0: only on rotation and close)")
+	flag.Int64Var(&config.LogMaxBytes, "logMaxBytes", config.LogMaxBytes, "rotate the log file when it gets larger than this (0: no rotation by size)")
+	flag.Int64Var(&config.LogMaxAgeSeconds, "logMaxAgeSeconds", config.LogMaxAgeSeconds, "rotate the log file after this many seconds (0: no rotation by age)")
+	flag.StringVar(&config.LogCompress, "logCompress", config.LogCompress, "compression of rotated log files (\"\" or \"gzip\")")
+}
+
+// 標準入力から一行づつ読んで channel に流します。読めなくなったら channel を閉じます。
+func readCommandLines() chan string {
+	lines := make(chan string)
+	go func(){
+		defer close(lines)
+		bio := bufio.NewReader(os.Stdin)
+		for {
+			line_bin, _, err := bio.ReadLine()
+			if err != nil {
+				return
+			}
+			lines <- string(line_bin)
+		}
+	}()
+	return lines
+}
+
+// 設定の source に従って GazeSource を作り、サンプルを溜め込み始めます。
+// tracker に繋いだ時だけ eye を返します(log file への書き出しは tracker の時だけ行います)。
+func startGazeSource(config *eyetribe.ServerConfig) (eyetribe.GazeSource, *eyetribe.EyeTribeConnection, error) {
+	kind, argument, err := eyetribe.ParseSourceSpec(config.Source)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch kind {
+	case eyetribe.SourceReplay:
+		source, err := eyetribe.NewLogReplaySource(argument, config.BufferSeconds, config.ScreenWidth, config.ScreenHeight)
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Printf("replaying %d frames of %s\n", len(source.FrameArray), argument)
+		source.Start()
+		return source, nil, nil
+	case eyetribe.SourceSynthetic:
+		source := eyetribe.NewSyntheticGazeSource(nil, config.FrameRate, config.BufferSeconds, config.ScreenWidth, config.ScreenHeight)
+		source.Start()
+		return source, nil, nil
+	}
+	eye, err := eyetribe.CreateServerConnection(config.TrackerAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	eye.LogOptions = config.LogFileOptions()
+	if config.LogFileName != "" {
+		err = eye.SetLogFile(config.LogFileName)
+		if err != nil {
+			eye.Close()
+			return nil, nil, err
+		}
+	}
+	eye.StartPullFrameTask(config.BufferSeconds) // BufferSeconds秒分溜め込ませます
+	return eye, eye, nil
+}
+
+// GazeSource がサンプルを溜め込むのを止めます。
+func stopGazeSource(source eyetribe.GazeSource) error {
+	switch s := source.(type) {
+	case *eyetribe.EyeTribeConnection:
+		return s.Close()
+	case *eyetribe.LogReplaySource:
+		s.Stop()
+	case *eyetribe.SyntheticGazeSource:
+		s.Stop()
+	}
+	return nil
+}
+
+// 指定の場所を確認していたかどうかを判定するための設定を読み直します。
+// 読み直しに失敗しても、今までの設定のまま動き続けます。
+func reloadCheckConfig(service *eyetribe.HttpService, fileName string) {
+	fmt.Println("設定ファイルを読み直します。")
+	err := service.LoadEyeTrackCheckConfig(fileName)
+	if err != nil {
+		fmt.Printf("config file load error:%q\n", err)
+	}
+}
+
 func main(){
-	eye, err := eyetribe.CreateServerConnection("localhost:6555")
+	var flags MainFlags
+	config := eyetribe.DefaultServerConfig()
+	flag.StringVar(&flags.ServerConfigFileName, "serverConfigFileName", "", "server config file name (JSON format required)")
+	bindServerConfigFlags(&config)
+	flag.Parse()
+
+	// 設定ファイル → 環境変数 の順に上書きしてから、
+	// もう一度コマンドライン引数を読んで、指定されたものだけを上書きし直します。
+	if flags.ServerConfigFileName != "" {
+		err := config.LoadFile(flags.ServerConfigFileName)
+		if err != nil {
+			fmt.Printf("server config file load error:%q\n", err)
+			return
+		}
+	}
+	err := config.LoadEnvironment()
 	if err != nil {
-		fmt.Printf("can not connect to server: %q\n", err)
+		fmt.Printf("environment variable error:%q\n", err)
 		return
 	}
-	err = eye.SetLogFile("log.json")
+	flag.CommandLine.Parse(os.Args[1:])
+	err = config.Validate()
 	if err != nil {
-		fmt.Printf("log file open error:%q\n", err)
+		fmt.Printf("server config error:%q\n", err)
 		return
 	}
-	err = eye.LoadEyeTrackCheckConfig("config.json")
+
+	source, eye, err := startGazeSource(&config)
+	if err != nil {
+		fmt.Printf("can not start gaze source %s: %q\n", config.Source, err)
+		return
+	}
+	if eye == nil && config.LogFileName != "" {
+		fmt.Printf("source %s does not write the log file %s\n", config.Source, config.LogFileName)
+	}
+	service := eyetribe.NewHttpService(source)
+	err = service.LoadEyeTrackCheckConfig(config.CheckConfigFileName)
 	if err != nil {
 		fmt.Printf("config file load error:%q\n", err)
+		stopGazeSource(source)
 		return
 	}
 	fmt.Println("start!")
-	eye.StartPullFrameTask(30) // 30秒分溜め込ませます
-	eye.StartHttpService(8888)
-
-	fmt.Println("\"q\" を入力して Enter で終了します。その他の Enger入力 で config.json を読み直します。")
-	bio := bufio.NewReader(os.Stdin)
-	for {
-		line_bin, _, _ := bio.ReadLine()
-		line_str := string(line_bin)
-		if len(line_str) > 0 && line_str[0] == "q"[0]{
-			break
-		}
-		fmt.Println("設定ファイルを読み直します。")
-		err = eye.LoadEyeTrackCheckConfig("config.json")
-		if err != nil {
-			fmt.Printf("config file load error:%q\n", err)
-			return
+	err = service.StartHttpService(&config)
+	if err != nil {
+		fmt.Printf("http service start error:%q\n", err)
+		stopGazeSource(source)
+		return
+	}
+
+	fmt.Printf("\"q\" を入力して Enter か SIGINT/SIGTERM で終了します。その他の Enger入力 か SIGHUP で %s を読み直します。\n", config.CheckConfigFileName)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	commands := readCommandLines()
+	quitWatch := make(chan bool)
+	var configChanged chan bool
+	if config.WatchCheckConfig {
+		configChanged = eyetribe.WatchFile(config.CheckConfigFileName, 2 * time.Second, quitWatch)
+	}
+	quitFlug := false
+	for quitFlug != true {
+		select {
+		case sig := <- signals:
+			if sig == syscall.SIGHUP {
+				reloadCheckConfig(service, config.CheckConfigFileName)
+				continue
+			}
+			fmt.Printf("signal %s received.\n", sig)
+			quitFlug = true
+		case line_str, ok := <- commands:
+			if !ok {
+				// 標準入力が無い(service として動いている等)ので、signal だけを待ちます。
+				commands = nil
+				continue
+			}
+			if len(line_str) > 0 && line_str[0] == "q"[0]{
+				quitFlug = true
+				continue
+			}
+			reloadCheckConfig(service, config.CheckConfigFileName)
+		case <- configChanged:
+			reloadCheckConfig(service, config.CheckConfigFileName)
 		}
 	}
+	signal.Stop(signals)
+	close(quitWatch)
 
 	fmt.Println("exit now.")
-	eye.Close()
+	err = service.StopHttpService(time.Duration(config.ShutdownTimeout) * time.Millisecond)
+	if err != nil {
+		fmt.Printf("http service stop error:%q\n", err)
+	}
+	err = stopGazeSource(source)
+	if err != nil {
+		fmt.Printf("close error:%q\n", err)
+	}
+	if eye != nil {
+		stats := eye.LogStats()
+		fmt.Printf("log: %d written, %d dropped, %d late, %d failed, %d rotations\n",
+			stats.Written, stats.Dropped, stats.Late, stats.Failed, stats.Rotations)
+	}
 }
-
-
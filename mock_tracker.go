@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"./eyetribe"
+)
+
+type MockFlags struct {
+	Address string
+	LogFileName string
+	NotCalibrated bool
+	HeartbeatInterval int64
+	ScreenWidth int64
+	ScreenHeight int64
+	FrameRate int64
+}
+
+func main(){
+	var flags MockFlags
+	defaultConfig := eyetribe.DefaultMockServerConfig()
+	flag.StringVar(&flags.Address, "address", "localhost:6555", "listen address")
+	flag.StringVar(&flags.LogFileName, "logFileName", "", "replay frames from this log file instead of a synthetic gaze path")
+	flag.BoolVar(&flags.NotCalibrated, "notCalibrated", false, "report the tracker as not calibrated")
+	flag.Int64Var(&flags.HeartbeatInterval, "heartbeatInterval", defaultConfig.HeartbeatInterval, "heartbeat interval (msec)")
+	flag.Int64Var(&flags.ScreenWidth, "screenWidth", defaultConfig.ScreenWidth, "screen width")
+	flag.Int64Var(&flags.ScreenHeight, "screenHeight", defaultConfig.ScreenHeight, "screen height")
+	flag.Int64Var(&flags.FrameRate, "frameRate", defaultConfig.FrameRate, "frame rate")
+	flag.Parse()
+
+	config := eyetribe.MockServerConfig{
+		Calibrated: !flags.NotCalibrated,
+		HeartbeatInterval: flags.HeartbeatInterval,
+		ScreenWidth: flags.ScreenWidth,
+		ScreenHeight: flags.ScreenHeight,
+		FrameRate: flags.FrameRate,
+	}
+	server, err := eyetribe.StartMockServer(flags.Address, config)
+	if err != nil {
+		fmt.Printf("mock server start error: %q\n", err)
+		return
+	}
+	if flags.LogFileName != "" {
+		err = server.LoadFrameLog(flags.LogFileName)
+		if err != nil {
+			fmt.Printf("log file '%s' load error: %q\n", flags.LogFileName, err)
+			server.Close()
+			return
+		}
+	}
+	fmt.Printf("mock tracker listening on %s\n", server.Addr())
+
+	fmt.Println("\"q\" を入力して Enter で終了します。その他の Enter入力 で接続を切ります(再接続の確認用)。")
+	bio := bufio.NewReader(os.Stdin)
+	for {
+		line_bin, _, err := bio.ReadLine()
+		if err != nil {
+			break
+		}
+		line_str := string(line_bin)
+		if len(line_str) > 0 && line_str[0] == "q"[0]{
+			break
+		}
+		fmt.Println("接続を切ります。")
+		server.DropConnections()
+	}
+
+	fmt.Println("exit now.")
+	server.Close()
+}
@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+)
+
+// Motion JPEG の AVI を書き出すもの
+// ヘッダを書いてからコマ(JPEG)を順に追記し、閉じる時に索引を書いてヘッダの大きさとコマの数を直します。
+type AviWriter struct {
+	FileName string
+	Width int
+	Height int
+	Fps float64
+	Quality int
+	file *os.File
+	writer *bufio.Writer
+	offset int64 // 次に書く位置
+	index []aviIndexEntry
+	maxFrameBytes int
+	buffer bytes.Buffer
+}
+
+type aviIndexEntry struct {
+	offset uint32 // "movi" の fourcc からの位置
+	size uint32
+}
+
+// ヘッダの中で、後から書き直す値の位置
+const (
+	aviRiffSizeOffset = 4
+	aviTotalFramesOffset = 48
+	aviSuggestedBufferOffset = 60
+	aviStreamLengthOffset = 140
+	aviStreamBufferOffset = 144
+	aviMoviSizeOffset = 216 // "movi" の LIST の大きさ(その後ろの "movi" から索引の前までの大きさ)
+)
+
+func NewAviWriter(fileName string, width int, height int, fps float64, quality int) (*AviWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	w := &AviWriter{
+		FileName: fileName,
+		Width: width,
+		Height: height,
+		Fps: fps,
+		Quality: quality,
+		file: file,
+		writer: bufio.NewWriter(file),
+	}
+	err = w.writeHeader()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *AviWriter) write(values ...interface{}) error {
+	for _, v := range values {
+		var err error
+		switch value := v.(type) {
+		case string:
+			_, err = w.writer.WriteString(value)
+			w.offset += int64(len(value))
+		case []byte:
+			_, err = w.writer.Write(value)
+			w.offset += int64(len(value))
+		default:
+			err = binary.Write(w.writer, binary.LittleEndian, value)
+			w.offset += int64(binary.Size(value))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RIFF のヘッダ(hdrl)と movi の LIST の始まりを書きます。コマの数等は 0 にしておいて、Close() で直します。
+func (w *AviWriter) writeHeader() error {
+	microSecPerFrame := uint32(math.Floor(1000000.0 / w.Fps + 0.5))
+	rate := uint32(math.Floor(w.Fps * 1000.0 + 0.5))
+	return w.write(
+		"RIFF", uint32(0), "AVI ",
+		"LIST", uint32(192), "hdrl",
+		// MainAVIHeader
+		"avih", uint32(56),
+		microSecPerFrame, uint32(0), uint32(0), uint32(0x10), // AVIF_HASINDEX
+		uint32(0), uint32(0), uint32(1), uint32(0), // total frames, initial frames, streams, suggested buffer size
+		uint32(w.Width), uint32(w.Height), [4]uint32{},
+		"LIST", uint32(116), "strl",
+		// AVIStreamHeader
+		"strh", uint32(56),
+		"vids", "MJPG", uint32(0), uint16(0), uint16(0), uint32(0),
+		uint32(1000), rate, uint32(0), uint32(0), uint32(0), // scale, rate, start, length, suggested buffer size
+		int32(-1), uint32(0), [4]int16{0, 0, int16(w.Width), int16(w.Height)},
+		// BITMAPINFOHEADER
+		"strf", uint32(40),
+		uint32(40), int32(w.Width), int32(w.Height), uint16(1), uint16(24), "MJPG",
+		uint32(w.Width * w.Height * 3), int32(0), int32(0), uint32(0), uint32(0),
+		"LIST", uint32(0), "movi",
+	)
+}
+
+func (w *AviWriter) WriteFrame(img image.Image) error {
+	if w.file == nil {
+		return errors.New("avi writer is closed")
+	}
+	w.buffer.Reset()
+	err := jpeg.Encode(&w.buffer, img, &jpeg.Options{Quality: w.Quality})
+	if err != nil {
+		return err
+	}
+	data := w.buffer.Bytes()
+	w.index = append(w.index, aviIndexEntry{
+		offset: uint32(w.offset - (aviMoviSizeOffset + 4)),
+		size: uint32(len(data)),
+	})
+	if len(data) > w.maxFrameBytes {
+		w.maxFrameBytes = len(data)
+	}
+	err = w.write("00dc", uint32(len(data)), data)
+	if err == nil && len(data) % 2 == 1 {
+		err = w.write([]byte{0})
+	}
+	return err
+}
+
+// 索引を書いて、大きさとコマの数を直してから閉じます。
+func (w *AviWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	defer func() {
+		w.file = nil
+	}()
+	moviEnd := w.offset
+	err := w.write("idx1", uint32(len(w.index) * 16))
+	for i := 0; err == nil && i < len(w.index); i++ {
+		err = w.write("00dc", uint32(0x10), w.index[i].offset, w.index[i].size) // AVIIF_KEYFRAME
+	}
+	if err == nil {
+		err = w.writer.Flush()
+	}
+	patch := func(offset int64, value uint32) {
+		if err != nil {
+			return
+		}
+		_, err = w.file.Seek(offset, io.SeekStart)
+		if err == nil {
+			err = binary.Write(w.file, binary.LittleEndian, value)
+		}
+	}
+	patch(aviRiffSizeOffset, uint32(w.offset - 8))
+	patch(aviTotalFramesOffset, uint32(len(w.index)))
+	patch(aviSuggestedBufferOffset, uint32(w.maxFrameBytes + 8))
+	patch(aviStreamLengthOffset, uint32(len(w.index)))
+	patch(aviStreamBufferOffset, uint32(w.maxFrameBytes + 8))
+	patch(aviMoviSizeOffset, uint32(moviEnd - (aviMoviSizeOffset + 4)))
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
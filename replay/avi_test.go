@@ -0,0 +1,127 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 書き出した AVI の RIFF の並びと、Close() で直した値が正しい位置にあるかを確かめます。
+func TestAviLayout(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "replay.avi")
+	const width, height, frames = 64, 48, 3
+	w, err := NewAviWriter(fileName, width, height, 10, DefaultQuality)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < frames; i++ {
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{uint8(i * 80), 0, 0, 255}), image.ZP, draw.Src)
+		err = w.WriteFrame(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.WriteFrame(img) == nil {
+		t.Errorf("WriteFrame() after Close() should fail")
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u32 := func(offset int) uint32 {
+		return binary.LittleEndian.Uint32(data[offset:])
+	}
+	fourcc := func(offset int) string {
+		return string(data[offset:offset + 4])
+	}
+
+	fourccTests := []struct {
+		offset int
+		want string
+	}{
+		{0, "RIFF"}, {8, "AVI "},
+		{12, "LIST"}, {20, "hdrl"},
+		{24, "avih"},
+		{88, "LIST"}, {96, "strl"},
+		{100, "strh"}, {108, "vids"}, {112, "MJPG"},
+		{164, "strf"}, {188, "MJPG"},
+		{aviMoviSizeOffset - 4, "LIST"}, {aviMoviSizeOffset + 4, "movi"},
+	}
+	for _, test := range fourccTests {
+		if got := fourcc(test.offset); got != test.want {
+			t.Errorf("fourcc at %d = %q, want %q", test.offset, got, test.want)
+		}
+	}
+	// LIST の大きさ通りに次の chunk が続きます。
+	if got := 12 + 8 + int(u32(16)); got != aviMoviSizeOffset - 4 {
+		t.Errorf("hdrl ends at %d, want %d", got, aviMoviSizeOffset - 4)
+	}
+	if got := 88 + 8 + int(u32(92)); got != aviMoviSizeOffset - 4 {
+		t.Errorf("strl ends at %d, want %d", got, aviMoviSizeOffset - 4)
+	}
+
+	valueTests := []struct {
+		name string
+		offset int
+		want uint32
+	}{
+		{"riff size", aviRiffSizeOffset, uint32(len(data) - 8)},
+		{"micro sec per frame", 32, 100000},
+		{"total frames", aviTotalFramesOffset, frames},
+		{"streams", 56, 1},
+		{"width", 64, width},
+		{"height", 68, height},
+		{"scale", 128, 1000},
+		{"rate", 132, 10000},
+		{"stream length", aviStreamLengthOffset, frames},
+	}
+	for _, test := range valueTests {
+		if got := u32(test.offset); got != test.want {
+			t.Errorf("%s at %d = %d, want %d", test.name, test.offset, got, test.want)
+		}
+	}
+	if u32(aviSuggestedBufferOffset) != u32(aviStreamBufferOffset) || u32(aviSuggestedBufferOffset) <= 8 {
+		t.Errorf("suggested buffer sizes = %d, %d", u32(aviSuggestedBufferOffset), u32(aviStreamBufferOffset))
+	}
+
+	// movi の後ろに索引が続き、索引は "movi" からの位置でコマを指します。
+	movi := aviMoviSizeOffset + 4
+	indexOffset := movi + int(u32(aviMoviSizeOffset))
+	if fourcc(indexOffset) != "idx1" || int(u32(indexOffset + 4)) != frames * 16 {
+		t.Fatalf("idx1 at %d: %q, size %d", indexOffset, fourcc(indexOffset), u32(indexOffset + 4))
+	}
+	if indexOffset + 8 + frames * 16 != len(data) {
+		t.Errorf("file size = %d, want %d", len(data), indexOffset + 8 + frames * 16)
+	}
+	for i := 0; i < frames; i++ {
+		entry := indexOffset + 8 + i * 16
+		offset := movi + int(u32(entry + 8))
+		size := int(u32(entry + 12))
+		if fourcc(entry) != "00dc" || u32(entry + 4) != 0x10 {
+			t.Errorf("index %d = %q, flags %x", i, fourcc(entry), u32(entry + 4))
+		}
+		if fourcc(offset) != "00dc" || int(u32(offset + 4)) != size {
+			t.Errorf("frame %d at %d: %q, size %d, want %d", i, offset, fourcc(offset), u32(offset + 4), size)
+			continue
+		}
+		frame, err := jpeg.Decode(bytes.NewReader(data[offset + 8:offset + 8 + size]))
+		if err != nil {
+			t.Errorf("frame %d: %s", i, err)
+			continue
+		}
+		if frame.Bounds().Dx() != width || frame.Bounds().Dy() != height {
+			t.Errorf("frame %d size = %v", i, frame.Bounds())
+		}
+	}
+}
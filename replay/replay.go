@@ -0,0 +1,169 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"../canvas"
+)
+
+// 既定値
+const (
+	DefaultFps = 10.0
+	DefaultSpeed = 1.0
+	DefaultTrailMsec = 1000.0
+	DefaultScale = 0.5
+	DefaultQuality = 80
+	MaxGapMsec = 200.0 // これより長く視線が取れなければ、今の視線の点を描きません
+)
+
+// 描く色
+var (
+	GazeColor = color.NRGBA{230, 30, 30, 255}
+	TrailColor = color.NRGBA{230, 30, 30, 255}
+	LabelColor = color.NRGBA{255, 255, 255, 255}
+	LabelBackgroundColor = color.NRGBA{0, 0, 0, 160}
+	BackgroundColor = color.NRGBA{255, 255, 255, 255}
+)
+
+// 動画の作り方
+type Options struct {
+	Format string // "gif", "png" (番号付きの PNG のディレクトリ) か "avi" (Motion JPEG)
+	Fps float64 // 動画の一秒あたりのコマ数
+	Speed float64 // 再生の速さ(2 なら 2 倍速)
+	TrailMsec float64 // 視線の軌跡を残す時間(ミリ秒)
+	Scale float64 // 元の座標に対する動画の大きさ
+	Quality int // avi の JPEG の品質(1 から 100)
+}
+
+// 既定の作り方を返します。
+func DefaultOptions() Options {
+	return Options{
+		Format: FormatGif,
+		Fps: DefaultFps,
+		Speed: DefaultSpeed,
+		TrailMsec: DefaultTrailMsec,
+		Scale: DefaultScale,
+		Quality: DefaultQuality,
+	}
+}
+
+// 作り方に誤りが無いかを確認します。
+func (o *Options) Validate() error {
+	switch o.Format {
+	case FormatGif, FormatPng, FormatAvi:
+	default:
+		return errors.New(fmt.Sprintf("unknown replay format: %s", o.Format))
+	}
+	if o.Fps <= 0 || o.Fps > 100 {
+		return errors.New(fmt.Sprintf("replay fps must be in 0 to 100: %f", o.Fps))
+	}
+	if o.Speed <= 0 {
+		return errors.New(fmt.Sprintf("replay speed must be positive: %f", o.Speed))
+	}
+	if o.TrailMsec < 0 {
+		return errors.New(fmt.Sprintf("replay trail msec must not be negative: %f", o.TrailMsec))
+	}
+	if o.Scale <= 0 || o.Scale > 4 {
+		return errors.New(fmt.Sprintf("replay scale must be in 0 to 4: %f", o.Scale))
+	}
+	if o.Quality < 1 || o.Quality > 100 {
+		return errors.New(fmt.Sprintf("replay quality must be in 1 to 100: %d", o.Quality))
+	}
+	return nil
+}
+
+// 一つの視線のサンプル
+type Sample struct {
+	Time float64 // ミリ秒
+	X float64 // ピクセル(元の座標)
+	Y float64
+	Valid bool
+}
+
+// 時刻の順に並んだサンプルを、start から end (ミリ秒) まで再生する動画を作って writer に書き出します。
+// background (nil なら白) を width x height の大きさとして、Scale 倍に縮めた上に描きます。
+// 書き出したコマの数を返します。writer は閉じません。
+func Render(writer FrameWriter, options Options, sampleList []Sample, background image.Image, width int, height int, start float64, end float64) (int, error) {
+	err := options.Validate()
+	if err != nil {
+		return 0, err
+	}
+	frameWidth, frameHeight := FrameSize(options, width, height)
+	base := image.NewRGBA(image.Rect(0, 0, frameWidth, frameHeight))
+	draw.Draw(base, base.Bounds(), image.NewUniform(BackgroundColor), image.ZP, draw.Src)
+	if background != nil {
+		canvas.DrawScaled(base, background, options.Scale)
+	}
+	frame := image.NewRGBA(base.Bounds())
+	stepMsec := 1000.0 / options.Fps * options.Speed
+	labelScale := int(math.Max(2, math.Min(6, float64(frameHeight) / 120.0)))
+	first := 0 // 軌跡に入るかもしれない最初のサンプル
+	next := 0 // まだ今の視線を探していない最初のサンプル
+	var current *Sample // t までで最後に視線が取れたサンプル(軌跡の長さには関わりません)
+	count := 0
+	for t := start; t <= end; t += stepMsec {
+		copy(frame.Pix, base.Pix)
+		for first < len(sampleList) && sampleList[first].Time < t - options.TrailMsec {
+			first++
+		}
+		if options.TrailMsec > 0 {
+			var previous *Sample // 軌跡の一つ前の視線が取れたサンプル
+			for i := first; i < len(sampleList) && sampleList[i].Time <= t; i++ {
+				sample := &sampleList[i]
+				if !sample.Valid {
+					continue
+				}
+				if previous != nil && sample.Time - previous.Time <= MaxGapMsec {
+					// 古いほど薄くします。
+					age := (t - sample.Time) / options.TrailMsec
+					c := TrailColor
+					c.A = uint8(200.0 * math.Max(0, 1.0 - age))
+					canvas.Line(frame, previous.X * options.Scale, previous.Y * options.Scale, sample.X * options.Scale, sample.Y * options.Scale, 3.0, c)
+				}
+				previous = sample
+			}
+		}
+		for next < len(sampleList) && sampleList[next].Time <= t {
+			if sampleList[next].Valid {
+				current = &sampleList[next]
+			}
+			next++
+		}
+		if current != nil && t - current.Time <= MaxGapMsec {
+			radius := math.Max(4, 12 * options.Scale)
+			canvas.FillCircle(frame, current.X * options.Scale, current.Y * options.Scale, radius, GazeColor)
+			canvas.StrokeCircle(frame, current.X * options.Scale, current.Y * options.Scale, radius, 2, LabelColor)
+		}
+		label := Timestamp(t - start)
+		if options.Speed != 1 {
+			label += fmt.Sprintf(" x%g", options.Speed)
+		}
+		canvas.Label(frame, labelScale * 2, labelScale * 2, label, labelScale, LabelColor, LabelBackgroundColor)
+		err = writer.WriteFrame(frame)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// 動画の大きさを返します(JPEG のために偶数にします)。
+func FrameSize(options Options, width int, height int) (int, int) {
+	frameWidth := int(math.Max(2, math.Floor(float64(width) * options.Scale / 2) * 2))
+	frameHeight := int(math.Max(2, math.Floor(float64(height) * options.Scale / 2) * 2))
+	return frameWidth, frameHeight
+}
+
+// 経過時間(ミリ秒)を "mm:ss.mmm" にします。
+func Timestamp(msec float64) string {
+	if msec < 0 {
+		msec = 0
+	}
+	total := int64(msec)
+	return fmt.Sprintf("%02d:%02d.%03d", total / 60000, total / 1000 % 60, total % 1000)
+}
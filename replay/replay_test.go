@@ -0,0 +1,156 @@
+package replay
+
+import (
+	"image"
+	"testing"
+)
+
+// コマごとに、(x, y) に今の視線の点が描かれているかを記録する FrameWriter
+type dotRecorder struct {
+	x int
+	y int
+	dots []bool
+}
+
+func (r *dotRecorder) WriteFrame(img image.Image) error {
+	red, green, blue, _ := img.At(r.x, r.y).RGBA()
+	r.dots = append(r.dots, red >> 8 == uint32(GazeColor.R) && green >> 8 == uint32(GazeColor.G) && blue >> 8 == uint32(GazeColor.B))
+	return nil
+}
+
+func (r *dotRecorder) Close() error {
+	return nil
+}
+
+// from から to (ミリ秒、to を含みます) まで 33 ミリ秒毎に (x, y) を見ているサンプルを返します。
+func gazeSamples(from float64, to float64, x float64, y float64, valid bool) []Sample {
+	result := []Sample{}
+	for t := from; t <= to; t += 33 {
+		result = append(result, Sample{Time: t, X: x, Y: y, Valid: valid})
+	}
+	return result
+}
+
+func TestRenderGazeDot(t *testing.T) {
+	lookThenLost := append(gazeSamples(0, 495, 200, 100, true), gazeSamples(528, 1023, 0, 0, false)...)
+	tests := []struct {
+		name string
+		trailMsec float64
+		samples []Sample
+		want []bool // 100 ミリ秒毎のコマに点があるかどうか
+	}{
+		{
+			name: "without a trail",
+			trailMsec: 0,
+			samples: gazeSamples(0, 1000, 200, 100, true),
+			want: []bool{true, true, true, true, true, true, true, true, true, true, true},
+		},
+		{
+			name: "with a trail",
+			trailMsec: 1000,
+			samples: gazeSamples(0, 1000, 200, 100, true),
+			want: []bool{true, true, true, true, true, true, true, true, true, true, true},
+		},
+		{
+			// 最後の視線から MaxGapMsec を過ぎたら点を消します。
+			name: "gaze lost",
+			trailMsec: 0,
+			samples: lookThenLost,
+			want: []bool{true, true, true, true, true, true, true, false, false, false, false},
+		},
+		{
+			name: "no gaze before the first sample",
+			trailMsec: 300,
+			samples: gazeSamples(250, 1000, 200, 100, true),
+			want: []bool{false, false, false, true, true, true, true, true, true, true, true},
+		},
+	}
+	for _, test := range tests {
+		options := DefaultOptions()
+		options.Fps = 10
+		options.TrailMsec = test.trailMsec
+		recorder := &dotRecorder{x: 100, y: 50}
+		count, err := Render(recorder, options, test.samples, nil, 400, 300, 0, 1000)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if count != len(test.want) || len(recorder.dots) != len(test.want) {
+			t.Errorf("%s: %d frames, want %d", test.name, count, len(test.want))
+			continue
+		}
+		for i := range test.want {
+			if recorder.dots[i] != test.want[i] {
+				t.Errorf("%s: frame %d has dot %v, want %v", test.name, i, recorder.dots[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		change func(o *Options)
+		ok bool
+	}{
+		{"default", func(o *Options) {}, true},
+		{"png", func(o *Options) { o.Format = FormatPng }, true},
+		{"avi", func(o *Options) { o.Format = FormatAvi }, true},
+		{"unknown format", func(o *Options) { o.Format = "mp4" }, false},
+		{"zero fps", func(o *Options) { o.Fps = 0 }, false},
+		{"too many fps", func(o *Options) { o.Fps = 120 }, false},
+		{"zero speed", func(o *Options) { o.Speed = 0 }, false},
+		{"no trail", func(o *Options) { o.TrailMsec = 0 }, true},
+		{"negative trail", func(o *Options) { o.TrailMsec = -1 }, false},
+		{"too large", func(o *Options) { o.Scale = 5 }, false},
+		{"zero quality", func(o *Options) { o.Quality = 0 }, false},
+	}
+	for _, test := range tests {
+		options := DefaultOptions()
+		test.change(&options)
+		err := options.Validate()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Validate() = %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+}
+
+func TestFrameSize(t *testing.T) {
+	tests := []struct {
+		scale float64
+		width int
+		height int
+		wantWidth int
+		wantHeight int
+	}{
+		{0.5, 1920, 1080, 960, 540},
+		{0.5, 1366, 767, 682, 382}, // JPEG のために偶数にします
+		{0.001, 100, 100, 2, 2},
+	}
+	for _, test := range tests {
+		options := DefaultOptions()
+		options.Scale = test.scale
+		width, height := FrameSize(options, test.width, test.height)
+		if width != test.wantWidth || height != test.wantHeight {
+			t.Errorf("FrameSize(%f, %d, %d) = %d, %d, want %d, %d", test.scale, test.width, test.height, width, height, test.wantWidth, test.wantHeight)
+		}
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		msec float64
+		want string
+	}{
+		{0, "00:00.000"},
+		{-5, "00:00.000"},
+		{1234.9, "00:01.234"},
+		{61005, "01:01.005"},
+		{3600000, "60:00.000"},
+	}
+	for _, test := range tests {
+		if got := Timestamp(test.msec); got != test.want {
+			t.Errorf("Timestamp(%f) = %s, want %s", test.msec, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// 動画の形式
+const (
+	FormatGif = "gif"
+	FormatPng = "png"
+	FormatAvi = "avi"
+)
+
+// 動画のコマを順に書き出すもの
+type FrameWriter interface {
+	WriteFrame(img image.Image) error // img は呼び出しの後に書き換えられることがあります
+	Close() error
+}
+
+// 形式に合った FrameWriter を作ります。
+// name は gif と avi ではファイルの名前、png では PNG を入れるディレクトリの名前です。
+func NewWriter(options Options, name string, width int, height int) (FrameWriter, error) {
+	switch options.Format {
+	case FormatGif:
+		return NewGifWriter(name, options.Fps), nil
+	case FormatPng:
+		return NewPngSequenceWriter(name)
+	case FormatAvi:
+		return NewAviWriter(name, width, height, options.Fps, options.Quality)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown replay format: %s", options.Format))
+}
+
+// 形式に合った、出力の名前の拡張子を返します(png はディレクトリなので "" です)。
+func Extension(format string) string {
+	switch format {
+	case FormatGif:
+		return ".gif"
+	case FormatAvi:
+		return ".avi"
+	}
+	return ""
+}
+
+// GIF に書き出せる全てのコマのピクセルの合計の上限
+// 既定の大きさ(960x540)なら 600 コマ(既定の 10 fps で一分)で、300MB ほどのメモリを使います。
+// ページの座標で描く長いページ(960x8192 など)は、その分少ないコマで上限になります。
+const MaxGifPixels = 600 * 960 * 540
+
+// animated GIF を書き出すもの
+// GIF は最後にまとめて書き出すので、全てのコマをメモリに持ちます。
+// コマのピクセルの合計が MaxPixels を超えるコマを書こうとしたらエラーを返し、Close() ではファイルを作りません。
+type GifWriter struct {
+	FileName string
+	MaxPixels int64 // NewGifWriter() では MaxGifPixels にします
+	delay int // 100 分の 1 秒
+	animation gif.GIF
+	pixels int64 // 持っているコマのピクセルの合計
+	tooLong bool
+}
+
+func NewGifWriter(fileName string, fps float64) *GifWriter {
+	delay := int(100.0 / fps + 0.5)
+	if delay < 2 {
+		// 多くのブラウザは 2 より短い delay を 10 として扱います。
+		delay = 2
+	}
+	return &GifWriter{FileName: fileName, MaxPixels: MaxGifPixels, delay: delay}
+}
+
+func (w *GifWriter) WriteFrame(img image.Image) error {
+	bounds := img.Bounds()
+	pixels := int64(bounds.Dx()) * int64(bounds.Dy())
+	if w.tooLong || w.pixels + pixels > w.MaxPixels {
+		frames := len(w.animation.Image)
+		w.tooLong = true
+		w.animation = gif.GIF{}
+		return errors.New(fmt.Sprintf("gif replay is limited to %d pixels in all frames, %d frames of %dx%d (%s): use png or avi for long or large segments", w.MaxPixels, frames, bounds.Dx(), bounds.Dy(), w.FileName))
+	}
+	w.pixels += pixels
+	paletted := image.NewPaletted(img.Bounds(), palette.WebSafe)
+	draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, img.Bounds().Min)
+	w.animation.Image = append(w.animation.Image, paletted)
+	w.animation.Delay = append(w.animation.Delay, w.delay)
+	return nil
+}
+
+func (w *GifWriter) Close() error {
+	if w.tooLong {
+		return nil
+	}
+	file, err := os.Create(w.FileName)
+	if err != nil {
+		return err
+	}
+	err = gif.EncodeAll(file, &w.animation)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.animation = gif.GIF{}
+	return file.Close()
+}
+
+// 番号付きの PNG (000001.png, 000002.png, ...) をディレクトリに書き出すもの
+type PngSequenceWriter struct {
+	DirectoryName string
+	count int
+}
+
+func NewPngSequenceWriter(directoryName string) (*PngSequenceWriter, error) {
+	err := os.MkdirAll(directoryName, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &PngSequenceWriter{DirectoryName: directoryName}, nil
+}
+
+func (w *PngSequenceWriter) WriteFrame(img image.Image) error {
+	w.count++
+	file, err := os.Create(filepath.Join(w.DirectoryName, fmt.Sprintf("%06d.png", w.count)))
+	if err != nil {
+		return err
+	}
+	err = png.Encode(file, img)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (w *PngSequenceWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package replay
+
+import (
+	"image"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGifWriter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "replay.gif")
+	w := NewGifWriter(fileName, 10)
+	if w.MaxPixels != MaxGifPixels {
+		t.Errorf("MaxPixels = %d, want %d", w.MaxPixels, MaxGifPixels)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 3; i++ {
+		err := w.WriteFrame(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	animation, err := gif.DecodeAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animation.Image) != 3 || animation.Delay[0] != 10 {
+		t.Errorf("gif has %d frames, delay %v", len(animation.Image), animation.Delay)
+	}
+}
+
+// ピクセルの合計が MaxPixels を超えるコマはエラーにして、途中までの GIF も作りません。
+func TestGifWriterTooLong(t *testing.T) {
+	tests := []struct {
+		name string
+		width int
+		height int
+		frames int // 書けるコマの数
+	}{
+		{"screen", 4, 3, 10},
+		{"long page", 4, 30, 1},
+		{"too large for one frame", 4, 40, 0},
+	}
+	for _, test := range tests {
+		fileName := filepath.Join(t.TempDir(), "replay.gif")
+		w := NewGifWriter(fileName, 10)
+		w.MaxPixels = 4 * 3 * 10
+		img := image.NewRGBA(image.Rect(0, 0, test.width, test.height))
+		for i := 0; i < test.frames; i++ {
+			err := w.WriteFrame(img)
+			if err != nil {
+				t.Fatalf("%s: frame %d: %s", test.name, i, err)
+			}
+		}
+		if w.WriteFrame(img) == nil {
+			t.Fatalf("%s: frame %d should fail", test.name, test.frames + 1)
+		}
+		if w.WriteFrame(image.NewRGBA(image.Rect(0, 0, 1, 1))) == nil {
+			t.Errorf("%s: frame after the limit should fail", test.name)
+		}
+		err := w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+			t.Errorf("%s: too long gif should not be written: %v", test.name, err)
+		}
+	}
+}
+
+func TestGifDelay(t *testing.T) {
+	tests := []struct {
+		fps float64
+		want int
+	}{
+		{10, 10},
+		{30, 3},
+		{100, 2}, // 2 より短いと多くのブラウザで遅くなります
+	}
+	for _, test := range tests {
+		if got := NewGifWriter("x.gif", test.fps).delay; got != test.want {
+			t.Errorf("delay at %f fps = %d, want %d", test.fps, got, test.want)
+		}
+	}
+}
+
+func TestPngSequenceWriter(t *testing.T) {
+	directoryName := filepath.Join(t.TempDir(), "replay")
+	w, err := NewPngSequenceWriter(directoryName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < 2; i++ {
+		err = w.WriteFrame(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	for _, name := range []string{"000001.png", "000002.png"} {
+		if _, err := os.Stat(filepath.Join(directoryName, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package scanpath
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"../aoi"
+	"../canvas"
+	"../heatmap"
+)
+
+// 既定値
+const (
+	DefaultMinRadius = 8.0 // ピクセル
+	DefaultMaxRadius = 50.0
+	DefaultMaxRadiusMsec = 1000.0 // この長さ以上の注視は一番大きい円にします
+	DefaultLineWidth = 2.0
+	DefaultAlpha = 0.6
+	DefaultColorMap = "jet"
+)
+
+// 色を付けない時の色
+var (
+	FixationColor = color.NRGBA{30, 100, 200, 255}
+	SaccadeColor = color.NRGBA{60, 60, 60, 255}
+	NumberColor = color.NRGBA{255, 255, 255, 255}
+	NumberShadowColor = color.NRGBA{0, 0, 0, 200}
+	AOIColor = color.NRGBA{0, 160, 0, 255}
+)
+
+// gaze plot (scanpath) の描き方の設定
+// config.json の "scanpath" に書きます。
+type Config struct {
+	MinRadius float64 `json:"min radius"` // 一番短い注視の円の半径(ピクセル)
+	MaxRadius float64 `json:"max radius"` // 一番長い注視の円の半径
+	MaxRadiusMsec float64 `json:"max radius msec"` // この長さ以上の注視は MaxRadius にします
+	LineWidth float64 `json:"line width"` // saccade の線の太さ
+	Alpha float64 `json:"alpha"` // 円の不透明度(0 から 1)
+	ColorByTime bool `json:"color by time"` // 注視の時刻で色を変えるかどうか(最初が ColorMap の 0, 最後が 1)
+	ColorMap string `json:"color map"` // ColorByTime の時の色の目盛り(heatmap と同じ名前)
+	HideNumbers bool `json:"hide numbers"` // 注視の順番の番号を書かないかどうか
+	AOIOutlines bool `json:"aoi outlines"` // AOI の縁を描くかどうか
+}
+
+// 一つの注視
+type Fixation struct {
+	X float64 // 描く座標(ピクセル)
+	Y float64
+	Start float64 // 始まった時刻(ミリ秒)
+	Duration float64 // 長さ(ミリ秒)
+}
+
+// 設定に誤りが無いかを確認します。値が 0 の項目は既定値を使います。
+func (c *Config) Validate() error {
+	if c.MinRadius < 0 || c.MaxRadius < 0 || c.MaxRadiusMsec < 0 || c.LineWidth < 0 {
+		return errors.New("scanpath radius, msec and line width must not be negative")
+	}
+	if c.MaxRadius > 0 && c.MaxRadius < c.MinRadius {
+		return errors.New(fmt.Sprintf("scanpath max radius %.0f is smaller than min radius %.0f", c.MaxRadius, c.MinRadius))
+	}
+	if c.Alpha < 0 || c.Alpha > 1 {
+		return errors.New("scanpath alpha must be in 0 to 1")
+	}
+	if _, ok := heatmap.ColorAt(c.ColorMap, 0); c.ColorMap != "" && !ok {
+		return errors.New(fmt.Sprintf("unknown scanpath color map: %s", c.ColorMap))
+	}
+	return nil
+}
+
+// 設定に従って gaze plot を描くもの
+type Renderer struct {
+	Config
+}
+
+// 設定から Renderer を作ります。config が nil なら既定の設定にします。
+func NewRenderer(config *Config) (*Renderer, error) {
+	r := &Renderer{}
+	if config != nil {
+		err := config.Validate()
+		if err != nil {
+			return nil, err
+		}
+		r.Config = *config
+	}
+	if r.MinRadius <= 0 {
+		r.MinRadius = DefaultMinRadius
+	}
+	if r.MaxRadius <= 0 {
+		r.MaxRadius = math.Max(DefaultMaxRadius, r.MinRadius)
+	}
+	if r.MaxRadiusMsec <= 0 {
+		r.MaxRadiusMsec = DefaultMaxRadiusMsec
+	}
+	if r.LineWidth <= 0 {
+		r.LineWidth = DefaultLineWidth
+	}
+	if r.Alpha <= 0 {
+		r.Alpha = DefaultAlpha
+	}
+	if r.ColorMap == "" {
+		r.ColorMap = DefaultColorMap
+	}
+	return r, nil
+}
+
+// 注視の長さから円の半径を返します。面積が長さに比例するようにします。
+func (r *Renderer) Radius(duration float64) float64 {
+	v := math.Sqrt(math.Max(0, math.Min(1, duration / r.MaxRadiusMsec)))
+	return r.MinRadius + (r.MaxRadius - r.MinRadius) * v
+}
+
+// i 番目の注視の色を返します。
+func (r *Renderer) fixationColor(fixationList []Fixation, i int) color.NRGBA {
+	if !r.ColorByTime || len(fixationList) < 2 {
+		return FixationColor
+	}
+	first := fixationList[0].Start
+	last := fixationList[len(fixationList) - 1].Start
+	v := 0.0
+	if last > first {
+		v = (fixationList[i].Start - first) / (last - first)
+	}
+	c, _ := heatmap.ColorAt(r.ColorMap, v)
+	return c
+}
+
+// 透明な width x height の画像に gaze plot を描きます。
+func (r *Renderer) Render(width int, height int, fixationList []Fixation, areaList []*aoi.Area) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	r.Draw(img, fixationList, areaList)
+	return img
+}
+
+// dst の上に、時刻の順に並んだ注視を番号付きの円と saccade の線で描きます。
+// AOIOutlines なら、先に areaList の縁を描きます。
+func (r *Renderer) Draw(dst draw.Image, fixationList []Fixation, areaList []*aoi.Area) {
+	if r.AOIOutlines {
+		for _, a := range areaList {
+			if a != nil {
+				DrawArea(dst, a, r.LineWidth, AOIColor)
+			}
+		}
+	}
+	for i := 1; i < len(fixationList); i++ {
+		from := fixationList[i - 1]
+		to := fixationList[i]
+		c := SaccadeColor
+		if r.ColorByTime {
+			c = r.fixationColor(fixationList, i - 1)
+		}
+		canvas.Line(dst, from.X, from.Y, to.X, to.Y, r.LineWidth, c)
+	}
+	for i := range fixationList {
+		f := fixationList[i]
+		radius := r.Radius(f.Duration)
+		c := r.fixationColor(fixationList, i)
+		fill := c
+		fill.A = uint8(255.0 * r.Alpha)
+		canvas.FillCircle(dst, f.X, f.Y, radius, fill)
+		canvas.StrokeCircle(dst, f.X, f.Y, radius, 1.5, c)
+		if !r.HideNumbers {
+			label := strconv.Itoa(i + 1)
+			scale := int(math.Max(1, math.Min(4, radius / 6.0)))
+			// 明るい色の上でも読めるように、影を付けます。
+			canvas.TextCentered(dst, f.X + float64(scale), f.Y + float64(scale), label, scale, NumberShadowColor)
+			canvas.TextCentered(dst, f.X, f.Y, label, scale, NumberColor)
+		}
+	}
+}
+
+// AOI の縁(padding を含みます)を width の太さで描きます。
+func DrawArea(dst draw.Image, a *aoi.Area, width float64, c color.Color) {
+	minX, minY, maxX, maxY := a.Bounds()
+	rect := image.Rect(int(math.Floor(minX - width)), int(math.Floor(minY - width)), int(math.Ceil(maxX + width)), int(math.Ceil(maxY + width)))
+	canvas.Paint(dst, rect, func(x float64, y float64) float64 {
+		// 中にあって、width 離れた所のどれかが外なら縁です。
+		if !a.Contains(x, y) {
+			return 0
+		}
+		for _, d := range [][2]float64{{width, 0}, {-width, 0}, {0, width}, {0, -width}} {
+			if !a.Contains(x + d[0], y + d[1]) {
+				return 1
+			}
+		}
+		return 0
+	}, c)
+}
@@ -0,0 +1,141 @@
+package scanpath
+
+import (
+	"image/color"
+	"math"
+	"testing"
+	"../aoi"
+	"../heatmap"
+)
+
+func newTestRenderer(t *testing.T, config *Config) *Renderer {
+	r, err := NewRenderer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// 円の面積は MaxRadiusMsec までは注視の長さに比例して増え、その先は MaxRadius のままです。
+func TestRadius(t *testing.T) {
+	r := newTestRenderer(t, &Config{MinRadius: 10, MaxRadius: 50, MaxRadiusMsec: 1000})
+	tests := []struct {
+		duration float64
+		want float64
+	}{
+		{-100, 10},
+		{0, 10},
+		{250, 30},
+		{1000, 50},
+		{5000, 50},
+	}
+	for _, test := range tests {
+		if got := r.Radius(test.duration); math.Abs(got - test.want) > 1e-9 {
+			t.Errorf("Radius(%v) = %v, want %v", test.duration, got, test.want)
+		}
+	}
+	// MinRadius を除いた円の面積は長さに比例します。
+	area := func(d float64) float64 {
+		extra := r.Radius(d) - r.MinRadius
+		return extra * extra
+	}
+	if ratio := area(400) / area(100); math.Abs(ratio - 4) > 1e-9 {
+		t.Errorf("area(400) / area(100) = %v, want 4", ratio)
+	}
+	previous := r.Radius(0)
+	for d := 50.0; d <= 1500; d += 50 {
+		radius := r.Radius(d)
+		if radius < previous {
+			t.Errorf("Radius(%v) = %v is smaller than Radius(%v) = %v", d, radius, d - 50, previous)
+		}
+		previous = radius
+	}
+}
+
+func TestFixationColor(t *testing.T) {
+	fixationList := []Fixation{
+		{X: 10, Y: 10, Start: 1000, Duration: 200},
+		{X: 20, Y: 20, Start: 1500, Duration: 200},
+		{X: 30, Y: 30, Start: 2000, Duration: 200},
+	}
+	byTime := newTestRenderer(t, &Config{ColorByTime: true, ColorMap: "gray"})
+	first, _ := heatmap.ColorAt("gray", 0)
+	middle, _ := heatmap.ColorAt("gray", 0.5)
+	last, _ := heatmap.ColorAt("gray", 1)
+	tests := []struct {
+		name string
+		renderer *Renderer
+		fixationList []Fixation
+		i int
+		want color.NRGBA
+	}{
+		{"first", byTime, fixationList, 0, first},
+		{"middle", byTime, fixationList, 1, middle},
+		{"last", byTime, fixationList, 2, last},
+		{"only one", byTime, fixationList[:1], 0, FixationColor},
+		{"same start", byTime, []Fixation{{Start: 10}, {Start: 10}}, 1, first},
+		{"not by time", newTestRenderer(t, nil), fixationList, 2, FixationColor},
+	}
+	for _, test := range tests {
+		if got := test.renderer.fixationColor(test.fixationList, test.i); got != test.want {
+			t.Errorf("%s: fixationColor() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// 注視の所に円が、注視の間に saccade の線が描かれ、離れた所は透明のままです。
+func TestRender(t *testing.T) {
+	r := newTestRenderer(t, &Config{HideNumbers: true, AOIOutlines: true})
+	fixationList := []Fixation{
+		{X: 30, Y: 30, Start: 0, Duration: 500},
+		{X: 150, Y: 30, Start: 600, Duration: 100},
+	}
+	areaList := []*aoi.Area{{Name: "a", X: 20, Y: 120, Width: 60, Height: 40}}
+	img := r.Render(200, 200, fixationList, areaList)
+	if got := img.Bounds().Size(); got.X != 200 || got.Y != 200 {
+		t.Fatalf("image size = %v, want 200x200", got)
+	}
+	// 円の中(saccade の線から外れた所)は FixationColor を Alpha で塗った色です。
+	c := img.RGBAAt(30, 45)
+	wantAlpha := uint8(255.0 * DefaultAlpha)
+	if c.A != wantAlpha || c.B <= c.R {
+		t.Errorf("color at the fixation = %v, want %v with alpha %d", c, FixationColor, wantAlpha)
+	}
+	// 長い注視の円の方が大きくなります。
+	if img.RGBAAt(30 + 25, 30).A == 0 {
+		t.Errorf("circle of the long fixation is too small")
+	}
+	if img.RGBAAt(150 + 25, 30).A != 0 {
+		t.Errorf("circle of the short fixation is too large")
+	}
+	if img.RGBAAt(90, 30).A == 0 {
+		t.Errorf("no saccade line between the fixations")
+	}
+	if img.RGBAAt(20, 140).A == 0 || img.RGBAAt(50, 140).A != 0 {
+		t.Errorf("AOI outline is not drawn only on the edge")
+	}
+	if img.RGBAAt(190, 190).A != 0 {
+		t.Errorf("pixel far from the scanpath is not transparent")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		config Config
+		ok bool
+	}{
+		{"default", Config{}, true},
+		{"radius", Config{MinRadius: 5, MaxRadius: 20}, true},
+		{"negative radius", Config{MinRadius: -1}, false},
+		{"max smaller than min", Config{MinRadius: 20, MaxRadius: 10}, false},
+		{"alpha over 1", Config{Alpha: 2}, false},
+		{"unknown color map", Config{ColorMap: "rainbow"}, false},
+	}
+	for _, test := range tests {
+		err := test.config.Validate()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Validate() = %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// eyebit server とこのブラウザの時計のずれを NTP と同じやり方で測って、サーバに報告します。
+// server は "https://localhost:8888" のようなサーバの URL、count は往復の回数です。
+// 一番速く往復できた時の値を使います。done(report) があれば、報告した値を渡して呼びます。
+function EyebitClockSync(server, count, done){
+    var best = null;
+    var n = 0;
+    function report(){
+	if(best == null){
+	    return;
+	}
+	best.samples = n;
+	var xhr = new XMLHttpRequest();
+	xhr.open("POST", server + "/clock/report.json");
+	xhr.setRequestHeader("Content-Type", "application/json");
+	xhr.send(JSON.stringify(best));
+	if(done){
+	    done(best);
+	}
+    }
+    function ping(){
+	if(n >= count){
+	    report();
+	    return;
+	}
+	var xhr = new XMLHttpRequest();
+	var clientSend = Date.now();
+	xhr.open("GET", server + "/clock/ping.json?client_send=" + clientSend);
+	xhr.onload = function(){
+	    var clientReceive = Date.now();
+	    var result = JSON.parse(xhr.responseText);
+	    var offset = ((result["server receive"] - clientSend) + (result["server send"] - clientReceive)) / 2;
+	    var roundTrip = (clientReceive - clientSend) - (result["server send"] - result["server receive"]);
+	    n += 1;
+	    if(best == null || roundTrip < best["round trip msec"]){
+		best = {"offset msec": offset, "round trip msec": roundTrip};
+	    }
+	    setTimeout(ping, 50);
+	};
+	xhr.onerror = function(){
+	    n += 1;
+	    setTimeout(ping, 50);
+	};
+	xhr.send();
+    }
+    ping();
+}
@@ -0,0 +1,57 @@
+// ページの表示の様子(画面上の位置, 大きさ, scroll, zoom)を eyebit server に報告します。
+// 読み込んだ時と、scroll や大きさが変わった時に送るので、視線をページ上の位置に直せるようになります。
+// server は "https://localhost:8888" のようなサーバの URL です。
+function EyebitViewport(server){
+    var timer = null;
+    var last = "";
+    function state(){
+	var zoom = window.devicePixelRatio || 1;
+	var contentX, contentY;
+	if(window.mozInnerScreenX !== undefined){
+	    // Firefox はページの表示されている所の画面上の位置を教えてくれます。
+	    contentX = window.mozInnerScreenX * zoom;
+	    contentY = window.mozInnerScreenY * zoom;
+	}else{
+	    // 枠の太さは左右と下で同じ、残りは上の URL 欄等とみなします。
+	    var border = (window.outerWidth - window.innerWidth) / 2;
+	    contentX = (window.screenX + border) * zoom;
+	    contentY = (window.screenY + window.outerHeight - window.innerHeight - border) * zoom;
+	}
+	var doc = document.documentElement;
+	return {
+	    "url": location.href,
+	    "content x": contentX,
+	    "content y": contentY,
+	    "width": window.innerWidth,
+	    "height": window.innerHeight,
+	    "scroll x": window.pageXOffset,
+	    "scroll y": window.pageYOffset,
+	    "zoom": zoom,
+	    "document width": doc.scrollWidth,
+	    "document height": doc.scrollHeight
+	};
+    }
+    function send(){
+	timer = null;
+	var body = JSON.stringify(state());
+	if(body == last){
+	    return;
+	}
+	last = body;
+	var xhr = new XMLHttpRequest();
+	xhr.open("POST", server + "/viewport.json");
+	xhr.setRequestHeader("Content-Type", "application/json");
+	xhr.send(body);
+    }
+    function changed(){
+	// scroll 中は 100 ミリ秒に一度だけ送ります。
+	if(timer == null){
+	    timer = setTimeout(send, 100);
+	}
+    }
+    window.addEventListener("scroll", changed);
+    window.addEventListener("resize", changed);
+    // ウィンドウを動かしてもイベントは来ないので、時々確かめます。
+    setInterval(changed, 1000);
+    send();
+}
@@ -0,0 +1,115 @@
+package viewport
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ブラウザがページをどこにどう表示しているか
+// 画面上の位置(ContentX, ContentY)は画面のピクセル、それ以外はページの CSS ピクセルです。
+type State struct {
+	Url string `json:"url,omitempty"` // location.href
+	ContentX float64 `json:"content x"` // ページを表示している所(viewport)の左上の、画面上の位置
+	ContentY float64 `json:"content y"`
+	Width float64 `json:"width"` // viewport の大きさ(innerWidth, innerHeight)
+	Height float64 `json:"height"`
+	ScrollX float64 `json:"scroll x"` // scrollX, scrollY
+	ScrollY float64 `json:"scroll y"`
+	Zoom float64 `json:"zoom"` // CSS ピクセル一つが画面の何ピクセルか(devicePixelRatio)。0 なら 1 とします
+	DocumentWidth float64 `json:"document width,omitempty"` // ページ全体の大きさ(scrollWidth, scrollHeight)
+	DocumentHeight float64 `json:"document height,omitempty"`
+	Time time.Time `json:"time"` // サーバが受け取った時刻
+}
+
+// 設定に誤りが無いかを確認します。
+func (s *State) Validate() error {
+	if s.Width <= 0 || s.Height <= 0 {
+		return errors.New("viewport width and height must be positive")
+	}
+	if s.Zoom < 0 {
+		return errors.New("viewport zoom must not be negative")
+	}
+	return nil
+}
+
+func (s *State) zoom() float64 {
+	if s.Zoom <= 0 {
+		return 1.0
+	}
+	return s.Zoom
+}
+
+// 画面上の (x, y) をページ(document)の座標にします。
+// inside は (x, y) が viewport の中(ページが見えている所)にあるかどうかです。
+func (s *State) Map(x float64, y float64) (pageX float64, pageY float64, inside bool) {
+	zoom := s.zoom()
+	clientX := (x - s.ContentX) / zoom
+	clientY := (y - s.ContentY) / zoom
+	inside = clientX >= 0 && clientX < s.Width && clientY >= 0 && clientY < s.Height
+	return clientX + s.ScrollX, clientY + s.ScrollY, inside
+}
+
+// ページ全体の大きさを返します。分からなければ viewport の右下までの大きさを返します。
+func (s *State) DocumentSize() (float64, float64) {
+	width := s.DocumentWidth
+	if width <= 0 {
+		width = s.ScrollX + s.Width
+	}
+	height := s.DocumentHeight
+	if height <= 0 {
+		height = s.ScrollY + s.Height
+	}
+	return width, height
+}
+
+// ページの表示の移り変わり
+// 報告された State を時刻の順に覚えておき、ある時刻にどう表示していたかを返します。
+type History struct {
+	lock sync.RWMutex
+	stateList []State
+	maxStates int
+}
+
+// maxStates 個まで覚えておく History を作ります。
+func NewHistory(maxStates int) *History {
+	return &History{maxStates: maxStates}
+}
+
+// 報告された State を一つ追加します。古すぎるものは忘れます。
+// 時刻が前のものより前なら、前のものの時刻にします。
+func (h *History) Add(state State) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.stateList) > 0 && state.Time.Before(h.stateList[len(h.stateList) - 1].Time) {
+		state.Time = h.stateList[len(h.stateList) - 1].Time
+	}
+	h.stateList = append(h.stateList, state)
+	if h.maxStates > 0 && len(h.stateList) > h.maxStates {
+		h.stateList = append([]State{}, h.stateList[len(h.stateList) - h.maxStates:]...)
+	}
+}
+
+// 時刻 t の表示を返します。分からなければ false を返します。
+func (h *History) At(t time.Time) (State, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	i := sort.Search(len(h.stateList), func(i int) bool {
+		return h.stateList[i].Time.After(t)
+	})
+	if i <= 0 {
+		return State{}, false
+	}
+	return h.stateList[i - 1], true
+}
+
+// 最後に報告された表示を返します。
+func (h *History) Current() (State, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if len(h.stateList) <= 0 {
+		return State{}, false
+	}
+	return h.stateList[len(h.stateList) - 1], true
+}
@@ -0,0 +1,105 @@
+package viewport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMap(t *testing.T) {
+	// 画面の (0, 100) から 1000x600 の viewport に、ページを (0, 2000) までスクロールして表示しています。
+	scrolled := State{ContentX: 0, ContentY: 100, Width: 1000, Height: 600, ScrollY: 2000}
+	// 200% に拡大すると、画面の 2 ピクセルがページの 1 ピクセルになります。
+	zoomed := State{ContentX: 10, ContentY: 100, Width: 500, Height: 300, ScrollX: 50, ScrollY: 400, Zoom: 2}
+	tests := []struct {
+		name string
+		state State
+		x float64
+		y float64
+		pageX float64
+		pageY float64
+		inside bool
+	}{
+		{"top left of the viewport", scrolled, 0, 100, 0, 2000, true},
+		{"scrolled", scrolled, 500, 400, 500, 2300, true},
+		{"browser toolbar above the viewport", scrolled, 500, 50, 500, 1950, false},
+		{"right edge is outside", scrolled, 1000, 400, 1000, 2300, false},
+		{"below the viewport", scrolled, 500, 700, 500, 2600, false},
+		{"zoomed", zoomed, 210, 300, 150, 500, true},
+		{"zoomed outside", zoomed, 1100, 300, 595, 500, false},
+		{"zero zoom is 1", State{Width: 100, Height: 100}, 50, 50, 50, 50, true},
+	}
+	for _, test := range tests {
+		pageX, pageY, inside := test.state.Map(test.x, test.y)
+		if pageX != test.pageX || pageY != test.pageY || inside != test.inside {
+			t.Errorf("%s: Map(%f, %f) = %f, %f, %v, want %f, %f, %v", test.name, test.x, test.y, pageX, pageY, inside, test.pageX, test.pageY, test.inside)
+		}
+	}
+}
+
+func TestDocumentSize(t *testing.T) {
+	tests := []struct {
+		state State
+		width float64
+		height float64
+	}{
+		{State{Width: 1000, Height: 600, DocumentWidth: 1200, DocumentHeight: 5000}, 1200, 5000},
+		{State{Width: 1000, Height: 600, ScrollX: 10, ScrollY: 2000}, 1010, 2600},
+	}
+	for _, test := range tests {
+		width, height := test.state.DocumentSize()
+		if width != test.width || height != test.height {
+			t.Errorf("%+v: DocumentSize() = %f, %f, want %f, %f", test.state, width, height, test.width, test.height)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		state State
+		ok bool
+	}{
+		{State{Width: 100, Height: 100}, true},
+		{State{Width: 0, Height: 100}, false},
+		{State{Width: 100, Height: 100, Zoom: -1}, false},
+	}
+	for _, test := range tests {
+		err := test.state.Validate()
+		if (err == nil) != test.ok {
+			t.Errorf("%+v: Validate() = %v, want ok = %v", test.state, err, test.ok)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	base := time.Date(2016, 10, 16, 7, 0, 0, 0, time.UTC)
+	h := NewHistory(3)
+	if _, ok := h.Current(); ok {
+		t.Fatalf("empty history should have no current state")
+	}
+	for i := 0; i < 4; i++ {
+		h.Add(State{Width: 100, Height: 100, ScrollY: float64(i) * 100, Time: base.Add(time.Duration(i) * time.Second)})
+	}
+	// 時刻が戻ったものは、前のものの時刻にします。
+	h.Add(State{Width: 100, Height: 100, ScrollY: 400, Time: base})
+	tests := []struct {
+		offset time.Duration
+		scrollY float64
+		ok bool
+	}{
+		{1500 * time.Millisecond, 0, false}, // 一番古い二つは忘れています
+		{2 * time.Second, 200, true},
+		{2500 * time.Millisecond, 200, true},
+		{3 * time.Second, 400, true},
+		{time.Hour, 400, true},
+	}
+	for _, test := range tests {
+		state, ok := h.At(base.Add(test.offset))
+		if ok != test.ok || (ok && state.ScrollY != test.scrollY) {
+			t.Errorf("At(+%s) = %+v, %v, want scroll y %f, %v", test.offset, state, ok, test.scrollY, test.ok)
+		}
+	}
+	current, _ := h.Current()
+	if current.ScrollY != 400 || !current.Time.Equal(base.Add(3 * time.Second)) {
+		t.Errorf("Current() = %+v", current)
+	}
+}